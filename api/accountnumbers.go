@@ -0,0 +1,261 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+)
+
+const (
+	// StepUpCodeTTL is how long a step-up code can be used after it is sent.
+	StepUpCodeTTL     = 5 * time.Minute
+	StepUpMaxAttempts = 5
+	StepUpCodeDigits  = 6
+
+	AccessActionChallenge = "challenge"
+	AccessActionView      = "view"
+	AccessGranted         = "granted"
+	AccessDenied          = "denied"
+)
+
+type AccountNumbersChallengeRequest struct {
+	UserId  string `json:"userId" binding:"required"`
+	TrackId string `json:"plaidTrackId" binding:"required"`
+}
+
+type AccountNumbersRequest struct {
+	UserId      string `json:"userId" binding:"required"`
+	TrackId     string `json:"plaidTrackId" binding:"required"`
+	ChallengeId string `json:"challengeId" binding:"required"`
+	Code        string `json:"code" binding:"required"`
+	Reveal      bool   `json:"reveal"`
+}
+
+type AccountNumbers struct {
+	TrackId     string `json:"plaidTrackId"`
+	AccountMask string `json:"accountMask"`
+	Account     string `json:"account"`
+	Routing     string `json:"routing"`
+	WireRouting string `json:"wireRouting,omitempty"`
+	Revealed    bool   `json:"revealed"`
+}
+
+// maskNumber keeps the last four digits of an account or routing number.
+func maskNumber(number string) string {
+	if len(number) <= 4 {
+		return strings.Repeat("*", len(number))
+	}
+	return strings.Repeat("*", len(number)-4) + number[len(number)-4:]
+}
+
+func newStepUpCode() (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(StepUpCodeDigits), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", fmt.Errorf("error while generating step-up code: %v", err.Error())
+	}
+	return fmt.Sprintf("%0*d", StepUpCodeDigits, n), nil
+}
+
+// hashStepUpCode binds the code to its challenge so equal codes do not hash
+// alike.
+func hashStepUpCode(challengeId string, code string) string {
+	mac := hmac.New(sha256.New, []byte(IdentityHashKey))
+	mac.Write([]byte(challengeId + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func auditAccountNumberAccess(c *gin.Context, access db.AccountNumberAccess) {
+	access.AccessId = utils.GenerateId("ACCESS")
+	access.ClientIp = c.ClientIP()
+	access.UserAgent = c.Request.UserAgent()
+	access.CreatedAt = time.Now()
+	if err := db.AddAccountNumberAccess(PgDb, access); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// ownedLinkedBank loads the bank for a track id when it belongs to the user
+// and was linked through Plaid for funding, which is the only way to get its
+// numbers.
+func ownedLinkedBank(userId string, trackId string) (db.PlaidUser, int, string) {
+	bank, err := db.GetRecordUsingTrackId(PgDb, trackId)
+	if err != nil || bank.UserId != userId {
+		return db.PlaidUser{}, http.StatusNotFound, "bank not found for user"
+	}
+	if IsManualBank(bank) || !IsFundingBank(bank) {
+		return db.PlaidUser{}, http.StatusBadRequest, "account numbers are only available for funding banks linked through plaid"
+	}
+	return bank, http.StatusOK, ""
+}
+
+// CreateAccountNumbersChallenge sends the owner a one-time code through
+// StepUpCodeHook. The code must be presented to GetAccountNumbers.
+func CreateAccountNumbersChallenge(c *gin.Context) {
+	var challengeReq AccountNumbersChallengeRequest
+	if err := c.ShouldBindJSON(&challengeReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	access := db.AccountNumberAccess{UserId: challengeReq.UserId, TrackId: challengeReq.TrackId, Action: AccessActionChallenge}
+
+	if _, status, reason := ownedLinkedBank(challengeReq.UserId, challengeReq.TrackId); status != http.StatusOK {
+		access.Outcome, access.Reason = AccessDenied, reason
+		auditAccountNumberAccess(c, access)
+		c.JSON(status, gin.H{"error": reason})
+		return
+	}
+
+	code, err := newStepUpCode()
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	challenge := db.AuthChallenge{
+		ChallengeId: utils.GenerateId("CHALLENGE"),
+		UserId:      challengeReq.UserId,
+		TrackId:     challengeReq.TrackId,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(StepUpCodeTTL),
+	}
+	challenge.CodeHash = hashStepUpCode(challenge.ChallengeId, code)
+	if err := db.AddAuthChallenge(PgDb, challenge); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = StepUpCodeHook(NotificationEvent{
+		Type:   EventStepUpCodeIssued,
+		UserId: challenge.UserId,
+		Payload: map[string]interface{}{
+			"challengeId": challenge.ChallengeId,
+			"code":        code,
+			"purpose":     "account_numbers",
+			"expiresAt":   challenge.ExpiresAt,
+		},
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Println("unable to deliver step-up code: " + err.Error())
+		access.ChallengeId, access.Outcome, access.Reason = challenge.ChallengeId, AccessDenied, "verification code could not be delivered"
+		auditAccountNumberAccess(c, access)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "verification code could not be delivered"})
+		return
+	}
+	access.ChallengeId, access.Outcome = challenge.ChallengeId, AccessGranted
+	auditAccountNumberAccess(c, access)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification Code Sent", "challengeId": challenge.ChallengeId, "expiresAt": challenge.ExpiresAt})
+}
+
+// GetAccountNumbers returns the ACH numbers of the user's own linked bank
+// once the step-up code checks out. Numbers are fetched from Plaid on every
+// call, masked unless reveal is set, and never stored.
+func GetAccountNumbers(c *gin.Context) {
+	var numbersReq AccountNumbersRequest
+	if err := c.ShouldBindJSON(&numbersReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	access := db.AccountNumberAccess{
+		UserId:      numbersReq.UserId,
+		TrackId:     numbersReq.TrackId,
+		ChallengeId: numbersReq.ChallengeId,
+		Action:      AccessActionView,
+		Revealed:    numbersReq.Reveal,
+	}
+	deny := func(status int, reason string) {
+		access.Outcome, access.Reason = AccessDenied, reason
+		auditAccountNumberAccess(c, access)
+		c.JSON(status, gin.H{"error": reason})
+	}
+
+	bank, status, reason := ownedLinkedBank(numbersReq.UserId, numbersReq.TrackId)
+	if status != http.StatusOK {
+		deny(status, reason)
+		return
+	}
+
+	now := time.Now()
+	challenge, ok, err := db.AttemptAuthChallenge(PgDb, numbersReq.ChallengeId, StepUpMaxAttempts, now)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok || challenge.UserId != numbersReq.UserId || challenge.TrackId != numbersReq.TrackId {
+		deny(http.StatusForbidden, "verification code is invalid, expired or used")
+		return
+	}
+	if !hmac.Equal([]byte(challenge.CodeHash), []byte(hashStepUpCode(challenge.ChallengeId, numbersReq.Code))) {
+		deny(http.StatusForbidden, fmt.Sprintf("incorrect verification code, %d attempts left", StepUpMaxAttempts-challenge.Attempts))
+		return
+	}
+	if used, err := db.UseAuthChallenge(PgDb, challenge.ChallengeId, now); err != nil || !used {
+		deny(http.StatusForbidden, "verification code is invalid, expired or used")
+		return
+	}
+
+	ach, err := GetAuthNumbers(bank.AccessToken, bank.AccountId)
+	if err != nil {
+		log.Println(err.Error())
+		access.Outcome, access.Reason = AccessDenied, "numbers unavailable from plaid"
+		auditAccountNumberAccess(c, access)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "account numbers are unavailable for this bank"})
+		return
+	}
+	numbers := AccountNumbers{
+		TrackId:     bank.TrackId,
+		AccountMask: bank.AccountMask,
+		Account:     ach.GetAccount(),
+		Routing:     ach.GetRouting(),
+		WireRouting: ach.GetWireRouting(),
+		Revealed:    numbersReq.Reveal,
+	}
+	if !numbersReq.Reveal {
+		numbers.Account = maskNumber(numbers.Account)
+		numbers.Routing = maskNumber(numbers.Routing)
+		numbers.WireRouting = maskNumber(numbers.WireRouting)
+	}
+	access.Outcome = AccessGranted
+	auditAccountNumberAccess(c, access)
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"data": numbers})
+}
+
+// GetAccountNumberAccesses lists every request made for the user's account
+// numbers, newest first.
+func GetAccountNumberAccesses(c *gin.Context) {
+	var userReq BankUserId
+	if err := c.ShouldBindJSON(&userReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	accesses, err := db.GetAccountNumberAccessesUsingUserId(PgDb, userReq.UserId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": accesses})
+}
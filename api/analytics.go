@@ -0,0 +1,197 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"gorm.io/gorm"
+)
+
+var (
+	DefaultAnalyticsWindowDays = 30
+	DefaultAnalyticsMonths     = 6
+	DefaultTopMerchants        = 10
+)
+
+type AnalyticsRequest struct {
+	UserId    string `json:"userId" binding:"required"`
+	TrackId   string `json:"plaidTrackId"`
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	Limit     int    `json:"limit"`
+}
+
+type MonthComparison struct {
+	db.PeriodSummary
+	Net          float64  `json:"net"`
+	SpendChange  *float64 `json:"spendChange"`
+	IncomeChange *float64 `json:"incomeChange"`
+}
+
+// BuildAnalyticsFilter validates the request window and, when a track id is
+// given, resolves it to the Plaid account owned by the user.
+func BuildAnalyticsFilter(bankdb *gorm.DB, request AnalyticsRequest, defaultStart time.Time) (db.AnalyticsFilter, error) {
+	dateFormat := "2006-01-02"
+	filter := db.AnalyticsFilter{
+		UserId:    request.UserId,
+		StartDate: defaultStart.Format(dateFormat),
+		EndDate:   time.Now().Format(dateFormat),
+	}
+	if len(request.StartDate) > 0 {
+		if _, err := time.Parse(dateFormat, request.StartDate); err != nil {
+			return db.AnalyticsFilter{}, fmt.Errorf("invalid start date: %v", err.Error())
+		}
+		filter.StartDate = request.StartDate
+	}
+	if len(request.EndDate) > 0 {
+		if _, err := time.Parse(dateFormat, request.EndDate); err != nil {
+			return db.AnalyticsFilter{}, fmt.Errorf("invalid end date: %v", err.Error())
+		}
+		filter.EndDate = request.EndDate
+	}
+	if filter.StartDate > filter.EndDate {
+		return db.AnalyticsFilter{}, fmt.Errorf("start date %s is after end date %s", filter.StartDate, filter.EndDate)
+	}
+
+	if len(request.TrackId) > 0 {
+		bankDetails, err := db.GetRecordUsingTrackId(bankdb, request.TrackId)
+		if err != nil || bankDetails.UserId != request.UserId {
+			return db.AnalyticsFilter{}, fmt.Errorf("no account %s found for user", request.TrackId)
+		}
+		filter.TrackId = bankDetails.TrackId
+		filter.AccountId = bankDetails.AccountId
+	}
+	return filter, nil
+}
+
+func GetCategoryAnalytics(c *gin.Context) {
+	var request AnalyticsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	filter, err := BuildAnalyticsFilter(PgDb, request, time.Now().AddDate(0, 0, -DefaultAnalyticsWindowDays))
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	categories, err := db.GetSpendByCategory(PgDb, filter)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var totalSpend, totalIncome float64
+	for i, eachCategory := range categories {
+		categories[i].Spend = roundAmount(eachCategory.Spend)
+		categories[i].Income = roundAmount(eachCategory.Income)
+		totalSpend += eachCategory.Spend
+		totalIncome += eachCategory.Income
+	}
+
+	c.JSON(http.StatusOK, gin.H{"startDate": filter.StartDate, "endDate": filter.EndDate, "categories": categories, "totalSpend": roundAmount(totalSpend), "totalIncome": roundAmount(totalIncome)})
+}
+
+func GetMerchantAnalytics(c *gin.Context) {
+	var request AnalyticsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	filter, err := BuildAnalyticsFilter(PgDb, request, time.Now().AddDate(0, 0, -DefaultAnalyticsWindowDays))
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultTopMerchants
+	}
+
+	merchants, err := db.GetTopMerchants(PgDb, filter, limit)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i, eachMerchant := range merchants {
+		merchants[i].Spend = roundAmount(eachMerchant.Spend)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"startDate": filter.StartDate, "endDate": filter.EndDate, "merchants": merchants})
+}
+
+func GetMonthlyAnalytics(c *gin.Context) {
+	var request AnalyticsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	now := time.Now()
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	filter, err := BuildAnalyticsFilter(PgDb, request, firstOfMonth.AddDate(0, -(DefaultAnalyticsMonths-1), 0))
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	periods, err := db.GetSpendByMonth(PgDb, filter)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"startDate": filter.StartDate, "endDate": filter.EndDate, "months": CompareMonths(periods)})
+}
+
+// CompareMonths adds the net amount and the percentage change against the
+// previous month. Changes are left nil when there is nothing to compare with.
+func CompareMonths(periods []db.PeriodSummary) []MonthComparison {
+	comparisons := make([]MonthComparison, 0, len(periods))
+	for i, eachPeriod := range periods {
+		comparison := MonthComparison{
+			PeriodSummary: db.PeriodSummary{
+				Period: eachPeriod.Period,
+				Spend:  roundAmount(eachPeriod.Spend),
+				Income: roundAmount(eachPeriod.Income),
+			},
+			Net: roundAmount(eachPeriod.Income - eachPeriod.Spend),
+		}
+		if i > 0 {
+			comparison.SpendChange = percentChange(periods[i-1].Spend, eachPeriod.Spend)
+			comparison.IncomeChange = percentChange(periods[i-1].Income, eachPeriod.Income)
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons
+}
+
+func percentChange(previous float64, current float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := roundAmount((current - previous) / previous * 100)
+	return &change
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,401 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	SplitEqual      = "equal"
+	SplitPercentage = "percentage"
+	SplitExact      = "exact"
+
+	BillOpen          = "open"
+	BillPartiallyPaid = "partially_paid"
+	BillSettled       = "settled"
+	// BillUnsettled means nothing is left to pay but some shares were
+	// declined or expired.
+	BillUnsettled = "unsettled"
+
+	ShareUnpaid = "unpaid"
+	SharePaid   = "paid"
+
+	MaxBillParticipants = 50
+)
+
+type BillParticipant struct {
+	ShareableId string `json:"sharableId" binding:"required"`
+	Percent     string `json:"percent"`
+	Amount      string `json:"amount"`
+}
+
+type BillSplitRequest struct {
+	UserId       string            `json:"userId" binding:"required"`
+	TrackId      string            `json:"plaidTrackId" binding:"required"`
+	TotalAmount  string            `json:"totalAmount" binding:"required"`
+	Note         string            `json:"note"`
+	SplitType    string            `json:"splitType" binding:"required"`
+	Participants []BillParticipant `json:"participants" binding:"required,dive"`
+}
+
+type BillSplitLookupRequest struct {
+	UserId string `json:"userId" binding:"required"`
+	BillId string `json:"billId"`
+}
+
+type BillShareStatus struct {
+	db.BillShare
+	Status string `json:"status"`
+}
+
+type BillSettlement struct {
+	db.BillSplit
+	Status            string            `json:"status"`
+	PaidAmount        string            `json:"paidAmount"`
+	OutstandingAmount string            `json:"outstandingAmount"`
+	Shares            []BillShareStatus `json:"shares"`
+}
+
+// parseCents reads a non-negative amount with at most two decimals as a whole
+// number of cents. Percentages use the same form, giving basis points.
+func parseCents(value string) (int64, error) {
+	if !amountPattern.MatchString(value) {
+		return 0, fmt.Errorf("%q must be a non-negative value with at most two decimals", value)
+	}
+	whole, fraction, _ := strings.Cut(value, ".")
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+	cents, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is out of range", value)
+	}
+	return cents, nil
+}
+
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+// SplitBill works out each participant's share in cents so the shares always
+// add up to the total exactly.
+//
+// Equal splits give every participant the same number of cents and hand the
+// leftover cents, one each, to participants in list order. Percentage splits
+// round every share down and give the leftover cents, one each, to the
+// participants with the largest remainders, ties going to the earlier one.
+// Exact splits must already add up to the total.
+func SplitBill(totalCents int64, splitType string, participants []BillParticipant) ([]int64, error) {
+	if len(participants) == 0 {
+		return nil, errors.New("a bill needs at least one participant")
+	}
+	// Percentage shares multiply the total by up to 10000 basis points.
+	if totalCents <= 0 || totalCents > math.MaxInt64/10000 {
+		return nil, errors.New("total is out of range")
+	}
+	shares := make([]int64, len(participants))
+	count := int64(len(participants))
+	switch splitType {
+	case SplitEqual:
+		for i := range shares {
+			shares[i] = totalCents / count
+			if int64(i) < totalCents%count {
+				shares[i]++
+			}
+		}
+
+	case SplitPercentage:
+		var totalBasisPoints int64
+		remainders := make([]int64, len(participants))
+		for i, participant := range participants {
+			basisPoints, err := parseCents(participant.Percent)
+			if err != nil {
+				return nil, fmt.Errorf("invalid percent for participant %d: %v", i+1, err.Error())
+			}
+			if basisPoints > 10000 {
+				return nil, fmt.Errorf("percent for participant %d must be at most 100", i+1)
+			}
+			totalBasisPoints += basisPoints
+			shares[i] = totalCents * basisPoints / 10000
+			remainders[i] = totalCents * basisPoints % 10000
+		}
+		if totalBasisPoints != 10000 {
+			return nil, errors.New("percentages must add up to 100")
+		}
+		var allocated int64
+		for _, share := range shares {
+			allocated += share
+		}
+		order := make([]int, len(participants))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return remainders[order[a]] > remainders[order[b]]
+		})
+		for i := int64(0); i < totalCents-allocated; i++ {
+			shares[order[i]]++
+		}
+
+	case SplitExact:
+		var allocated int64
+		for i, participant := range participants {
+			cents, err := parseCents(participant.Amount)
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount for participant %d: %v", i+1, err.Error())
+			}
+			shares[i] = cents
+			allocated += cents
+		}
+		if allocated != totalCents {
+			return nil, fmt.Errorf("amounts add up to %s, not the total %s", formatCents(allocated), formatCents(totalCents))
+		}
+
+	default:
+		return nil, fmt.Errorf("splitType must be one of %s, %s or %s", SplitEqual, SplitPercentage, SplitExact)
+	}
+	return shares, nil
+}
+
+// shareStatus reads a share's progress from its payment request.
+func shareStatus(share db.BillShare, requests map[string]db.PaymentRequest) string {
+	if len(share.RequestId) == 0 {
+		return SharePaid
+	}
+	request, ok := requests[share.RequestId]
+	if !ok {
+		return ShareUnpaid
+	}
+	if request.Status == PaymentRequestAccepted {
+		return SharePaid
+	}
+	return request.Status
+}
+
+// GetBillSettlements reports how far each bill has been paid.
+func GetBillSettlements(bankdb *gorm.DB, bills []db.BillSplit) ([]BillSettlement, error) {
+	settlements := []BillSettlement{}
+	if len(bills) == 0 {
+		return settlements, nil
+	}
+	billIds := make([]string, len(bills))
+	for i, bill := range bills {
+		billIds[i] = bill.BillId
+	}
+	shares, err := db.GetBillSharesUsingBillIds(bankdb, billIds)
+	if err != nil {
+		return nil, err
+	}
+	requestList, err := db.GetPaymentRequestsUsingBillIds(bankdb, billIds)
+	if err != nil {
+		return nil, err
+	}
+	requests := make(map[string]db.PaymentRequest, len(requestList))
+	for _, request := range requestList {
+		requests[request.RequestId] = request
+	}
+	sharesByBill := make(map[string][]db.BillShare)
+	for _, share := range shares {
+		sharesByBill[share.BillId] = append(sharesByBill[share.BillId], share)
+	}
+
+	for _, bill := range bills {
+		settlement := BillSettlement{BillSplit: bill, Shares: []BillShareStatus{}}
+		var paid, outstanding int64
+		var open, unpaidClosed, paidByOthers bool
+		for _, share := range sharesByBill[bill.BillId] {
+			status := shareStatus(share, requests)
+			settlement.Shares = append(settlement.Shares, BillShareStatus{BillShare: share, Status: status})
+			cents, _ := parseCents(share.Amount)
+			switch status {
+			case SharePaid:
+				paid += cents
+				if len(share.RequestId) > 0 {
+					paidByOthers = true
+				}
+			case PaymentRequestDeclined, PaymentRequestExpired:
+				outstanding += cents
+				unpaidClosed = true
+			default:
+				outstanding += cents
+				open = true
+			}
+		}
+		switch {
+		case outstanding == 0:
+			settlement.Status = BillSettled
+		case open && paidByOthers:
+			settlement.Status = BillPartiallyPaid
+		case open:
+			settlement.Status = BillOpen
+		case unpaidClosed:
+			settlement.Status = BillUnsettled
+		}
+		settlement.PaidAmount = formatCents(paid)
+		settlement.OutstandingAmount = formatCents(outstanding)
+		settlements = append(settlements, settlement)
+	}
+	return settlements, nil
+}
+
+// CreateBillSplit splits a bill and sends each participant a payment request
+// for their share. The creator may list their own bank as a participant;
+// that share counts as paid and is not requested.
+func CreateBillSplit(c *gin.Context) {
+	var billReq BillSplitRequest
+	if err := c.ShouldBindJSON(&billReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if len(billReq.Participants) == 0 || len(billReq.Participants) > MaxBillParticipants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a bill needs 1 to %d participants", MaxBillParticipants)})
+		return
+	}
+	totalCents, err := parseCents(billReq.TotalAmount)
+	if err != nil || totalCents <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("totalAmount %q must be a positive value with at most two decimals", billReq.TotalAmount)})
+		return
+	}
+	if totalCents > int64(math.Round(GlobalTransferLimit.MaxPerTransfer*100)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("totalAmount exceeds the transfer limit of %.2f", GlobalTransferLimit.MaxPerTransfer)})
+		return
+	}
+	if len(billReq.Note) > MaxPaymentRequestNoteLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("note must be at most %d characters", MaxPaymentRequestNoteLength)})
+		return
+	}
+	shareCents, err := SplitBill(totalCents, billReq.SplitType, billReq.Participants)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	creatorBank, err := db.GetRecordUsingTrackId(PgDb, billReq.TrackId)
+	if err != nil || creatorBank.UserId != billReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no account found for user"})
+		return
+	}
+
+	now := time.Now()
+	bill := db.BillSplit{
+		BillId:         utils.GenerateId("BILL"),
+		CreatorId:      billReq.UserId,
+		CreatorTrackId: creatorBank.TrackId,
+		TotalAmount:    formatCents(totalCents),
+		Note:           billReq.Note,
+		SplitType:      billReq.SplitType,
+		CreatedAt:      now,
+	}
+	// Requests in one bill share an id base so they cannot collide.
+	requestIdBase := utils.GenerateId("PAYREQ")
+	var shares []db.BillShare
+	var requests []db.PaymentRequest
+	seenUsers := make(map[string]bool)
+	for i, participant := range billReq.Participants {
+		participantBank, err := ResolveShareableId(PgDb, participant.ShareableId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown participant %d", i+1)})
+			return
+		}
+		if seenUsers[participantBank.UserId] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("participant %d is listed more than once", i+1)})
+			return
+		}
+		seenUsers[participantBank.UserId] = true
+
+		share := db.BillShare{
+			ShareId:     fmt.Sprintf("%s-%d", bill.BillId, i+1),
+			BillId:      bill.BillId,
+			UserId:      participantBank.UserId,
+			ShareableId: participant.ShareableId,
+			Amount:      formatCents(shareCents[i]),
+		}
+		if billReq.SplitType == SplitPercentage {
+			share.Percent = participant.Percent
+		}
+		if participantBank.UserId != billReq.UserId {
+			if shareCents[i] == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("participant %d would owe nothing", i+1)})
+				return
+			}
+			request := NewPaymentRequest(creatorBank, participantBank, participant.ShareableId, share.Amount, billReq.Note, now)
+			request.RequestId = fmt.Sprintf("%s-%d", requestIdBase, i+1)
+			request.BillId = bill.BillId
+			share.RequestId = request.RequestId
+			requests = append(requests, request)
+		}
+		shares = append(shares, share)
+	}
+	if len(requests) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a bill needs at least one participant other than the creator"})
+		return
+	}
+
+	if err := db.AddBillSplit(PgDb, bill, shares, requests); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, request := range requests {
+		Notify(EventPaymentRequestCreated, request.PayerId, paymentRequestPayload(request))
+	}
+
+	settlements, err := GetBillSettlements(PgDb, []db.BillSplit{bill})
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Bill Split Created Successfully", "data": settlements[0]})
+}
+
+// GetBillSplits returns the settlement status of one bill when billId is set,
+// otherwise of every bill the user created.
+func GetBillSplits(c *gin.Context) {
+	var lookupReq BillSplitLookupRequest
+	if err := c.ShouldBindJSON(&lookupReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	ExpirePaymentRequests(PgDb, time.Now())
+	var bills []db.BillSplit
+	if len(lookupReq.BillId) > 0 {
+		bill, err := db.GetBillSplitUsingId(PgDb, lookupReq.BillId)
+		if err != nil || bill.CreatorId != lookupReq.UserId {
+			c.JSON(http.StatusNotFound, gin.H{"error": "bill not found for user"})
+			return
+		}
+		bills = []db.BillSplit{bill}
+	} else {
+		var err error
+		bills, err = db.GetBillSplitsUsingCreatorId(PgDb, lookupReq.UserId)
+		if err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	settlements, err := GetBillSettlements(PgDb, bills)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": settlements})
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSplitBill(t *testing.T) {
+	percents := func(values ...string) []BillParticipant {
+		participants := make([]BillParticipant, len(values))
+		for i, value := range values {
+			participants[i] = BillParticipant{Percent: value}
+		}
+		return participants
+	}
+	amounts := func(values ...string) []BillParticipant {
+		participants := make([]BillParticipant, len(values))
+		for i, value := range values {
+			participants[i] = BillParticipant{Amount: value}
+		}
+		return participants
+	}
+
+	tests := []struct {
+		name         string
+		totalCents   int64
+		splitType    string
+		participants []BillParticipant
+		want         []int64
+		wantErr      bool
+	}{
+		{"equal leftover goes in list order", 1000, SplitEqual, make([]BillParticipant, 3), []int64{334, 333, 333}, false},
+		{"equal fewer cents than participants", 1, SplitEqual, make([]BillParticipant, 3), []int64{1, 0, 0}, false},
+		{"equal even", 900, SplitEqual, make([]BillParticipant, 3), []int64{300, 300, 300}, false},
+		{"percentage largest remainder", 1000, SplitPercentage, percents("33.33", "33.33", "33.34"), []int64{333, 333, 334}, false},
+		{"percentage remainder in the middle", 100, SplitPercentage, percents("33.33", "33.34", "33.33"), []int64{33, 34, 33}, false},
+		{"percentage tie goes to the earlier", 1, SplitPercentage, percents("50", "50"), []int64{1, 0}, false},
+		{"percentage whole", 100, SplitPercentage, percents("100"), []int64{100}, false},
+		{"percentage not adding up", 1000, SplitPercentage, percents("50", "40"), nil, true},
+		{"percentage above 100", 1000, SplitPercentage, percents("150", "-50"), nil, true},
+		{"percentage huge", 1000, SplitPercentage, percents("99999999999"), nil, true},
+		{"exact", 1000, SplitExact, amounts("4.00", "6"), []int64{400, 600}, false},
+		{"exact not adding up", 1000, SplitExact, amounts("4.00", "5.99"), nil, true},
+		{"total too large", math.MaxInt64, SplitPercentage, percents("100"), nil, true},
+		{"no participants", 1000, SplitEqual, nil, nil, true},
+		{"unknown split type", 1000, "weighted", make([]BillParticipant, 2), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitBill(tt.totalCents, tt.splitType, tt.participants)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitBill() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("SplitBill() = %v, want %v", got, tt.want)
+			}
+			var sum int64
+			for _, share := range got {
+				sum += share
+			}
+			if sum != tt.totalCents {
+				t.Fatalf("shares add up to %d, want %d", sum, tt.totalCents)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+var DefaultBudgetThresholds = []int{80, 100}
+
+type BudgetRequest struct {
+	UserId     string `json:"userId" binding:"required"`
+	Category   string `json:"category" binding:"required"`
+	Limit      string `json:"limit" binding:"required"`
+	Thresholds []int  `json:"thresholds"`
+}
+
+type BudgetUpdateRequest struct {
+	BudgetId   string `json:"budgetId" binding:"required"`
+	UserId     string `json:"userId" binding:"required"`
+	Category   string `json:"category"`
+	Limit      string `json:"limit"`
+	Thresholds []int  `json:"thresholds"`
+	Active     *bool  `json:"active"`
+}
+
+type BudgetIdRequest struct {
+	BudgetId string `json:"budgetId" binding:"required"`
+	UserId   string `json:"userId" binding:"required"`
+}
+
+type BudgetStatus struct {
+	BudgetId    string  `json:"budgetId"`
+	Category    string  `json:"category"`
+	Limit       float64 `json:"limit"`
+	Thresholds  []int   `json:"thresholds"`
+	Active      bool    `json:"active"`
+	Period      string  `json:"period"`
+	Spend       float64 `json:"spend"`
+	Remaining   float64 `json:"remaining"`
+	PercentUsed float64 `json:"percentUsed"`
+}
+
+func CreateBudget(c *gin.Context) {
+	var budgetReq BudgetRequest
+	if err := c.ShouldBindJSON(&budgetReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	category := strings.ToUpper(budgetReq.Category)
+	if !IsValidCategory(category) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown category: " + budgetReq.Category})
+		return
+	}
+
+	limit, err := parseBudgetLimit(budgetReq.Limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	thresholds, err := normalizeThresholds(budgetReq.Thresholds)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	budget := db.Budget{
+		BudgetId:    utils.GenerateId("BUDGET"),
+		UserId:      budgetReq.UserId,
+		Category:    category,
+		LimitAmount: limit,
+		Thresholds:  formatThresholds(thresholds),
+		Active:      true,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := db.CreateBudget(PgDb, budget); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	status, err := GetBudgetStatuses(PgDb, []db.Budget{budget})
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Budget Created Successfully", "budget": status[0]})
+}
+
+func GetBudgets(c *gin.Context) {
+	var userData BankUserId
+	if err := c.ShouldBindJSON(&userData); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	budgets, err := db.GetBudgetsUsingUserId(PgDb, userData.UserId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to fetch budgets - " + err.Error()})
+		return
+	}
+
+	statuses, err := GetBudgetStatuses(PgDb, budgets)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	alerts, err := db.GetBudgetAlertsUsingUserId(PgDb, userData.UserId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to fetch budget alerts - " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"budgets": statuses, "alerts": alerts})
+}
+
+func UpdateBudget(c *gin.Context) {
+	var updateReq BudgetUpdateRequest
+	if err := c.ShouldBindJSON(&updateReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	budget, err := db.GetBudgetUsingId(PgDb, updateReq.BudgetId)
+	if err != nil || budget.UserId != updateReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "budget not found for user"})
+		return
+	}
+
+	if len(updateReq.Category) > 0 {
+		category := strings.ToUpper(updateReq.Category)
+		if !IsValidCategory(category) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown category: " + updateReq.Category})
+			return
+		}
+		budget.Category = category
+	}
+	if len(updateReq.Limit) > 0 {
+		limit, err := parseBudgetLimit(updateReq.Limit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		budget.LimitAmount = limit
+	}
+	if updateReq.Thresholds != nil {
+		thresholds, err := normalizeThresholds(updateReq.Thresholds)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		budget.Thresholds = formatThresholds(thresholds)
+	}
+	if updateReq.Active != nil {
+		budget.Active = *updateReq.Active
+	}
+	budget.UpdatedAt = time.Now()
+
+	if err := db.UpdateBudget(PgDb, budget); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	status, err := GetBudgetStatuses(PgDb, []db.Budget{budget})
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Budget Updated Successfully", "budget": status[0]})
+}
+
+func DeleteBudget(c *gin.Context) {
+	var budgetReq BudgetIdRequest
+	if err := c.ShouldBindJSON(&budgetReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	budget, err := db.GetBudgetUsingId(PgDb, budgetReq.BudgetId)
+	if err != nil || budget.UserId != budgetReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "budget not found for user"})
+		return
+	}
+
+	if err := db.DeleteBudget(PgDb, budget.BudgetId); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Budget Deleted Successfully", "budgetId": budget.BudgetId})
+}
+
+// GetBudgetStatuses computes month-to-date spend for each budget from the
+// category totals of its owner.
+func GetBudgetStatuses(bankdb *gorm.DB, budgets []db.Budget) ([]BudgetStatus, error) {
+	period, filterFor := currentBudgetPeriod()
+	spendByUser := make(map[string]map[string]float64)
+	statuses := make([]BudgetStatus, 0, len(budgets))
+
+	for _, budget := range budgets {
+		categorySpend, ok := spendByUser[budget.UserId]
+		if !ok {
+			categories, err := db.GetSpendByCategory(bankdb, filterFor(budget.UserId))
+			if err != nil {
+				return []BudgetStatus{}, err
+			}
+			categorySpend = make(map[string]float64, len(categories))
+			for _, eachCategory := range categories {
+				categorySpend[eachCategory.Category] = eachCategory.Spend
+			}
+			spendByUser[budget.UserId] = categorySpend
+		}
+
+		spend := roundAmount(categorySpend[budget.Category])
+		status := BudgetStatus{
+			BudgetId:   budget.BudgetId,
+			Category:   budget.Category,
+			Limit:      budget.LimitAmount,
+			Thresholds: parseThresholds(budget.Thresholds),
+			Active:     budget.Active,
+			Period:     period,
+			Spend:      spend,
+			Remaining:  roundAmount(budget.LimitAmount - spend),
+		}
+		if budget.LimitAmount > 0 {
+			status.PercentUsed = roundAmount(spend / budget.LimitAmount * 100)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// EvaluateBudgets checks the user's active budgets against month-to-date spend
+// and emits one alert per threshold crossed in the current period.
+func EvaluateBudgets(bankdb *gorm.DB, userId string) error {
+	budgets, err := db.GetBudgetsUsingUserId(bankdb, userId)
+	if err != nil {
+		return err
+	}
+
+	var activeBudgets []db.Budget
+	for _, budget := range budgets {
+		if budget.Active {
+			activeBudgets = append(activeBudgets, budget)
+		}
+	}
+	if len(activeBudgets) == 0 {
+		return nil
+	}
+
+	statuses, err := GetBudgetStatuses(bankdb, activeBudgets)
+	if err != nil {
+		return err
+	}
+
+	for i, status := range statuses {
+		for _, threshold := range status.Thresholds {
+			if status.PercentUsed < float64(threshold) {
+				continue
+			}
+			alert := db.BudgetAlert{
+				BudgetId:    status.BudgetId,
+				Period:      status.Period,
+				Threshold:   threshold,
+				UserId:      activeBudgets[i].UserId,
+				Category:    status.Category,
+				Spend:       status.Spend,
+				LimitAmount: status.Limit,
+				CreatedAt:   time.Now(),
+			}
+			created, err := db.AddBudgetAlert(bankdb, alert)
+			if err != nil {
+				return err
+			}
+			if created {
+				Notify(EventBudgetThreshold, alert.UserId, map[string]interface{}{
+					"budgetId":    alert.BudgetId,
+					"category":    alert.Category,
+					"period":      alert.Period,
+					"threshold":   alert.Threshold,
+					"spend":       alert.Spend,
+					"limit":       alert.LimitAmount,
+					"percentUsed": status.PercentUsed,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func currentBudgetPeriod() (string, func(userId string) db.AnalyticsFilter) {
+	now := time.Now()
+	dateFormat := "2006-01-02"
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return now.Format("2006-01"), func(userId string) db.AnalyticsFilter {
+		return db.AnalyticsFilter{
+			UserId:    userId,
+			StartDate: firstOfMonth.Format(dateFormat),
+			EndDate:   now.Format(dateFormat),
+		}
+	}
+}
+
+func parseBudgetLimit(limit string) (float64, error) {
+	amount, err := strconv.ParseFloat(limit, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("invalid budget limit: %s", limit)
+	}
+	return roundAmount(amount), nil
+}
+
+func normalizeThresholds(thresholds []int) ([]int, error) {
+	if len(thresholds) == 0 {
+		return DefaultBudgetThresholds, nil
+	}
+	seen := make(map[int]bool)
+	var normalized []int
+	for _, threshold := range thresholds {
+		if threshold <= 0 || threshold > 1000 {
+			return nil, fmt.Errorf("invalid budget threshold: %d", threshold)
+		}
+		if !seen[threshold] {
+			seen[threshold] = true
+			normalized = append(normalized, threshold)
+		}
+	}
+	sort.Ints(normalized)
+	return normalized, nil
+}
+
+func formatThresholds(thresholds []int) string {
+	values := make([]string, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		values = append(values, strconv.Itoa(threshold))
+	}
+	return strings.Join(values, ",")
+}
+
+func parseThresholds(thresholds string) []int {
+	var values []int
+	for _, value := range strings.Split(thresholds, ",") {
+		threshold, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		values = append(values, threshold)
+	}
+	return values
+}
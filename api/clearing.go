@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	ClearingStandard      = "standard"
+	ClearingSameDay       = "same-day"
+	ClearingNextAvailable = "next-available"
+
+	// SameDayAchLimit is the Nacha per-payment limit for Same Day ACH.
+	SameDayAchLimit = 1000000
+
+	MaxTransferMetadataKeys   = 10
+	MaxTransferMetadataLength = 255
+
+	FundingSourceChannelAch      = "ach"
+	FundingSourceChannelRealTime = "real-time-payments"
+)
+
+const (
+	RejectInvalidClearing    = "INVALID_CLEARING"
+	RejectClearingNotAllowed = "CLEARING_NOT_SUPPORTED"
+	RejectInvalidMetadata    = "INVALID_METADATA"
+)
+
+// ClearingSettings maps a clearing speed to the Dwolla clearing object. Only
+// same-day uses it, to send the credit as Same Day ACH; standard sends no
+// clearing so the account default applies.
+func ClearingSettings(clearing string) *TransferClearing {
+	if clearing == ClearingSameDay {
+		return &TransferClearing{Destination: "next-available"}
+	}
+	return nil
+}
+
+// ProcessingChannelSettings routes next-available transfers over real-time
+// payments instead of ACH.
+func ProcessingChannelSettings(clearing string) *TransferProcessingChannel {
+	if clearing == ClearingNextAvailable {
+		return &TransferProcessingChannel{Destination: FundingSourceChannelRealTime}
+	}
+	return nil
+}
+
+// ActualClearing reads the clearing and processing channel Dwolla applied
+// back into a clearing speed. A transfer with neither went out as standard.
+func ActualClearing(clearing *TransferClearing, channel *TransferProcessingChannel) string {
+	switch {
+	case channel != nil && channel.Destination == FundingSourceChannelRealTime:
+		return ClearingNextAvailable
+	case clearing != nil && clearing.Destination == "next-available":
+		return ClearingSameDay
+	}
+	return ClearingStandard
+}
+
+// ValidateTransferOptions checks the clearing speed, metadata and correlation
+// id of a transfer request before anything is sent to Dwolla.
+func ValidateTransferOptions(paymentTransferReq PaymentTransfer) []TransferRejection {
+	var rejections []TransferRejection
+	switch paymentTransferReq.Clearing {
+	case "", ClearingStandard, ClearingSameDay, ClearingNextAvailable:
+	default:
+		rejections = append(rejections, TransferRejection{
+			Code:    RejectInvalidClearing,
+			Message: fmt.Sprintf("clearing must be one of %s, %s or %s", ClearingStandard, ClearingSameDay, ClearingNextAvailable),
+		})
+	}
+
+	if len(paymentTransferReq.Metadata) > MaxTransferMetadataKeys {
+		rejections = append(rejections, TransferRejection{
+			Code:    RejectInvalidMetadata,
+			Message: fmt.Sprintf("metadata may have at most %d keys", MaxTransferMetadataKeys),
+		})
+	}
+	for key, value := range paymentTransferReq.Metadata {
+		if len(key) == 0 || len(key) > MaxTransferMetadataLength || len(value) > MaxTransferMetadataLength {
+			rejections = append(rejections, TransferRejection{
+				Code:    RejectInvalidMetadata,
+				Message: fmt.Sprintf("metadata keys and values must be 1 to %d characters", MaxTransferMetadataLength),
+			})
+			break
+		}
+	}
+	if len(paymentTransferReq.CorrelationId) > MaxTransferMetadataLength {
+		rejections = append(rejections, TransferRejection{
+			Code:    RejectInvalidMetadata,
+			Message: fmt.Sprintf("correlationId must be at most %d characters", MaxTransferMetadataLength),
+		})
+	}
+	return rejections
+}
+
+// CheckClearingSupport verifies both funding sources can use the requested
+// clearing speed. Same-day needs bank accounts on the ACH network and an
+// amount within the Same Day ACH limit; next-available is sent over
+// real-time payments, so the destination must take them.
+func CheckClearingSupport(ctx context.Context, sourceFundingSourceUrl string, destinationFundingSourceUrl string, clearing string, amount float64) ([]TransferRejection, error) {
+	if clearing != ClearingSameDay && clearing != ClearingNextAvailable {
+		return nil, nil
+	}
+
+	destination, err := RetrieveFundingSource(ctx, destinationFundingSourceUrl)
+	if err != nil {
+		return nil, err
+	}
+	var rejections []TransferRejection
+	reject := func(message string) {
+		rejections = append(rejections, TransferRejection{Code: RejectClearingNotAllowed, Message: message})
+	}
+
+	if clearing == ClearingNextAvailable {
+		if !hasChannel(destination, FundingSourceChannelRealTime) {
+			reject("the receiving bank does not accept real-time payments")
+		}
+		return rejections, nil
+	}
+
+	source, err := RetrieveFundingSource(ctx, sourceFundingSourceUrl)
+	if err != nil {
+		return nil, err
+	}
+	if source.Type != "bank" || !hasChannel(source, FundingSourceChannelAch) {
+		reject("the sending bank does not support same-day ACH")
+	}
+	if destination.Type != "bank" || !hasChannel(destination, FundingSourceChannelAch) {
+		reject("the receiving bank does not support same-day ACH")
+	}
+	if amount > SameDayAchLimit {
+		reject(fmt.Sprintf("same-day transfers are limited to %d", SameDayAchLimit))
+	}
+	return rejections, nil
+}
+
+// hasChannel reports whether the funding source lists the payment channel.
+// Dwolla omits channels on older funding sources, which are ACH only.
+func hasChannel(fundingSource DwollaFundingSource, channel string) bool {
+	if len(fundingSource.Channels) == 0 {
+		return channel == FundingSourceChannelAch
+	}
+	for _, each := range fundingSource.Channels {
+		if strings.EqualFold(each, channel) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"gorm.io/gorm"
+)
+
+// MaxCustomerDocumentSize is the largest document Dwolla accepts.
+const MaxCustomerDocumentSize = 10 << 20
+
+var (
+	CustomerDocumentTypes = []string{"passport", "license", "idCard", "other"}
+	CustomerDocumentMimes = []string{"image/jpeg", "image/png", "application/pdf"}
+
+	statePattern      = regexp.MustCompile(`^[A-Z]{2}$`)
+	postalCodePattern = regexp.MustCompile(`^\d{5}(-\d{4})?$`)
+	digitsPattern     = regexp.MustCompile(`^\d+$`)
+)
+
+type DwollaCustomerLookup struct {
+	UserId     string `json:"userId" binding:"required"`
+	CustomerId string `json:"customerId" binding:"required"`
+}
+
+// InvalidCustomerError is returned when the customer details fail validation.
+type InvalidCustomerError struct {
+	Reason string
+}
+
+func (e *InvalidCustomerError) Error() string {
+	return e.Reason
+}
+
+// DuplicateCustomerError is returned when Dwolla already has a customer for
+// the email. That customer is never linked automatically; an admin links it
+// with AdoptDwollaCustomer once the owner is confirmed.
+type DuplicateCustomerError struct {
+	Email string
+}
+
+func (e *DuplicateCustomerError) Error() string {
+	return "a dwolla customer already exists for " + e.Email + ", it must be linked by an admin"
+}
+
+type AdoptCustomerRequest struct {
+	UserId    string `json:"userId" binding:"required"`
+	Email     string `json:"email" binding:"required"`
+	FirstName string `json:"firstName" binding:"required"`
+	LastName  string `json:"lastName" binding:"required"`
+}
+
+// EnsureDwollaCustomer returns the user's Dwolla customer, creating it on the
+// first call. A user has at most one customer, so repeat calls return the
+// stored one. created reports whether a customer was added for the user.
+func EnsureDwollaCustomer(bankdb *gorm.DB, dwollaUser BankUser) (db.DwollaCustomer, bool, error) {
+	if len(dwollaUser.UserId) == 0 || len(dwollaUser.Email) == 0 || len(dwollaUser.FirstName) == 0 || len(dwollaUser.LastName) == 0 {
+		return db.DwollaCustomer{}, false, &InvalidCustomerError{Reason: "userId, email, firstName and lastName are required"}
+	}
+	if existing, err := db.GetDwollaCustomerUsingUserId(bankdb, dwollaUser.UserId); err == nil {
+		return existing, false, nil
+	}
+
+	if dwollaUser.CustomerType == DwollaCustomerPersonal {
+		if err := ValidateVerifiedCustomer(dwollaUser, 4); err != nil {
+			return db.DwollaCustomer{}, false, &InvalidCustomerError{Reason: err.Error()}
+		}
+	} else {
+		dwollaUser.CustomerType = DwollaCustomerUnverified
+	}
+
+	customer, customerUrl, err := CreateDwollaCustomer(dwollaUser)
+	if errors.Is(err, ErrDwollaDuplicate) {
+		return db.DwollaCustomer{}, false, &DuplicateCustomerError{Email: dwollaUser.Email}
+	}
+	if err != nil {
+		return db.DwollaCustomer{}, false, err
+	}
+	return saveDwollaCustomer(bankdb, dwollaUser, customer, customerUrl)
+}
+
+// saveDwollaCustomer stores a Dwolla customer for the user unless it already
+// belongs to someone else.
+func saveDwollaCustomer(bankdb *gorm.DB, dwollaUser BankUser, customer DwollaCustomer, customerUrl string) (db.DwollaCustomer, bool, error) {
+	if owner, err := db.GetDwollaCustomerUsingId(bankdb, customer.Id); err == nil && owner.UserId != dwollaUser.UserId {
+		return db.DwollaCustomer{}, false, &InvalidCustomerError{Reason: "email is already registered to another user"}
+	}
+
+	now := time.Now()
+	customerRecord := db.DwollaCustomer{
+		CustomerId:  customer.Id,
+		UserId:      dwollaUser.UserId,
+		CustomerUrl: customerUrl,
+		Email:       dwollaUser.Email,
+		FirstName:   dwollaUser.FirstName,
+		LastName:    dwollaUser.LastName,
+		Address1:    dwollaUser.Address1,
+		PostalCode:  dwollaUser.PostalCode,
+		Type:        customer.Type,
+		Status:      customer.Status,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if len(customerRecord.Type) == 0 {
+		customerRecord.Type = dwollaUser.CustomerType
+	}
+	if len(customerRecord.Status) == 0 {
+		customerRecord.Status = DwollaCustomerUnverified
+	}
+	created, err := db.AddDwollaCustomer(bankdb, customerRecord)
+	if err != nil {
+		return db.DwollaCustomer{}, false, err
+	}
+	// A concurrent request may have stored the user's customer first.
+	stored, err := db.GetDwollaCustomerUsingUserId(bankdb, dwollaUser.UserId)
+	if err != nil {
+		return db.DwollaCustomer{}, false, err
+	}
+	return stored, created, nil
+}
+
+// ValidateVerifiedCustomer checks the KYC fields Dwolla requires for a
+// personal verified customer. The first attempt takes the last four SSN
+// digits and a retry takes all nine.
+func ValidateVerifiedCustomer(dwollaUser BankUser, ssnDigits int) error {
+	var missing []string
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"firstName", dwollaUser.FirstName},
+		{"lastName", dwollaUser.LastName},
+		{"email", dwollaUser.Email},
+		{"address1", dwollaUser.Address1},
+		{"city", dwollaUser.City},
+		{"state", dwollaUser.State},
+		{"postalCode", dwollaUser.PostalCode},
+		{"dateOfBirth", dwollaUser.DateOfBirth},
+		{"ssn", dwollaUser.Ssn},
+	} {
+		if len(strings.TrimSpace(field.value)) == 0 {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing fields for a verified customer: %s", strings.Join(missing, ", "))
+	}
+
+	if !statePattern.MatchString(dwollaUser.State) {
+		return fmt.Errorf("state must be a two-letter abbreviation")
+	}
+	if !postalCodePattern.MatchString(dwollaUser.PostalCode) {
+		return fmt.Errorf("invalid postal code: %s", dwollaUser.PostalCode)
+	}
+	dob, err := time.Parse("2006-01-02", dwollaUser.DateOfBirth)
+	if err != nil {
+		return fmt.Errorf("dateOfBirth must be in YYYY-MM-DD format")
+	}
+	if dob.AddDate(18, 0, 0).After(time.Now()) {
+		return fmt.Errorf("customer must be at least 18 years old")
+	}
+	if len(dwollaUser.Ssn) != ssnDigits || !digitsPattern.MatchString(dwollaUser.Ssn) {
+		return fmt.Errorf("ssn must be %d digits", ssnDigits)
+	}
+	return nil
+}
+
+// RefreshDwollaCustomer pulls the verification status from Dwolla and stores
+// it when it changed.
+func RefreshDwollaCustomer(bankdb *gorm.DB, customer db.DwollaCustomer) (db.DwollaCustomer, error) {
+	dwollaCustomer, err := RetrieveDwollaCustomer(context.Background(), customer.CustomerUrl)
+	if err != nil {
+		return db.DwollaCustomer{}, err
+	}
+	if dwollaCustomer.Status == customer.Status && dwollaCustomer.Type == customer.Type {
+		return customer, nil
+	}
+	if err := db.UpdateDwollaCustomerStatus(bankdb, customer.CustomerId, dwollaCustomer.Type, dwollaCustomer.Status); err != nil {
+		return db.DwollaCustomer{}, err
+	}
+	return db.GetDwollaCustomerUsingId(bankdb, customer.CustomerId)
+}
+
+func getOwnedDwollaCustomer(userId string, customerId string) (db.DwollaCustomer, bool) {
+	customer, err := db.GetDwollaCustomerUsingId(PgDb, customerId)
+	if err != nil || customer.UserId != userId {
+		return db.DwollaCustomer{}, false
+	}
+	return customer, true
+}
+
+func GetDwollaCustomer(c *gin.Context) {
+	var lookupReq DwollaCustomerLookup
+	if err := c.ShouldBindJSON(&lookupReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	customer, ok := getOwnedDwollaCustomer(lookupReq.UserId, lookupReq.CustomerId)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "customer not found for user"})
+		return
+	}
+
+	customer, err := RefreshDwollaCustomer(PgDb, customer)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": customer})
+}
+
+// RetryDwollaCustomerVerification resubmits KYC details for a customer that
+// Dwolla could not verify on the first attempt.
+func RetryDwollaCustomerVerification(c *gin.Context) {
+	var dwollaUser BankUser
+	if err := c.ShouldBindJSON(&dwollaUser); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	customer, ok := getOwnedDwollaCustomer(dwollaUser.UserId, dwollaUser.DwollaCustomerId)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "customer not found for user"})
+		return
+	}
+	if customer.Status != DwollaCustomerRetry {
+		c.JSON(http.StatusConflict, gin.H{"error": "customer status is " + customer.Status + ", retry is not allowed", "status": customer.Status})
+		return
+	}
+	if err := ValidateVerifiedCustomer(dwollaUser, 9); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dwollaCustomer, err := RetryDwollaCustomer(context.Background(), customer.CustomerUrl, dwollaUser)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if len(dwollaCustomer.Status) > 0 {
+		if err := db.UpdateDwollaCustomerStatus(PgDb, customer.CustomerId, DwollaCustomerPersonal, dwollaCustomer.Status); err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		customer.Status = dwollaCustomer.Status
+	}
+	// The retry may correct the address the identity check compares against.
+	if err := db.UpdateDwollaCustomerAddress(PgDb, customer.CustomerId, dwollaUser.Address1, dwollaUser.PostalCode); err != nil {
+		log.Println(err.Error())
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Customer Verification Resubmitted", "status": customer.Status})
+}
+
+// UploadDwollaCustomerDocument takes a multipart form with userId, customerId,
+// documentType and file, and forwards the file to Dwolla.
+func UploadDwollaCustomerDocument(c *gin.Context) {
+	userId := c.PostForm("userId")
+	customerId := c.PostForm("customerId")
+	documentType := c.PostForm("documentType")
+	if len(userId) == 0 || len(customerId) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - userId and customerId are required"})
+		return
+	}
+	if !containsString(CustomerDocumentTypes, documentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "documentType must be one of " + strings.Join(CustomerDocumentTypes, ", ")})
+		return
+	}
+
+	customer, ok := getOwnedDwollaCustomer(userId, customerId)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "customer not found for user"})
+		return
+	}
+	if customer.Status != DwollaCustomerDocument {
+		c.JSON(http.StatusConflict, gin.H{"error": "customer status is " + customer.Status + ", no document is needed", "status": customer.Status})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if fileHeader.Size > MaxCustomerDocumentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "document must be 10MB or smaller"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unable to read document"})
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if mime := http.DetectContentType(head[:n]); !containsString(CustomerDocumentMimes, mime) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "document must be a JPEG, PNG or PDF"})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to read document"})
+		return
+	}
+
+	documentId, err := UploadCustomerDocument(context.Background(), customer.CustomerUrl, documentType, fileHeader.Filename, file)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document Uploaded Successfully", "documentId": documentId})
+}
+
+// AdoptDwollaCustomer links a customer Dwolla already has for the email to the
+// user, e.g. one created before customers were stored here. The name on the
+// Dwolla customer must match the one the admin confirmed for the user.
+func AdoptDwollaCustomer(c *gin.Context) {
+	var adoptReq AdoptCustomerRequest
+	if err := c.ShouldBindJSON(&adoptReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if _, err := db.GetDwollaCustomerUsingUserId(PgDb, adoptReq.UserId); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "user already has a dwolla customer"})
+		return
+	}
+
+	customer, customerUrl, err := FindDwollaCustomerByEmail(context.Background(), adoptReq.Email)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if !strings.EqualFold(strings.TrimSpace(customer.FirstName), strings.TrimSpace(adoptReq.FirstName)) ||
+		!strings.EqualFold(strings.TrimSpace(customer.LastName), strings.TrimSpace(adoptReq.LastName)) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name on the dwolla customer does not match the user"})
+		return
+	}
+
+	stored, created, err := saveDwollaCustomer(PgDb, BankUser{
+		UserId:       adoptReq.UserId,
+		Email:        adoptReq.Email,
+		FirstName:    customer.FirstName,
+		LastName:     customer.LastName,
+		CustomerType: customer.Type,
+	}, customer, customerUrl)
+	if err != nil {
+		log.Println(err.Error())
+		var invalidErr *InvalidCustomerError
+		if errors.As(err, &invalidErr) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Println("Dwolla Customer Adopted: ", stored.CustomerId, "| User: ", stored.UserId)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dwolla Customer Linked", "customer_id": stored.CustomerId, "customer_url": stored.CustomerUrl, "status": stored.Status, "created": created})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,16 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kolanos/dwolla-v2-go"
 )
@@ -23,8 +30,109 @@ type FundingSourcePayload struct {
 }
 
 type TransferRequestBody struct {
-	Links  dwolla.Links  `json:"_links"`
-	Amount dwolla.Amount `json:"amount"`
+	Links             dwolla.Links               `json:"_links"`
+	Amount            dwolla.Amount              `json:"amount"`
+	Clearing          *TransferClearing          `json:"clearing,omitempty"`
+	ProcessingChannel *TransferProcessingChannel `json:"processingChannel,omitempty"`
+	Metadata          map[string]string          `json:"metadata,omitempty"`
+	CorrelationId     string                     `json:"correlationId,omitempty"`
+	Fees              []TransferFee              `json:"fees,omitempty"`
+}
+
+type TransferClearing struct {
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// TransferProcessingChannel routes a transfer over a network other than ACH,
+// such as real-time payments.
+type TransferProcessingChannel struct {
+	Destination string `json:"destination"`
+}
+
+type DwollaTransfer struct {
+	Id                string                     `json:"id"`
+	Status            string                     `json:"status"`
+	Amount            dwolla.Amount              `json:"amount"`
+	Created           string                     `json:"created"`
+	CorrelationId     string                     `json:"correlationId"`
+	Clearing          *TransferClearing          `json:"clearing"`
+	ProcessingChannel *TransferProcessingChannel `json:"processingChannel"`
+	Links             dwolla.Links               `json:"_links"`
+}
+
+type DwollaTransferList struct {
+	Embedded struct {
+		Transfers []DwollaTransfer `json:"transfers"`
+	} `json:"_embedded"`
+	Total int `json:"total"`
+}
+
+// DwollaCustomerRequest is the customer payload. Unverified customers need only
+// a name and email; personal verified customers also carry the KYC fields.
+type DwollaCustomerRequest struct {
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	Email       string `json:"email"`
+	Type        string `json:"type,omitempty"`
+	Address1    string `json:"address1,omitempty"`
+	City        string `json:"city,omitempty"`
+	State       string `json:"state,omitempty"`
+	PostalCode  string `json:"postalCode,omitempty"`
+	DateOfBirth string `json:"dateOfBirth,omitempty"`
+	Ssn         string `json:"ssn,omitempty"`
+}
+
+type DwollaCustomer struct {
+	Id        string       `json:"id"`
+	FirstName string       `json:"firstName"`
+	LastName  string       `json:"lastName"`
+	Email     string       `json:"email"`
+	Type      string       `json:"type"`
+	Status    string       `json:"status"`
+	Created   string       `json:"created"`
+	Links     dwolla.Links `json:"_links"`
+}
+
+const (
+	DwollaCustomerUnverified = "unverified"
+	DwollaCustomerPersonal   = "personal"
+
+	DwollaCustomerVerified    = "verified"
+	DwollaCustomerRetry       = "retry"
+	DwollaCustomerDocument    = "document"
+	DwollaCustomerSuspended   = "suspended"
+	DwollaCustomerDeactivated = "deactivated"
+)
+
+const (
+	DwollaTransferPending   = "pending"
+	DwollaTransferProcessed = "processed"
+	DwollaTransferFailed    = "failed"
+	DwollaTransferCancelled = "cancelled"
+)
+
+var (
+	// ErrDwollaNotFound is wrapped into errors for resources Dwolla does not
+	// know.
+	ErrDwollaNotFound = errors.New("dwolla resource not found")
+	// ErrDwollaDuplicate is wrapped into errors for creates Dwolla refuses
+	// because the resource already exists.
+	ErrDwollaDuplicate = errors.New("dwolla resource already exists")
+)
+
+// DwollaErrorCode returns the code of an error response from Dwolla, such as
+// NotFound or Duplicate, or an empty string for any other error.
+func DwollaErrorCode(err error) string {
+	var halErr dwolla.HALError
+	if errors.As(err, &halErr) {
+		return halErr.Code
+	}
+	var halErrRef *dwolla.HALError
+	if errors.As(err, &halErrRef) {
+		return halErrRef.Code
+	}
+	return ""
 }
 
 func CreateDwollaClient() {
@@ -44,21 +152,102 @@ func CreateOnDemandAuthorization(client *dwolla.Client) (dwolla.Links, error) {
 	return dwollaAuthLinks, nil
 }
 
-func CreateDwollaCustomer(dwollaUser BankUser) (string, string, error) {
+// CreateDwollaCustomer creates an unverified customer, or a personal verified
+// customer when the user asks for one and supplies the KYC fields.
+func CreateDwollaCustomer(dwollaUser BankUser) (DwollaCustomer, string, error) {
 	ctx := context.Background()
-	dwollaCustomerPaylod := dwolla.CustomerRequest{
-		FirstName:    dwollaUser.FirstName,
-		LastName:     dwollaUser.LastName,
-		Email:        dwollaUser.Email,
-		BusinessName: fmt.Sprintf("%s %s's Business", dwollaUser.FirstName, dwollaUser.LastName),
+	dwollaCustomerPayload := DwollaCustomerRequest{
+		FirstName: dwollaUser.FirstName,
+		LastName:  dwollaUser.LastName,
+		Email:     dwollaUser.Email,
 	}
-	newDwollaCustomer, err := DwollaClient.Customer.Create(ctx, &dwollaCustomerPaylod)
-	if err != nil {
+	if dwollaUser.CustomerType == DwollaCustomerPersonal {
+		dwollaCustomerPayload = verifiedCustomerRequest(dwollaUser)
+	}
+
+	var newDwollaCustomer DwollaCustomer
+	customersUrl := fmt.Sprintf("%s/customers", DwollaBaseUrl)
+	if err := DwollaClient.Post(ctx, customersUrl, dwollaCustomerPayload, &http.Header{}, &newDwollaCustomer); err != nil {
 		log.Println(err.Error())
-		return "", "", fmt.Errorf("error while creating Dwolla customer: %v", err.Error())
+		if DwollaErrorCode(err) == "Duplicate" {
+			return DwollaCustomer{}, "", fmt.Errorf("error while creating Dwolla customer: %w", ErrDwollaDuplicate)
+		}
+		return DwollaCustomer{}, "", fmt.Errorf("error while creating Dwolla customer: %v", err.Error())
 	}
-	dwollaCustomerUrl := fmt.Sprintf("%s/customers/%s", DwollaBaseUrl, newDwollaCustomer.ID)
-	return newDwollaCustomer.ID, dwollaCustomerUrl, nil
+	if len(newDwollaCustomer.Id) == 0 {
+		return DwollaCustomer{}, "", fmt.Errorf("error while creating Dwolla customer: dwolla did not return a customer id")
+	}
+	dwollaCustomerUrl := fmt.Sprintf("%s/customers/%s", DwollaBaseUrl, newDwollaCustomer.Id)
+	return newDwollaCustomer, dwollaCustomerUrl, nil
+}
+
+// FindDwollaCustomerByEmail looks up an existing customer, used when an admin
+// links a customer Dwolla already has for the email.
+func FindDwollaCustomerByEmail(ctx context.Context, email string) (DwollaCustomer, string, error) {
+	params := &url.Values{}
+	params.Set("email", email)
+	var customers struct {
+		Embedded struct {
+			Customers []DwollaCustomer `json:"customers"`
+		} `json:"_embedded"`
+	}
+	if err := DwollaClient.Get(ctx, fmt.Sprintf("%s/customers", DwollaBaseUrl), params, &http.Header{}, &customers); err != nil {
+		log.Println(err.Error())
+		return DwollaCustomer{}, "", fmt.Errorf("error while searching dwolla customers: %v", err.Error())
+	}
+	for _, customer := range customers.Embedded.Customers {
+		if strings.EqualFold(customer.Email, email) {
+			return customer, fmt.Sprintf("%s/customers/%s", DwollaBaseUrl, customer.Id), nil
+		}
+	}
+	return DwollaCustomer{}, "", fmt.Errorf("no dwolla customer found for %s", email)
+}
+
+func verifiedCustomerRequest(dwollaUser BankUser) DwollaCustomerRequest {
+	return DwollaCustomerRequest{
+		FirstName:   dwollaUser.FirstName,
+		LastName:    dwollaUser.LastName,
+		Email:       dwollaUser.Email,
+		Type:        DwollaCustomerPersonal,
+		Address1:    dwollaUser.Address1,
+		City:        dwollaUser.City,
+		State:       dwollaUser.State,
+		PostalCode:  dwollaUser.PostalCode,
+		DateOfBirth: dwollaUser.DateOfBirth,
+		Ssn:         dwollaUser.Ssn,
+	}
+}
+
+func RetrieveDwollaCustomer(ctx context.Context, customerUrl string) (DwollaCustomer, error) {
+	var customer DwollaCustomer
+	if err := DwollaClient.Get(ctx, customerUrl, nil, &http.Header{}, &customer); err != nil {
+		log.Println(err.Error())
+		return DwollaCustomer{}, fmt.Errorf("error while retrieving dwolla customer: %v", err.Error())
+	}
+	return customer, nil
+}
+
+// RetryDwollaCustomer resubmits the KYC fields of a customer in retry status.
+// Dwolla expects the full nine digit SSN on a retry.
+func RetryDwollaCustomer(ctx context.Context, customerUrl string, dwollaUser BankUser) (DwollaCustomer, error) {
+	var customer DwollaCustomer
+	if err := DwollaClient.Post(ctx, customerUrl, verifiedCustomerRequest(dwollaUser), &http.Header{}, &customer); err != nil {
+		log.Println(err.Error())
+		return DwollaCustomer{}, fmt.Errorf("error while retrying dwolla customer verification: %v", err.Error())
+	}
+	return customer, nil
+}
+
+// UploadCustomerDocument sends an identity document for a customer in
+// document status and returns the Dwolla document id.
+func UploadCustomerDocument(ctx context.Context, customerUrl string, documentType string, fileName string, file io.Reader) (string, error) {
+	var document map[string]interface{}
+	if err := DwollaClient.Upload(ctx, customerUrl+"/documents", dwolla.DocumentType(documentType), fileName, file, &document); err != nil {
+		log.Println(err.Error())
+		return "", fmt.Errorf("error while uploading customer document: %v", err.Error())
+	}
+	documentId, _ := document["id"].(string)
+	return documentId, nil
 }
 
 func CreateFundingSource(dwollaAuthLinks dwolla.Links, ctx context.Context, dwollaCustomerId string, processorToken string, bankName string) (*dwolla.FundingSource, error) {
@@ -105,28 +294,104 @@ func CreateFundingSourceUsingPostCall(client *dwolla.Client, ctx context.Context
 	return responseContainer, nil
 }
 
-func AddFundingSource(dwollaCustomerUrl string, processorToken string, bankName string) (string, error) {
+func AddFundingSource(dwollaCustomerUrl string, processorToken string, bankName string) (string, string, error) {
 	ctx := context.Background()
 	dwollaAuthLinks, err := CreateOnDemandAuthorization(DwollaClient)
 	if err != nil {
 		log.Println(err.Error())
-		return "", err
+		return "", "", err
 	}
 
 	fundingSourceResponse, err := CreateFundingSourceUsingPostCall(DwollaClient, ctx, dwollaCustomerUrl, processorToken, bankName, dwollaAuthLinks)
 	if err != nil {
 		log.Println(err.Error())
-		return "", err
+		return "", "", err
+	}
+	fundingSourceId, ok := fundingSourceResponse["id"].(string)
+	if !ok || len(fundingSourceId) == 0 {
+		return "", "", fmt.Errorf("error while creating funding source: dwolla did not return a funding source id")
 	}
-	fundingSourceId := fundingSourceResponse["id"]
 	fundingSourceUrl := fmt.Sprintf("%s/funding-sources/%s", DwollaBaseUrl, fundingSourceId)
-	//return funding source url
-	return fundingSourceUrl, nil
+	//return funding source id and url
+	return fundingSourceId, fundingSourceUrl, nil
 
 	// CreateFundingSource(dwollaAuthLinks, ctx, dwollaCustomerId, processorToken, bankName)
 }
 
-func CreateTransfer(ctx context.Context, sourceFundingSourceUrl string, destinationFundingSourceUrl string, amount string) (map[string]interface{}, error) {
+type BankFundingSourceRequest struct {
+	RoutingNumber   string `json:"routingNumber"`
+	AccountNumber   string `json:"accountNumber"`
+	BankAccountType string `json:"bankAccountType"`
+	Name            string `json:"name"`
+}
+
+// CreateBankFundingSource adds an unverified funding source from routing and
+// account numbers, for banks that cannot be linked through Plaid.
+func CreateBankFundingSource(ctx context.Context, dwollaCustomerUrl string, body BankFundingSourceRequest) (string, string, error) {
+	var responseContainer map[string]interface{}
+	if err := DwollaClient.Post(ctx, dwollaCustomerUrl+"/funding-sources", body, &http.Header{}, &responseContainer); err != nil {
+		log.Println(err.Error())
+		return "", "", fmt.Errorf("error while creating funding source: %v", err.Error())
+	}
+	fundingSourceId, ok := responseContainer["id"].(string)
+	if !ok || len(fundingSourceId) == 0 {
+		return "", "", fmt.Errorf("error while creating funding source: dwolla did not return a funding source id")
+	}
+	return fundingSourceId, fmt.Sprintf("%s/funding-sources/%s", DwollaBaseUrl, fundingSourceId), nil
+}
+
+// InitiateMicroDeposits asks Dwolla to send two small deposits to the bank
+// account; they arrive in one to two business days.
+func InitiateMicroDeposits(ctx context.Context, fundingSourceUrl string) error {
+	var responseContainer map[string]interface{}
+	if err := DwollaClient.Post(ctx, fundingSourceUrl+"/micro-deposits", nil, &http.Header{}, &responseContainer); err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while initiating micro-deposits: %v", err.Error())
+	}
+	return nil
+}
+
+func VerifyMicroDeposits(ctx context.Context, fundingSourceUrl string, amount1 string, amount2 string) error {
+	body := map[string]dwolla.Amount{
+		"amount1": {Currency: dwolla.USD, Value: amount1},
+		"amount2": {Currency: dwolla.USD, Value: amount2},
+	}
+	var responseContainer map[string]interface{}
+	if err := DwollaClient.Post(ctx, fundingSourceUrl+"/micro-deposits", body, &http.Header{}, &responseContainer); err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while verifying micro-deposits: %v", err.Error())
+	}
+	return nil
+}
+
+type DwollaFundingSource struct {
+	Id       string                 `json:"id"`
+	Status   string                 `json:"status"`
+	Type     string                 `json:"type"`
+	Channels []string               `json:"channels"`
+	Removed  bool                   `json:"removed"`
+	Links    map[string]interface{} `json:"_links"`
+}
+
+func RetrieveFundingSource(ctx context.Context, fundingSourceUrl string) (DwollaFundingSource, error) {
+	var fundingSource DwollaFundingSource
+	if err := DwollaClient.Get(ctx, fundingSourceUrl, nil, &http.Header{}, &fundingSource); err != nil {
+		log.Println(err.Error())
+		return DwollaFundingSource{}, fmt.Errorf("error while retrieving funding source: %v", err.Error())
+	}
+	return fundingSource, nil
+}
+
+// TransferOptions carries the optional parts of a transfer request.
+type TransferOptions struct {
+	Clearing          *TransferClearing
+	ProcessingChannel *TransferProcessingChannel
+	Metadata          map[string]string
+	CorrelationId     string
+	Fees              []TransferFee
+}
+
+func CreateTransfer(ctx context.Context, sourceFundingSourceUrl string, destinationFundingSourceUrl string, amount string, options TransferOptions) (map[string]interface{}, error) {
 
 	var transferReq TransferRequestBody
 
@@ -143,6 +408,11 @@ func CreateTransfer(ctx context.Context, sourceFundingSourceUrl string, destinat
 		Currency: dwolla.USD,
 		Value:    amount,
 	}
+	transferReq.Clearing = options.Clearing
+	transferReq.ProcessingChannel = options.ProcessingChannel
+	transferReq.Metadata = options.Metadata
+	transferReq.CorrelationId = options.CorrelationId
+	transferReq.Fees = options.Fees
 
 	log.Println("Transfer Request: ", transferReq)
 
@@ -159,6 +429,93 @@ func CreateTransfer(ctx context.Context, sourceFundingSourceUrl string, destinat
 
 }
 
+// DwollaResourceId returns the id at the end of a Dwolla resource URL.
+func DwollaResourceId(resourceUrl string) string {
+	return path.Base(resourceUrl)
+}
+
+func RetrieveFundingSourceCustomerUrl(ctx context.Context, fundingSourceUrl string) (string, error) {
+	var fundingSource struct {
+		Links dwolla.Links `json:"_links"`
+	}
+	if err := DwollaClient.Get(ctx, fundingSourceUrl, nil, &http.Header{}, &fundingSource); err != nil {
+		log.Println(err.Error())
+		return "", fmt.Errorf("error while retrieving funding source: %v", err.Error())
+	}
+	customerLink, ok := fundingSource.Links["customer"]
+	if !ok || len(customerLink.Href) == 0 {
+		return "", fmt.Errorf("funding source %s has no customer link", fundingSourceUrl)
+	}
+	return customerLink.Href, nil
+}
+
+// ListCustomerTransfers pages through the customer's transfers created
+// between the start and end dates.
+func ListCustomerTransfers(ctx context.Context, customerUrl string, start time.Time, end time.Time) ([]DwollaTransfer, error) {
+	var transfers []DwollaTransfer
+	limit := 200
+	for offset := 0; ; offset += limit {
+		params := &url.Values{}
+		params.Set("startDate", start.Format("2006-01-02"))
+		params.Set("endDate", end.Format("2006-01-02"))
+		params.Set("limit", strconv.Itoa(limit))
+		params.Set("offset", strconv.Itoa(offset))
+
+		var page DwollaTransferList
+		if err := DwollaClient.Get(ctx, customerUrl+"/transfers", params, &http.Header{}, &page); err != nil {
+			log.Println(err.Error())
+			return nil, fmt.Errorf("error while listing dwolla transfers: %v", err.Error())
+		}
+		transfers = append(transfers, page.Embedded.Transfers...)
+		if len(page.Embedded.Transfers) < limit || offset+limit >= page.Total {
+			return transfers, nil
+		}
+	}
+}
+
+func DwollaTransferUrl(transferId string) string {
+	return fmt.Sprintf("%s/transfers/%s", DwollaBaseUrl, transferId)
+}
+
+func RetrieveTransfer(ctx context.Context, transferId string) (DwollaTransfer, error) {
+	var transfer DwollaTransfer
+	transferUrl := DwollaTransferUrl(transferId)
+	if err := DwollaClient.Get(ctx, transferUrl, nil, &http.Header{}, &transfer); err != nil {
+		log.Println(err.Error())
+		if DwollaErrorCode(err) == "NotFound" {
+			return DwollaTransfer{}, fmt.Errorf("error while retrieving dwolla transfer %s: %w", transferId, ErrDwollaNotFound)
+		}
+		return DwollaTransfer{}, fmt.Errorf("error while retrieving dwolla transfer: %v", err.Error())
+	}
+	return transfer, nil
+}
+
+// CancelTransfer asks Dwolla to cancel a transfer. Dwolla only allows this
+// while the transfer is pending.
+func CancelTransfer(ctx context.Context, transferUrl string) (DwollaTransfer, error) {
+	body := map[string]string{"status": DwollaTransferCancelled}
+	var transfer DwollaTransfer
+	if err := DwollaClient.Post(ctx, transferUrl, body, &http.Header{}, &transfer); err != nil {
+		log.Println(err.Error())
+		return DwollaTransfer{}, fmt.Errorf("error while cancelling dwolla transfer: %v", err.Error())
+	}
+	return transfer, nil
+}
+
+// RetrieveTransferFailure returns the ACH return code and description of a
+// failed transfer, e.g. "R01: Insufficient Funds".
+func RetrieveTransferFailure(ctx context.Context, transferId string) (string, error) {
+	var failure struct {
+		Code        string `json:"code"`
+		Description string `json:"description"`
+	}
+	if err := DwollaClient.Get(ctx, DwollaTransferUrl(transferId)+"/failure", nil, &http.Header{}, &failure); err != nil {
+		log.Println(err.Error())
+		return "", fmt.Errorf("error while retrieving dwolla transfer failure: %v", err.Error())
+	}
+	return fmt.Sprintf("%s: %s", failure.Code, failure.Description), nil
+}
+
 func RetrieveAccount(client *dwolla.Client) error {
 	ctx := context.Background()
 	res, err := client.Account.Retrieve(ctx)
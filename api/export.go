@@ -0,0 +1,393 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+)
+
+const (
+	ExportCsv = "csv"
+	ExportOfx = "ofx"
+	ExportQif = "qif"
+
+	// MaxExportDays bounds a single export request.
+	MaxExportDays = 366 * 7
+	// exportFlushEvery is how many lines are written between flushes to the
+	// client.
+	exportFlushEvery = 500
+)
+
+// CsvExportColumns is the CSV schema. Columns are only ever appended so
+// existing imports keep working.
+var CsvExportColumns = []string{
+	"date", "plaid_track_id", "account_mask", "source", "reference", "description",
+	"category", "amount", "direction", "status", "pending",
+}
+
+type ExportRequest struct {
+	UserId    string `json:"userId" binding:"required"`
+	TrackId   string `json:"plaidTrackId"`
+	StartDate string `json:"startDate" binding:"required"`
+	EndDate   string `json:"endDate" binding:"required"`
+	Format    string `json:"format" binding:"required"`
+}
+
+// exportAccount is what a writer knows about the account being exported.
+type exportAccount struct {
+	Bank        db.PlaidUser
+	Name        string
+	Mask        string
+	AccountType string
+	// Balance is the last snapshot on or before the end of the range.
+	Balance *db.BalanceSnapshot
+}
+
+// exportWriter renders activity in one file format. Lines arrive account by
+// account, oldest first, with money in positive and money out negative.
+type exportWriter interface {
+	Begin(startDate string, endDate string) error
+	BeginAccount(account exportAccount) error
+	Line(line StatementLine) error
+	EndAccount() error
+	End() error
+}
+
+// postedActivity reports whether a line belongs in a ledger import. Pending
+// and failed lines are left out of OFX and QIF since accounting tools cannot
+// update or remove them later.
+func postedActivity(line StatementLine) bool {
+	return !line.Pending && activityCounts(line)
+}
+
+type csvExportWriter struct {
+	csv     *csv.Writer
+	account exportAccount
+}
+
+// csvText keeps spreadsheets from running a text cell as a formula by
+// prefixing cells that start with a formula character with a quote.
+func csvText(value string) string {
+	if len(value) > 0 && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (w *csvExportWriter) Begin(startDate string, endDate string) error {
+	return w.csv.Write(CsvExportColumns)
+}
+
+func (w *csvExportWriter) BeginAccount(account exportAccount) error {
+	w.account = account
+	return nil
+}
+
+func (w *csvExportWriter) Line(line StatementLine) error {
+	direction := "credit"
+	if line.Amount < 0 {
+		direction = "debit"
+	}
+	return w.csv.Write([]string{
+		line.Date,
+		w.account.Bank.TrackId,
+		w.account.Mask,
+		line.Source,
+		csvText(line.Reference),
+		csvText(line.Description),
+		csvText(line.Category),
+		strconv.FormatFloat(line.Amount, 'f', 2, 64),
+		direction,
+		csvText(line.Status),
+		strconv.FormatBool(line.Pending),
+	})
+}
+
+func (w *csvExportWriter) EndAccount() error {
+	return nil
+}
+
+func (w *csvExportWriter) End() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// ofxExportWriter writes OFX 2.2 with one statement per account.
+type ofxExportWriter struct {
+	out       io.Writer
+	startDate string
+	endDate   string
+	now       time.Time
+	account   exportAccount
+	seen      map[string]int
+}
+
+func ofxDate(date string) string {
+	return strings.ReplaceAll(date, "-", "")
+}
+
+func ofxText(value string, limit int) string {
+	var escaped strings.Builder
+	runes := []rune(value)
+	if len(runes) > limit {
+		runes = runes[:limit]
+	}
+	xml.EscapeText(&escaped, []byte(string(runes)))
+	return escaped.String()
+}
+
+func (w *ofxExportWriter) Begin(startDate string, endDate string) error {
+	w.startDate, w.endDate = startDate, endDate
+	_, err := fmt.Fprintf(w.out, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1>
+`, w.now.UTC().Format("20060102150405"))
+	return err
+}
+
+func (w *ofxExportWriter) BeginAccount(account exportAccount) error {
+	w.account = account
+	w.seen = make(map[string]int)
+	accountType := "CHECKING"
+	if strings.EqualFold(account.AccountType, "savings") {
+		accountType = "SAVINGS"
+	}
+	_, err := fmt.Fprintf(w.out, `<STMTTRNRS><TRNUID>%s</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>USD</CURDEF>
+<BANKACCTFROM><BANKID>000000000</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>%s</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>
+`, ofxText(account.Bank.TrackId, 36), ofxText(account.Bank.TrackId, 22), accountType, ofxDate(w.startDate), ofxDate(w.endDate))
+	return err
+}
+
+func (w *ofxExportWriter) Line(line StatementLine) error {
+	if !postedActivity(line) {
+		return nil
+	}
+	transactionType := "CREDIT"
+	if line.Source == ActivitySourceFee {
+		transactionType = "FEE"
+	} else if line.Amount < 0 {
+		transactionType = "DEBIT"
+	}
+	// A transfer and its fee share a reference; FITID must be unique.
+	w.seen[line.Reference]++
+	fitId := line.Reference
+	if w.seen[line.Reference] > 1 {
+		fitId = fmt.Sprintf("%s-%d", line.Reference, w.seen[line.Reference])
+	}
+	name := line.Description
+	if len(name) == 0 {
+		name = line.Reference
+	}
+	memo := ""
+	if len(line.Category) > 0 {
+		memo = "<MEMO>" + ofxText(line.Category, 255) + "</MEMO>"
+	}
+	_, err := fmt.Fprintf(w.out, "<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%.2f</TRNAMT><FITID>%s</FITID><NAME>%s</NAME>%s</STMTTRN>\n",
+		transactionType, ofxDate(line.Date), line.Amount, ofxText(fitId, 255), ofxText(name, 32), memo)
+	return err
+}
+
+func (w *ofxExportWriter) EndAccount() error {
+	if _, err := io.WriteString(w.out, "</BANKTRANLIST>\n"); err != nil {
+		return err
+	}
+	// LEDGERBAL is required; without a snapshot it reports zero.
+	balance, asOf := 0.0, w.endDate
+	if w.account.Balance != nil {
+		balance, asOf = w.account.Balance.CurrentBalance, w.account.Balance.Date
+	}
+	_, err := fmt.Fprintf(w.out, "<LEDGERBAL><BALAMT>%.2f</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n</STMTRS></STMTTRNRS>\n", balance, ofxDate(asOf))
+	return err
+}
+
+func (w *ofxExportWriter) End() error {
+	_, err := io.WriteString(w.out, "</BANKMSGSRSV1>\n</OFX>\n")
+	return err
+}
+
+type qifExportWriter struct {
+	out io.Writer
+}
+
+// qifText keeps a value on one line, as QIF fields end at the newline.
+func qifText(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+func (w *qifExportWriter) Begin(startDate string, endDate string) error {
+	return nil
+}
+
+func (w *qifExportWriter) BeginAccount(account exportAccount) error {
+	name := account.Name
+	if len(account.Mask) > 0 {
+		name += " " + account.Mask
+	}
+	_, err := fmt.Fprintf(w.out, "!Account\nN%s\nTBank\n^\n!Type:Bank\n", qifText(name))
+	return err
+}
+
+func (w *qifExportWriter) Line(line StatementLine) error {
+	if !postedActivity(line) {
+		return nil
+	}
+	date, err := time.Parse("2006-01-02", line.Date)
+	if err != nil {
+		log.Println("skipping line with invalid date " + line.Reference)
+		return nil
+	}
+	_, err = fmt.Fprintf(w.out, "D%s\nT%.2f\nP%s\nL%s\nM%s\nC*\n^\n",
+		date.Format("01/02/2006"), line.Amount, qifText(line.Description), qifText(line.Category), qifText(line.Reference))
+	return err
+}
+
+func (w *qifExportWriter) EndAccount() error {
+	return nil
+}
+
+func (w *qifExportWriter) End() error {
+	return nil
+}
+
+// exportAccountDetails gathers the account name, mask and closing balance
+// from stored data so the export needs no Plaid calls.
+func exportAccountDetails(bank db.PlaidUser, endDate string) exportAccount {
+	account := exportAccount{Bank: bank, Name: bank.BankName, Mask: bank.AccountMask, AccountType: bank.AccountType}
+	if len(account.Name) == 0 {
+		account.Name = bank.TrackId
+	}
+	if snapshot, err := db.GetLastBalanceSnapshot(PgDb, bank.TrackId, endDate); err == nil {
+		account.Balance = &snapshot
+	}
+	return account
+}
+
+// ExportTransactions streams the activity of one bank, or all of the user's
+// banks, over a date range as CSV, OFX or QIF. Credit, loan and investment
+// items are not bank accounts and are left out. The bank's own legs of our
+// transfers are dropped by StreamAccountActivity, so each transfer is written
+// once.
+func ExportTransactions(c *gin.Context) {
+	var exportReq ExportRequest
+	if err := c.ShouldBindJSON(&exportReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	start, startErr := time.Parse("2006-01-02", exportReq.StartDate)
+	end, endErr := time.Parse("2006-01-02", exportReq.EndDate)
+	if startErr != nil || endErr != nil || end.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "startDate and endDate must look like 2024-01-31, with startDate first"})
+		return
+	}
+	if end.Sub(start) > MaxExportDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("an export can cover at most %d days", MaxExportDays)})
+		return
+	}
+
+	var banks []db.PlaidUser
+	if len(exportReq.TrackId) > 0 {
+		bank, err := db.GetRecordUsingTrackId(PgDb, exportReq.TrackId)
+		if err != nil || bank.UserId != exportReq.UserId {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no account found for user"})
+			return
+		}
+		if !IsFundingBank(bank) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bank " + bank.TrackId + " is linked for " + bank.LinkPurpose + ", use the net-worth endpoints"})
+			return
+		}
+		banks = []db.PlaidUser{bank}
+	} else {
+		records, err := db.GetAllRecordUsingUserId(PgDb, exportReq.UserId)
+		if err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, record := range records {
+			if IsFundingBank(record) {
+				banks = append(banks, record)
+			}
+		}
+	}
+
+	out := bufio.NewWriter(c.Writer)
+	var writer exportWriter
+	var contentType string
+	switch exportReq.Format {
+	case ExportCsv:
+		writer, contentType = &csvExportWriter{csv: csv.NewWriter(out)}, "text/csv; charset=utf-8"
+	case ExportOfx:
+		writer, contentType = &ofxExportWriter{out: out, now: time.Now()}, "application/x-ofx"
+	case ExportQif:
+		writer, contentType = &qifExportWriter{out: out}, "application/qif"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, ofx or qif"})
+		return
+	}
+
+	for _, bank := range banks {
+		if IsManualBank(bank) {
+			continue
+		}
+		if err := SyncTransactions(PgDb, bank); err != nil {
+			log.Println("unable to sync transactions before export: " + err.Error())
+		}
+	}
+
+	// Headers go out with the first write; errors after that can only be
+	// logged and end the stream early.
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=transactions-%s-%s.%s", exportReq.StartDate, exportReq.EndDate, exportReq.Format))
+	c.Status(http.StatusOK)
+
+	written := 0
+	err := writer.Begin(exportReq.StartDate, exportReq.EndDate)
+	for _, bank := range banks {
+		if err != nil {
+			break
+		}
+		if err = writer.BeginAccount(exportAccountDetails(bank, exportReq.EndDate)); err != nil {
+			break
+		}
+		err = StreamAccountActivity(PgDb, bank, exportReq.StartDate, exportReq.EndDate, func(line StatementLine) error {
+			if err := writer.Line(line); err != nil {
+				return err
+			}
+			written++
+			if written%exportFlushEvery == 0 {
+				if err := out.Flush(); err != nil {
+					return err
+				}
+				c.Writer.Flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = writer.EndAccount()
+		}
+	}
+	if err == nil {
+		err = writer.End()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Println("export ended early: " + err.Error())
+	}
+}
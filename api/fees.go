@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kolanos/dwolla-v2-go"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"gorm.io/gorm"
+)
+
+// FeeRule charges Flat plus Percent of the amount on transfers matching its
+// clearing speed and amount range. An empty Clearing matches every speed and a
+// zero MaxAmount has no upper bound. MinFee and MaxFee clamp the rule's fee.
+type FeeRule struct {
+	Name      string  `json:"name"`
+	Clearing  string  `json:"clearing"`
+	MinAmount float64 `json:"minAmount"`
+	MaxAmount float64 `json:"maxAmount"`
+	Flat      float64 `json:"flat"`
+	Percent   float64 `json:"percent"`
+	MinFee    float64 `json:"minFee"`
+	MaxFee    float64 `json:"maxFee"`
+}
+
+// FeeSchedule is evaluated in full; every matching rule adds its fee.
+var FeeSchedule = []FeeRule{
+	{Name: "instant", Clearing: ClearingNextAvailable, Percent: 1, MinFee: 0.25, MaxFee: 10},
+	{Name: "same-day", Clearing: ClearingSameDay, Flat: 1},
+	{Name: "large-transfer", MinAmount: 2500, Flat: 2},
+}
+
+type FeeLine struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+}
+
+type FeeQuote struct {
+	Amount   float64   `json:"amount"`
+	Clearing string    `json:"clearing"`
+	Fees     []FeeLine `json:"fees"`
+	TotalFee float64   `json:"totalFee"`
+	Total    float64   `json:"total"`
+}
+
+type FeeQuoteRequest struct {
+	Amount   string `json:"amount" binding:"required"`
+	Clearing string `json:"clearing"`
+}
+
+// TransferFee is a Dwolla facilitator fee charged to a customer on top of
+// the transfer.
+type TransferFee struct {
+	Links  dwolla.Links  `json:"_links"`
+	Amount dwolla.Amount `json:"amount"`
+}
+
+// LoadFeeSchedule replaces the default schedule with the JSON array in
+// FEE_SCHEDULE when it is set.
+func LoadFeeSchedule() {
+	value := os.Getenv("FEE_SCHEDULE")
+	if len(value) == 0 {
+		return
+	}
+	var schedule []FeeRule
+	if err := json.Unmarshal([]byte(value), &schedule); err != nil {
+		log.Println("invalid FEE_SCHEDULE, keeping the default: " + err.Error())
+		return
+	}
+	FeeSchedule = schedule
+}
+
+func CalculateFees(amount float64, clearing string) FeeQuote {
+	if len(clearing) == 0 {
+		clearing = ClearingStandard
+	}
+	quote := FeeQuote{Amount: roundAmount(amount), Clearing: clearing, Fees: []FeeLine{}}
+	for _, rule := range FeeSchedule {
+		if len(rule.Clearing) > 0 && rule.Clearing != clearing {
+			continue
+		}
+		if amount < rule.MinAmount || (rule.MaxAmount > 0 && amount > rule.MaxAmount) {
+			continue
+		}
+		fee := rule.Flat + amount*rule.Percent/100
+		fee = math.Max(fee, rule.MinFee)
+		if rule.MaxFee > 0 {
+			fee = math.Min(fee, rule.MaxFee)
+		}
+		fee = roundAmount(fee)
+		if fee <= 0 {
+			continue
+		}
+		quote.Fees = append(quote.Fees, FeeLine{Name: rule.Name, Amount: fee})
+		quote.TotalFee += fee
+	}
+	quote.TotalFee = roundAmount(quote.TotalFee)
+	quote.Total = roundAmount(quote.Amount + quote.TotalFee)
+	return quote
+}
+
+// FacilitatorFees builds the Dwolla fee entry that charges the total fee to
+// the sender's customer.
+func FacilitatorFees(chargeToCustomerUrl string, totalFee float64) []TransferFee {
+	if totalFee <= 0 {
+		return nil
+	}
+	return []TransferFee{{
+		Links:  dwolla.Links{"charge-to": dwolla.Link{Href: chargeToCustomerUrl}},
+		Amount: dwolla.Amount{Currency: dwolla.USD, Value: strconv.FormatFloat(totalFee, 'f', 2, 64)},
+	}}
+}
+
+// SenderCustomerUrl resolves the Dwolla customer that owns the sending bank.
+// Banks linked before customers were stored fall back to the funding source.
+func SenderCustomerUrl(bankdb *gorm.DB, senderBank db.PlaidUser) (string, error) {
+	if len(senderBank.DwollaCustomerId) > 0 {
+		if customer, err := db.GetDwollaCustomerUsingId(bankdb, senderBank.DwollaCustomerId); err == nil {
+			return customer.CustomerUrl, nil
+		}
+		return fmt.Sprintf("%s/customers/%s", DwollaBaseUrl, senderBank.DwollaCustomerId), nil
+	}
+	return RetrieveFundingSourceCustomerUrl(context.Background(), senderBank.FundingSourceUrl)
+}
+
+func QuoteTransferFee(c *gin.Context) {
+	var quoteReq FeeQuoteRequest
+	if err := c.ShouldBindJSON(&quoteReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	amount, err := strconv.ParseFloat(quoteReq.Amount, 64)
+	if !amountPattern.MatchString(quoteReq.Amount) || err != nil || amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("amount %q must be a positive value with at most two decimals", quoteReq.Amount)})
+		return
+	}
+	if rejections := ValidateTransferOptions(PaymentTransfer{Clearing: quoteReq.Clearing}); len(rejections) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejections[0].Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": CalculateFees(amount, quoteReq.Clearing)})
+}
@@ -0,0 +1,50 @@
+package api
+
+import "testing"
+
+func TestCalculateFees(t *testing.T) {
+	defaultSchedule := FeeSchedule
+	t.Cleanup(func() { FeeSchedule = defaultSchedule })
+	FeeSchedule = []FeeRule{
+		{Name: "instant", Clearing: ClearingNextAvailable, Percent: 1, MinFee: 0.25, MaxFee: 10},
+		{Name: "same-day", Clearing: ClearingSameDay, Flat: 1},
+		{Name: "large-transfer", MinAmount: 2500, Flat: 2},
+	}
+
+	tests := []struct {
+		name         string
+		amount       float64
+		clearing     string
+		wantClearing string
+		wantFees     int
+		wantTotalFee float64
+		wantTotal    float64
+	}{
+		{"standard is free", 100, ClearingStandard, ClearingStandard, 0, 0, 100},
+		{"empty clearing is standard", 100, "", ClearingStandard, 0, 0, 100},
+		{"instant percent", 100, ClearingNextAvailable, ClearingNextAvailable, 1, 1, 101},
+		{"instant minimum fee", 10, ClearingNextAvailable, ClearingNextAvailable, 1, 0.25, 10.25},
+		{"instant maximum fee", 2000, ClearingNextAvailable, ClearingNextAvailable, 1, 10, 2010},
+		{"instant rounds to cents", 33.33, ClearingNextAvailable, ClearingNextAvailable, 1, 0.33, 33.66},
+		{"same-day flat", 100, ClearingSameDay, ClearingSameDay, 1, 1, 101},
+		{"large transfer", 2500, ClearingStandard, ClearingStandard, 1, 2, 2502},
+		{"rules add up", 3000, ClearingSameDay, ClearingSameDay, 2, 3, 3003},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote := CalculateFees(tt.amount, tt.clearing)
+			if quote.Clearing != tt.wantClearing {
+				t.Errorf("Clearing = %q, want %q", quote.Clearing, tt.wantClearing)
+			}
+			if len(quote.Fees) != tt.wantFees {
+				t.Errorf("got %d fee lines, want %d: %+v", len(quote.Fees), tt.wantFees, quote.Fees)
+			}
+			if quote.TotalFee != tt.wantTotalFee {
+				t.Errorf("TotalFee = %v, want %v", quote.TotalFee, tt.wantTotalFee)
+			}
+			if quote.Total != tt.wantTotal {
+				t.Errorf("Total = %v, want %v", quote.Total, tt.wantTotal)
+			}
+		})
+	}
+}
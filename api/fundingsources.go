@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+)
+
+const (
+	BankSourcePlaid  = "plaid"
+	BankSourceManual = "manual"
+
+	FundingSourceVerified   = "verified"
+	FundingSourceUnverified = "unverified"
+	// FundingSourcePending means micro-deposits were sent and are waiting for
+	// the user to confirm the amounts.
+	FundingSourcePending = "pending"
+	// FundingSourceFailed means Dwolla removed the funding source after too
+	// many wrong micro-deposit attempts; the bank has to be added again.
+	FundingSourceFailed = "failed"
+)
+
+var (
+	routingNumberPattern = regexp.MustCompile(`^\d{9}$`)
+	accountNumberPattern = regexp.MustCompile(`^\d{4,17}$`)
+	microDepositPattern  = regexp.MustCompile(`^0\.\d{2}$`)
+)
+
+type ManualFundingSourceRequest struct {
+	UserId          string `json:"userId" binding:"required"`
+	RoutingNumber   string `json:"routingNumber" binding:"required"`
+	AccountNumber   string `json:"accountNumber" binding:"required"`
+	BankAccountType string `json:"bankAccountType" binding:"required"`
+	Name            string `json:"name" binding:"required"`
+}
+
+type MicroDepositRetryRequest struct {
+	UserId  string `json:"userId" binding:"required"`
+	TrackId string `json:"plaidTrackId" binding:"required"`
+}
+
+type MicroDepositRequest struct {
+	UserId  string `json:"userId" binding:"required"`
+	TrackId string `json:"plaidTrackId" binding:"required"`
+	Amount1 string `json:"amount1" binding:"required"`
+	Amount2 string `json:"amount2" binding:"required"`
+}
+
+func IsManualBank(bank db.PlaidUser) bool {
+	return bank.Source == BankSourceManual
+}
+
+// IsFundingBank reports whether the bank has a Dwolla funding source. Credit,
+// loan and investment links are only read.
+func IsFundingBank(bank db.PlaidUser) bool {
+	return len(bank.LinkPurpose) == 0 || bank.LinkPurpose == LinkPurposeFunding
+}
+
+// IsFundingSourceVerified reports whether the bank can send money. Banks
+// linked through Plaid are verified when they are linked.
+func IsFundingSourceVerified(bank db.PlaidUser) bool {
+	return len(bank.VerificationStatus) == 0 || bank.VerificationStatus == FundingSourceVerified
+}
+
+// NewTrackId builds a bank's track id from up to three letters of the owner's
+// first name and the time it was added. Short names are padded with X.
+func NewTrackId(firstName string, now time.Time) string {
+	prefix := []rune(strings.ToUpper(strings.TrimSpace(firstName)))
+	if len(prefix) > 3 {
+		prefix = prefix[:3]
+	}
+	return fmt.Sprintf("PLAID%s%s%v", string(prefix), strings.Repeat("X", 3-len(prefix)), now.Format("20060102150405"))
+}
+
+// validRoutingNumber applies the ABA checksum.
+func validRoutingNumber(routingNumber string) bool {
+	if !routingNumberPattern.MatchString(routingNumber) {
+		return false
+	}
+	weights := []int{3, 7, 1}
+	sum := 0
+	for i, digit := range routingNumber {
+		sum += int(digit-'0') * weights[i%3]
+	}
+	return sum%10 == 0
+}
+
+// ManualBankAccount describes a bank added with micro-deposits from the
+// stored details, since there is no Plaid item to read it from.
+func ManualBankAccount(bank db.PlaidUser) Account {
+	return Account{
+		Id:           bank.AccountId,
+		Name:         bank.BankName,
+		OfficialName: bank.BankName,
+		Mask:         bank.AccountMask,
+		Type:         "depository",
+		SubType:      bank.AccountType,
+		PlaidTrackId: bank.TrackId,
+		ShareableId:  bank.ShareableId,
+	}
+}
+
+func AddManualFundingSource(c *gin.Context) {
+	var fundingReq ManualFundingSourceRequest
+	if err := c.ShouldBindJSON(&fundingReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if !validRoutingNumber(fundingReq.RoutingNumber) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid routing number"})
+		return
+	}
+	if !accountNumberPattern.MatchString(fundingReq.AccountNumber) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account number must be 4 to 17 digits"})
+		return
+	}
+	if fundingReq.BankAccountType != "checking" && fundingReq.BankAccountType != "savings" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bankAccountType must be checking or savings"})
+		return
+	}
+
+	customer, err := db.GetDwollaCustomerUsingUserId(PgDb, fundingReq.UserId)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "no dwolla customer for user, create one first"})
+		return
+	}
+	if customer.Status == DwollaCustomerSuspended || customer.Status == DwollaCustomerDeactivated {
+		c.JSON(http.StatusForbidden, gin.H{"error": "dwolla customer is " + customer.Status})
+		return
+	}
+
+	ctx := context.Background()
+	fundingSrcId, fundingSrcUrl, err := CreateBankFundingSource(ctx, customer.CustomerUrl, BankFundingSourceRequest{
+		RoutingNumber:   fundingReq.RoutingNumber,
+		AccountNumber:   fundingReq.AccountNumber,
+		BankAccountType: fundingReq.BankAccountType,
+		Name:            fundingReq.Name,
+	})
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The funding source id stands in for the Plaid account id, so the bank
+	// gets a shareable id like any linked bank.
+	trackId := NewTrackId(customer.FirstName, time.Now())
+	newBank := db.PlaidUser{
+		TrackId:            trackId,
+		AccountId:          fundingSrcId,
+		FundingSourceUrl:   fundingSrcUrl,
+		FundingSourceId:    fundingSrcId,
+		DwollaCustomerId:   customer.CustomerId,
+		ShareableId:        utils.EncryptID(fundingSrcId),
+		UserId:             fundingReq.UserId,
+		Source:             BankSourceManual,
+		VerificationStatus: FundingSourceUnverified,
+		BankName:           fundingReq.Name,
+		AccountMask:        fundingReq.AccountNumber[len(fundingReq.AccountNumber)-4:],
+		AccountType:        fundingReq.BankAccountType,
+	}
+	if err := db.CreateBankAccount(PgDb, newBank); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The bank is kept when the deposits cannot be sent so the user can
+	// retry them with RetryMicroDeposits.
+	if err := InitiateMicroDeposits(ctx, fundingSrcUrl); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "plaidTrackId": trackId, "verificationStatus": newBank.VerificationStatus})
+		return
+	}
+	if err := db.UpdateFundingSourceVerification(PgDb, trackId, FundingSourcePending); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	newBank.VerificationStatus = FundingSourcePending
+
+	c.JSON(http.StatusOK, gin.H{"message": "Micro-Deposits Initiated", "account": ManualBankAccount(newBank), "verificationStatus": newBank.VerificationStatus})
+}
+
+// RetryMicroDeposits sends the micro-deposits again for a manual bank whose
+// first attempt failed.
+func RetryMicroDeposits(c *gin.Context) {
+	var retryReq MicroDepositRetryRequest
+	if err := c.ShouldBindJSON(&retryReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	bank, err := db.GetRecordUsingTrackId(PgDb, retryReq.TrackId)
+	if err != nil || bank.UserId != retryReq.UserId || !IsManualBank(bank) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no account found for user"})
+		return
+	}
+	if bank.VerificationStatus != FundingSourceUnverified {
+		c.JSON(http.StatusConflict, gin.H{"error": "micro-deposits were already initiated", "verificationStatus": bank.VerificationStatus})
+		return
+	}
+
+	if err := InitiateMicroDeposits(context.Background(), bank.FundingSourceUrl); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "verificationStatus": bank.VerificationStatus})
+		return
+	}
+	if err := db.UpdateFundingSourceVerification(PgDb, bank.TrackId, FundingSourcePending); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Micro-Deposits Initiated", "verificationStatus": FundingSourcePending})
+}
+
+// MicroDepositVerificationStatus maps a Dwolla funding source to the local
+// verification status. Micro-deposits are still pending while Dwolla offers to
+// verify them; without that link they have to be initiated again.
+func MicroDepositVerificationStatus(fundingSource DwollaFundingSource) string {
+	switch {
+	case fundingSource.Removed:
+		return FundingSourceFailed
+	case fundingSource.Status == FundingSourceVerified:
+		return FundingSourceVerified
+	}
+	if _, ok := fundingSource.Links["verify-micro-deposits"]; ok {
+		return FundingSourcePending
+	}
+	return FundingSourceUnverified
+}
+
+func VerifyManualFundingSource(c *gin.Context) {
+	var depositReq MicroDepositRequest
+	if err := c.ShouldBindJSON(&depositReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if !microDepositPattern.MatchString(depositReq.Amount1) || !microDepositPattern.MatchString(depositReq.Amount2) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "micro-deposit amounts must look like 0.03"})
+		return
+	}
+
+	bank, err := db.GetRecordUsingTrackId(PgDb, depositReq.TrackId)
+	if err != nil || bank.UserId != depositReq.UserId || !IsManualBank(bank) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no account found for user"})
+		return
+	}
+	if bank.VerificationStatus == FundingSourceVerified {
+		c.JSON(http.StatusOK, gin.H{"message": "Funding Source Already Verified", "verificationStatus": bank.VerificationStatus})
+		return
+	}
+	if bank.VerificationStatus != FundingSourcePending {
+		c.JSON(http.StatusConflict, gin.H{"error": "micro-deposits have not been initiated", "verificationStatus": bank.VerificationStatus})
+		return
+	}
+
+	ctx := context.Background()
+	if err := VerifyMicroDeposits(ctx, bank.FundingSourceUrl, depositReq.Amount1, depositReq.Amount2); err != nil {
+		log.Println(err.Error())
+		// A wrong attempt may still have left the funding source verified,
+		// reset or removed on Dwolla's side; keep the local state in line.
+		status := bank.VerificationStatus
+		if fundingSource, fetchErr := RetrieveFundingSource(ctx, bank.FundingSourceUrl); fetchErr != nil {
+			log.Println(fetchErr.Error())
+		} else {
+			status = MicroDepositVerificationStatus(fundingSource)
+		}
+		if status != FundingSourceVerified {
+			if status != bank.VerificationStatus {
+				if updateErr := db.UpdateFundingSourceVerification(PgDb, bank.TrackId, status); updateErr != nil {
+					log.Println(updateErr.Error())
+				}
+			}
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "verificationStatus": status})
+			return
+		}
+	}
+
+	if err := db.UpdateFundingSourceVerification(PgDb, bank.TrackId, FundingSourceVerified); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Funding Source Verified Successfully", "verificationStatus": FundingSourceVerified})
+}
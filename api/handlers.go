@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -19,9 +20,10 @@ import (
 var PgDb *gorm.DB
 
 type User struct {
-	UserId string `json:"userId" binding:"required"`
-	Email  string `json:"email" binding:"required"`
-	Name   string `json:"name" binding:"required"`
+	UserId  string `json:"userId" binding:"required"`
+	Email   string `json:"email" binding:"required"`
+	Name    string `json:"name" binding:"required"`
+	Purpose string `json:"purpose"`
 }
 
 type TrackIdRequest struct {
@@ -33,13 +35,22 @@ type BankUserId struct {
 }
 
 type TransactionRequest struct {
-	Name           string `json:"name"`
-	Amount         string `json:"amount"`
-	SenderId       string `json:"senderId"`
-	SenderBankId   string `json:"senderBankId"`
-	ReceiverId     string `json:"receiverId"`
-	ReceiverBankId string `json:"receiverBankId"`
-	Email          string `json:"email"`
+	Name                  string `json:"name"`
+	Amount                string `json:"amount"`
+	SenderId              string `json:"senderId"`
+	SenderBankId          string `json:"senderBankId"`
+	ReceiverId            string `json:"receiverId"`
+	ReceiverBankId        string `json:"receiverBankId"`
+	Email                 string `json:"email"`
+	DwollaTransferId      string `json:"dwollaTransferId"`
+	DwollaTransferUrl     string `json:"dwollaTransferUrl"`
+	SourceFundingSourceId string `json:"sourceFundingSourceId"`
+	DestFundingSourceId   string `json:"destFundingSourceId"`
+	Status                string `json:"status"`
+	RequestedClearing     string `json:"requestedClearing"`
+	ActualClearing        string `json:"actualClearing"`
+	CorrelationId         string `json:"correlationId"`
+	FeeAmount             string `json:"feeAmount"`
 }
 
 type TransactionsUsingBankId struct {
@@ -48,11 +59,15 @@ type TransactionsUsingBankId struct {
 }
 
 type PaymentTransfer struct {
-	Name        string `json:"name"`
-	Email       string `json:"email"`
-	Amount      string `json:"amount"`
-	SenderBank  string `json:"senderBank"`
-	ShareableId string `json:"sharableId"`
+	Name          string            `json:"name"`
+	Email         string            `json:"email"`
+	Amount        string            `json:"amount"`
+	SenderBank    string            `json:"senderBank"`
+	ShareableId   string            `json:"sharableId"`
+	PayeeId       string            `json:"payeeId"`
+	Clearing      string            `json:"clearing"`
+	Metadata      map[string]string `json:"metadata"`
+	CorrelationId string            `json:"correlationId"`
 }
 
 type Account struct {
@@ -76,18 +91,27 @@ type BankUser struct {
 	LastName          string `json:"lastName"`
 	DwollaCustomerUrl string `json:"dwollaCustomerUrl"`
 	DwollaCustomerId  string `json:"dwollaCustomerId"`
+	CustomerType      string `json:"customerType"`
 	Address1          string `json:"address1"`
 	City              string `json:"city"`
 	State             string `json:"state"`
 	PostalCode        string `json:"postalCode"`
-	DateOfBirth       string `json:"dateOfBbirth"`
+	DateOfBirth       string `json:"dateOfBirth"`
 	AadharNo          string `json:"aadharNo"`
+	Ssn               string `json:"ssn"`
 	UserId            string `json:"userId"`
 }
 
+type CategoryOverrideRequest struct {
+	UserId        string `json:"userId" binding:"required"`
+	TransactionId string `json:"transactionId" binding:"required"`
+	Category      string `json:"category" binding:"required"`
+}
+
 type PlaidAccount struct {
 	PublicToken string   `json:"publicToken"`
 	PlaidUser   BankUser `json:"user"`
+	Purpose     string   `json:"purpose"`
 }
 
 func GenerateLinkToken(c *gin.Context) {
@@ -99,7 +123,13 @@ func GenerateLinkToken(c *gin.Context) {
 		return
 	}
 
-	linkToken, err := CreatePlaidLinkToken(plaidUser)
+	purpose, err := ResolveLinkPurpose(plaidUser.Purpose)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	linkToken, err := CreatePlaidLinkToken(plaidUser, purpose)
 	if err != nil {
 		log.Println(err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -117,7 +147,29 @@ func GenerateAccessToken(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
 		return
 	}
-	log.Println("Public Token: ", plaidAccount.PublicToken, "| Plaid User: ", plaidAccount.PlaidUser)
+	log.Println("Exchanging Public Token For User: ", plaidAccount.PlaidUser.UserId)
+
+	purpose, err := ResolveLinkPurpose(plaidAccount.Purpose)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if purpose != LinkPurposeFunding {
+		linkNetWorthItem(c, plaidAccount, purpose)
+		return
+	}
+
+	// The Dwolla customer comes from our own records for the user; a customer
+	// URL in the request body is ignored.
+	customer, err := db.GetDwollaCustomerUsingUserId(PgDb, plaidAccount.PlaidUser.UserId)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "no dwolla customer for user, create one first"})
+		return
+	}
+	if customer.Status == DwollaCustomerSuspended || customer.Status == DwollaCustomerDeactivated {
+		c.JSON(http.StatusForbidden, gin.H{"error": "dwolla customer is " + customer.Status})
+		return
+	}
 
 	accessToken, itemId, err := ExchangePublicToken(plaidAccount.PublicToken)
 	if err != nil {
@@ -137,6 +189,31 @@ func GenerateAccessToken(c *gin.Context) {
 	}
 	log.Println("Account ID: ", accountId, "| Bank Name: ", bankName)
 
+	// Check the account holder against the names, email and address on our
+	// Dwolla customer before any funding source exists; the request body is
+	// not trusted for this. Unverified customers have no address on file.
+	profile := BankUser{
+		FirstName:  customer.FirstName,
+		LastName:   customer.LastName,
+		Email:      customer.Email,
+		Address1:   customer.Address1,
+		PostalCode: customer.PostalCode,
+	}
+	identityCheck, identity := VerifyBankIdentity(accessToken, accountId, profile)
+	identity.UserId = plaidAccount.PlaidUser.UserId
+	identity.ItemId = itemId
+	identity.AccountId = accountId
+	if identityCheck.Status == IdentityMismatch && IdentityEnforce {
+		if err := db.AddBankIdentity(PgDb, identity); err != nil {
+			log.Println(err.Error())
+		}
+		if err := RemoveItem(accessToken); err != nil {
+			log.Println(err.Error())
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "the bank account holder does not match the user", "identity": identityCheck})
+		return
+	}
+
 	processorToken, err := CreataDwollaAccount(accessToken, accountId)
 	if err != nil {
 		log.Println(err.Error())
@@ -145,7 +222,7 @@ func GenerateAccessToken(c *gin.Context) {
 	}
 	log.Println("Processor Token: ", processorToken)
 
-	fundingSrcUrl, err := AddFundingSource(plaidAccount.PlaidUser.DwollaCustomerUrl, processorToken, bankName)
+	fundingSrcId, fundingSrcUrl, err := AddFundingSource(customer.CustomerUrl, processorToken, bankName)
 	if err != nil {
 		log.Println(err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -153,16 +230,24 @@ func GenerateAccessToken(c *gin.Context) {
 	}
 
 	shareableId := utils.EncryptID(accountId)
-	trackId := fmt.Sprintf("PLAID%s%v", strings.ToUpper(plaidAccount.PlaidUser.FirstName[:3]), time.Now().Format("20060102150405"))
+	trackId := NewTrackId(plaidAccount.PlaidUser.FirstName, time.Now())
 
 	newPlaidUser := db.PlaidUser{
-		TrackId:          trackId,
-		AccountId:        accountId,
-		BankId:           itemId,
-		AccessToken:      accessToken,
-		FundingSourceUrl: fundingSrcUrl,
-		ShareableId:      shareableId,
-		UserId:           plaidAccount.PlaidUser.UserId,
+		TrackId:            trackId,
+		AccountId:          accountId,
+		BankId:             itemId,
+		AccessToken:        accessToken,
+		FundingSourceUrl:   fundingSrcUrl,
+		FundingSourceId:    fundingSrcId,
+		DwollaCustomerId:   customer.CustomerId,
+		ShareableId:        shareableId,
+		UserId:             plaidAccount.PlaidUser.UserId,
+		Source:             BankSourcePlaid,
+		VerificationStatus: FundingSourceVerified,
+		BankName:           bankName,
+		AccountMask:        accountData.GetMask(),
+		AccountType:        string(accountData.GetSubtype()),
+		IdentityStatus:     identityCheck.Status,
 	}
 
 	if err = db.CreateBankAccount(PgDb, newPlaidUser); err != nil {
@@ -170,6 +255,10 @@ func GenerateAccessToken(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	identity.TrackId = trackId
+	if err := db.AddBankIdentity(PgDb, identity); err != nil {
+		log.Println(err.Error())
+	}
 
 	plaidUserFromDb, err := db.GetRecordUsingTrackId(PgDb, trackId)
 	if err != nil {
@@ -182,6 +271,56 @@ func GenerateAccessToken(c *gin.Context) {
 
 }
 
+// linkNetWorthItem stores a credit, loan or investment item. These items are
+// only read for the net-worth view, so no Dwolla funding source is created
+// and they have no shareable id to receive money.
+func linkNetWorthItem(c *gin.Context, plaidAccount PlaidAccount, purpose string) {
+	accessToken, itemId, err := ExchangePublicToken(plaidAccount.PublicToken)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	accountData, _, err := GetAccounts(accessToken)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	trackId := NewTrackId(plaidAccount.PlaidUser.FirstName, time.Now())
+	newPlaidUser := db.PlaidUser{
+		TrackId:     trackId,
+		AccountId:   accountData.GetAccountId(),
+		BankId:      itemId,
+		AccessToken: accessToken,
+		UserId:      plaidAccount.PlaidUser.UserId,
+		Source:      BankSourcePlaid,
+		BankName:    accountData.GetName(),
+		AccountMask: accountData.GetMask(),
+		AccountType: string(accountData.GetSubtype()),
+		LinkPurpose: purpose,
+	}
+	if err = db.CreateBankAccount(PgDb, newPlaidUser); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := RefreshNetWorthItem(PgDb, newPlaidUser); err != nil {
+		log.Println("unable to load " + purpose + " for " + trackId + ": " + err.Error())
+	}
+
+	plaidUserFromDb, err := db.GetRecordUsingTrackId(PgDb, trackId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Plaid Account Linked Successfully", "plaidUser": plaidUserFromDb})
+}
+
 func CreateDwollaCustomerId(c *gin.Context) {
 	var dwollaUser BankUser
 	if err := c.ShouldBindJSON(&dwollaUser); err != nil {
@@ -190,14 +329,24 @@ func CreateDwollaCustomerId(c *gin.Context) {
 		return
 	}
 
-	customerId, customerUrl, err := CreateDwollaCustomer(dwollaUser)
+	customer, created, err := EnsureDwollaCustomer(PgDb, dwollaUser)
 	if err != nil {
 		log.Println(err.Error())
+		var invalidErr *InvalidCustomerError
+		if errors.As(err, &invalidErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var duplicateErr *DuplicateCustomerError
+		if errors.As(err, &duplicateErr) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"customer_id": customerId, "customer_url": customerUrl})
+	c.JSON(http.StatusOK, gin.H{"customer_id": customer.CustomerId, "customer_url": customer.CustomerUrl, "status": customer.Status, "created": created})
 }
 
 func GetBankAccounts(c *gin.Context) {
@@ -220,6 +369,15 @@ func GetBankAccounts(c *gin.Context) {
 	var accounts []Account
 
 	for _, eachRecord := range plaidDBRecords {
+		if IsManualBank(eachRecord) {
+			accounts = append(accounts, ManualBankAccount(eachRecord))
+			continue
+		}
+		// Credit, loan and investment items are reported by the net-worth
+		// endpoints; their balances are owed or invested, not cash.
+		if !IsFundingBank(eachRecord) {
+			continue
+		}
 		accountData, accountItem, err := GetAccounts(eachRecord.AccessToken)
 		// log.Println("AccountData: ", accountData, "| accountItem: ", accountItem)
 		if err != nil {
@@ -236,6 +394,7 @@ func GetBankAccounts(c *gin.Context) {
 		}
 		log.Println("Avail Bal: ", availableBal)
 		currentBal := strconv.FormatFloat(float64(accountData.Balances.GetCurrent()), 'f', -1, 32)
+		RecordBalanceSnapshot(PgDb, eachRecord, accountData, time.Now())
 
 		institutionId, _ := GetDefaultInstitutionId(accountItem)
 
@@ -248,7 +407,7 @@ func GetBankAccounts(c *gin.Context) {
 			OfficialName:     accountData.GetOfficialName(),
 			Mask:             accountData.GetMask(),
 			Type:             string(accountData.GetType()),
-			SubType:          string(*accountData.Subtype.Get()),
+			SubType:          string(accountData.GetSubtype()),
 			PlaidTrackId:     eachRecord.TrackId,
 			ShareableId:      eachRecord.ShareableId,
 		}
@@ -261,6 +420,10 @@ func GetBankAccounts(c *gin.Context) {
 	totalBanks := len(accounts)
 	var totalCurrentBalance float64
 	for _, eachAccount := range accounts {
+		// Banks verified with micro-deposits have no balance to report.
+		if len(eachAccount.CurrentBalance) == 0 {
+			continue
+		}
 		currentBal, err := strconv.ParseFloat(eachAccount.CurrentBalance, 64)
 		if err != nil {
 			log.Println("unable to convert current balance str to float64: " + err.Error())
@@ -291,6 +454,21 @@ func GetBankAccount(c *gin.Context) {
 		return
 	}
 
+	if IsManualBank(bankDetails) {
+		transactions, err := GetTransferTransactionsForBank(PgDb, bankDetails)
+		if err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": ManualBankAccount(bankDetails), "transactions": transactions})
+		return
+	}
+	if !IsFundingBank(bankDetails) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bank " + bankDetails.TrackId + " is linked for " + bankDetails.LinkPurpose + ", use the net-worth endpoints"})
+		return
+	}
+
 	accountData, accountItem, err := GetAccounts(bankDetails.AccessToken)
 	if err != nil {
 		log.Println(err.Error())
@@ -305,35 +483,18 @@ func GetBankAccount(c *gin.Context) {
 		log.Println("Account Available Balance nil")
 	}
 	currentBal := strconv.FormatFloat(float64(accountData.Balances.GetCurrent()), 'f', -1, 32)
+	RecordBalanceSnapshot(PgDb, bankDetails, accountData, time.Now())
 
 	institutionId, _ := GetDefaultInstitutionId(accountItem)
 
-	transferTransactionsData, err := GetTransactionsByBankId(PgDb, bankDetails.TrackId)
+	transferTransactions, err := GetTransferTransactionsForBank(PgDb, bankDetails)
 	if err != nil {
 		log.Println(err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var transferTransactions []PlaidTransaction
-	for _, eachTransaction := range transferTransactionsData.Documents {
-		transferTransactions = append(transferTransactions, PlaidTransaction{
-			Id:             eachTransaction.TransactionId,
-			Name:           eachTransaction.Name,
-			Amount:         eachTransaction.Amount,
-			Date:           utils.ExtractTimeStamp(eachTransaction.TransactionId),
-			PaymentChannel: eachTransaction.Channel,
-			Category:       eachTransaction.Category,
-			Type: func() string {
-				if eachTransaction.SenderBankId == bankDetails.TrackId {
-					return "debit"
-				}
-				return "credit"
-			}(),
-		})
-	}
-
-	transactions, err := GetTransactionsFromPlaid(bankDetails.AccessToken)
+	transactions, err := GetTransactionsFromPlaid(PgDb, bankDetails)
 	if err != nil {
 		log.Println(err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -349,7 +510,7 @@ func GetBankAccount(c *gin.Context) {
 		OfficialName:     accountData.GetOfficialName(),
 		Mask:             accountData.GetMask(),
 		Type:             string(accountData.GetType()),
-		SubType:          string(*accountData.Subtype.Get()),
+		SubType:          string(accountData.GetSubtype()),
 		PlaidTrackId:     bankDetails.TrackId,
 		ShareableId:      bankDetails.ShareableId,
 	}
@@ -374,16 +535,29 @@ func GetBankAccount(c *gin.Context) {
 
 func CreateTransaction(bankdb *gorm.DB, transactionReq TransactionRequest) (db.Transaction, error) {
 
-	transactionId := fmt.Sprintf("TRANSCT%v", time.Now().Format("20060102150405"))
+	now := time.Now()
+	transactionId := utils.GenerateIdAt("TRANSCT", now)
 	transactionRecord := db.Transaction{
-		TransactionId:  transactionId,
-		Amount:         transactionReq.Amount,
-		Channel:        "online",
-		Category:       "Transfer",
-		SenderId:       transactionReq.SenderId,
-		ReceiverId:     transactionReq.ReceiverId,
-		SenderBankId:   transactionReq.SenderBankId,
-		ReceiverBankId: transactionReq.ReceiverBankId,
+		TransactionId:         transactionId,
+		Name:                  transactionReq.Name,
+		Amount:                transactionReq.Amount,
+		Channel:               "online",
+		Category:              TransferOutCategory,
+		SenderId:              transactionReq.SenderId,
+		ReceiverId:            transactionReq.ReceiverId,
+		SenderBankId:          transactionReq.SenderBankId,
+		ReceiverBankId:        transactionReq.ReceiverBankId,
+		DwollaTransferId:      transactionReq.DwollaTransferId,
+		DwollaTransferUrl:     transactionReq.DwollaTransferUrl,
+		SourceFundingSourceId: transactionReq.SourceFundingSourceId,
+		DestFundingSourceId:   transactionReq.DestFundingSourceId,
+		Status:                transactionReq.Status,
+		RequestedClearing:     transactionReq.RequestedClearing,
+		ActualClearing:        transactionReq.ActualClearing,
+		CorrelationId:         transactionReq.CorrelationId,
+		FeeAmount:             transactionReq.FeeAmount,
+		InitiatedAt:           &now,
+		StatusUpdatedAt:       &now,
 	}
 
 	if err := db.AddTransaction(bankdb, transactionRecord); err != nil {
@@ -398,6 +572,54 @@ func CreateTransaction(bankdb *gorm.DB, transactionReq TransactionRequest) (db.T
 	return transaction, nil
 }
 
+// GetTransferTransactionsForBank returns the internal transfers of a bank in
+// the synced transaction shape, with the user's category overrides applied.
+func GetTransferTransactionsForBank(bankdb *gorm.DB, bankDetails db.PlaidUser) ([]PlaidTransaction, error) {
+	transferTransactionsData, err := GetTransactionsByBankId(bankdb, bankDetails.TrackId)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := db.GetCategoryOverridesUsingUserId(bankdb, bankDetails.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	var transferTransactions []PlaidTransaction
+	for _, eachTransaction := range transferTransactionsData.Documents {
+		transferTransactions = append(transferTransactions, ConvertTransferToPlaidTransaction(eachTransaction, bankDetails.TrackId, overrides))
+	}
+	return transferTransactions, nil
+}
+
+// ConvertTransferToPlaidTransaction maps an internal transfer to the shape of a
+// synced transaction as seen from the given bank, so a transfer is TRANSFER_OUT
+// for the sender and TRANSFER_IN for the receiver.
+func ConvertTransferToPlaidTransaction(transaction db.Transaction, trackId string, overrides map[string]string) PlaidTransaction {
+	transferType, category := "credit", TransferInCategory
+	if transaction.SenderBankId == trackId {
+		transferType, category = "debit", TransferOutCategory
+	}
+	plaidTransaction := PlaidTransaction{
+		Id:               transaction.TransactionId,
+		Name:             transaction.Name,
+		Amount:           transaction.Amount,
+		Date:             utils.ExtractTimeStamp(transaction.TransactionId),
+		PaymentChannel:   transaction.Channel,
+		Category:         category,
+		CategoryDetailed: category + "_ACCOUNT_TRANSFER",
+		Type:             transferType,
+		Counterparties:   []TransactionCounterparty{},
+	}
+	if overrideCategory, ok := overrides[transaction.TransactionId]; ok {
+		plaidTransaction.Category = overrideCategory
+		plaidTransaction.CategoryDetailed = ""
+		plaidTransaction.CategoryOverridden = true
+	}
+	plaidTransaction.Image = GetCategoryIcon(plaidTransaction.Category)
+	return plaidTransaction
+}
+
 func GetTransactionsByBankId(bankdb *gorm.DB, bankId string) (TransactionsUsingBankId, error) {
 
 	senderBankDocs, err := db.GetTransactionUsingSenderBankId(bankdb, bankId)
@@ -427,56 +649,273 @@ func TransferPayment(c *gin.Context) {
 		return
 	}
 
-	// fmt.Println("Request body: ", paymentTransferReq)
-	receiverAccountId, err := utils.DecryptID(paymentTransferReq.ShareableId)
+	transactionRes, err := ExecuteTransfer(PgDb, paymentTransferReq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to decrypt shareable id: " + err.Error()})
+		log.Println(err.Error())
+		var rejectedErr *TransferRejectedError
+		if errors.As(err, &rejectedErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "reasons": rejectedErr.Rejections})
+			return
+		}
+		var heldErr *TransferHeldError
+		if errors.As(err, &heldErr) {
+			c.JSON(http.StatusAccepted, gin.H{"message": "Transfer Held For Review", "decisionId": heldErr.DecisionId})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	// log.Println("Receiver Account ID: ", receiverAccountId)
 
-	receiverBank, err := db.GetRecordUsingAccountId(PgDb, receiverAccountId)
+	c.JSON(http.StatusOK, gin.H{"data": transactionRes})
+}
+
+type transferOptions struct {
+	// skipRiskReview is set when an admin already approved a held transfer.
+	skipRiskReview bool
+	// quote is set when a reviewed quote is confirmed; the transfer must still
+	// match it.
+	quote *db.TransferQuote
+}
+
+// ExecuteTransfer moves money from the sender bank to the bank behind the
+// receiver's shareable id through Dwolla and records the transaction. It is
+// shared by the transfer endpoint and the scheduled transfer worker.
+func ExecuteTransfer(bankdb *gorm.DB, paymentTransferReq PaymentTransfer) (db.Transaction, error) {
+	return executeTransfer(bankdb, paymentTransferReq, transferOptions{})
+}
+
+// ResolveTransferBanks looks up the sending bank and the bank behind the
+// receiver's shareable id.
+func ResolveTransferBanks(bankdb *gorm.DB, paymentTransferReq PaymentTransfer) (db.PlaidUser, db.PlaidUser, error) {
+	receiverAccountId, err := utils.DecryptID(paymentTransferReq.ShareableId)
+	if err != nil {
+		return db.PlaidUser{}, db.PlaidUser{}, fmt.Errorf("unable to decrypt shareable id: %v", err.Error())
+	}
+
+	receiverBank, err := db.GetRecordUsingAccountId(bankdb, receiverAccountId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to fetch record using bank id: " + err.Error()})
-		return
+		return db.PlaidUser{}, db.PlaidUser{}, fmt.Errorf("unable to fetch record using bank id: %v", err.Error())
 	}
 
-	senderBank, err := db.GetRecordUsingTrackId(PgDb, paymentTransferReq.SenderBank)
+	senderBank, err := db.GetRecordUsingTrackId(bankdb, paymentTransferReq.SenderBank)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return db.PlaidUser{}, db.PlaidUser{}, fmt.Errorf("unable to fetch record using track id: %v", err.Error())
+	}
+	return senderBank, receiverBank, nil
+}
+
+// CheckTransfer runs the limits, option and clearing checks and prices the
+// transfer. Any failed check is returned as a TransferRejectedError.
+func CheckTransfer(bankdb *gorm.DB, paymentTransferReq PaymentTransfer, senderBank db.PlaidUser, receiverBank db.PlaidUser) (TransferRuleResult, FeeQuote, error) {
+	ruleResult, err := CheckTransferRules(bankdb, senderBank, receiverBank, paymentTransferReq.Amount)
+	if err != nil {
+		return TransferRuleResult{}, FeeQuote{}, fmt.Errorf("unable to verify transfer: %v", err.Error())
+	}
+	if len(paymentTransferReq.Clearing) == 0 {
+		paymentTransferReq.Clearing = ClearingStandard
+	}
+	rejections := append(ruleResult.Rejections, ValidateTransferOptions(paymentTransferReq)...)
+	feeQuote := CalculateFees(ruleResult.Amount, paymentTransferReq.Clearing)
+	if feeQuote.TotalFee > 0 && !IsManualBank(senderBank) && ruleResult.Amount <= ruleResult.AvailableBalance && feeQuote.Total > ruleResult.AvailableBalance {
+		rejections = append(rejections, TransferRejection{
+			Code:    RejectInsufficientFunds,
+			Message: fmt.Sprintf("amount plus fees of %.2f exceeds the available balance of %.2f", feeQuote.TotalFee, ruleResult.AvailableBalance),
+		})
+	}
+	if len(rejections) > 0 {
+		return TransferRuleResult{}, FeeQuote{}, &TransferRejectedError{Rejections: rejections}
+	}
+
+	rejections, err = CheckClearingSupport(context.Background(), senderBank.FundingSourceUrl, receiverBank.FundingSourceUrl, paymentTransferReq.Clearing, ruleResult.Amount)
+	if err != nil {
+		return TransferRuleResult{}, FeeQuote{}, fmt.Errorf("unable to verify clearing options: %v", err.Error())
+	}
+	if len(rejections) > 0 {
+		return TransferRuleResult{}, FeeQuote{}, &TransferRejectedError{Rejections: rejections}
+	}
+	return ruleResult, feeQuote, nil
+}
+
+func executeTransfer(bankdb *gorm.DB, paymentTransferReq PaymentTransfer, options transferOptions) (db.Transaction, error) {
+	if err := ApplyPayee(bankdb, &paymentTransferReq, time.Now()); err != nil {
+		return db.Transaction{}, err
+	}
+	senderBank, receiverBank, err := ResolveTransferBanks(bankdb, paymentTransferReq)
+	if err != nil {
+		return db.Transaction{}, err
+	}
+	if len(paymentTransferReq.Clearing) == 0 {
+		paymentTransferReq.Clearing = ClearingStandard
+	}
+
+	// The limits are checked against the transfers already recorded, so the
+	// check and the new row happen under the sender's lock.
+	var transactionRes db.Transaction
+	err = db.WithTransferLock(bankdb, senderBank.UserId, func() error {
+		transactionRes, err = createCheckedTransfer(bankdb, paymentTransferReq, senderBank, receiverBank, options)
+		return err
+	})
+	if err != nil {
+		return db.Transaction{}, err
+	}
+
+	if err := EvaluateBudgets(bankdb, senderBank.UserId); err != nil {
+		log.Println("unable to evaluate budgets: " + err.Error())
+	}
+
+	log.Println("Transaction Complete: ", transactionRes)
+	return transactionRes, nil
+}
+
+// createCheckedTransfer runs the transfer checks, creates the Dwolla transfer
+// and records it with its ledger journals.
+func createCheckedTransfer(bankdb *gorm.DB, paymentTransferReq PaymentTransfer, senderBank db.PlaidUser, receiverBank db.PlaidUser, options transferOptions) (db.Transaction, error) {
+	ruleResult, feeQuote, err := CheckTransfer(bankdb, paymentTransferReq, senderBank, receiverBank)
+	if err != nil {
+		return db.Transaction{}, err
+	}
+	if options.quote != nil {
+		if rejections := CompareWithQuote(*options.quote, receiverBank, feeQuote); len(rejections) > 0 {
+			return db.Transaction{}, &TransferRejectedError{Rejections: rejections}
+		}
 	}
 
 	ctx := context.Background()
+	if !options.skipRiskReview {
+		if _, err := ReviewTransferRisk(bankdb, paymentTransferReq, senderBank, receiverBank, ruleResult.Amount); err != nil {
+			return db.Transaction{}, err
+		}
+	}
+
 	log.Println("Receiver Bank: ", receiverBank)
 	log.Println("Sender Bank: ", senderBank)
-	transferRes, err := CreateTransfer(ctx, senderBank.FundingSourceUrl, receiverBank.FundingSourceUrl, paymentTransferReq.Amount)
+	transferOptions := TransferOptions{
+		Clearing:          ClearingSettings(paymentTransferReq.Clearing),
+		ProcessingChannel: ProcessingChannelSettings(paymentTransferReq.Clearing),
+		Metadata:          paymentTransferReq.Metadata,
+		CorrelationId:     paymentTransferReq.CorrelationId,
+	}
+	if feeQuote.TotalFee > 0 {
+		customerUrl, err := SenderCustomerUrl(bankdb, senderBank)
+		if err != nil {
+			return db.Transaction{}, fmt.Errorf("unable to resolve the sender for fees: %v", err.Error())
+		}
+		transferOptions.Fees = FacilitatorFees(customerUrl, feeQuote.TotalFee)
+	}
+	transferRes, err := CreateTransfer(ctx, senderBank.FundingSourceUrl, receiverBank.FundingSourceUrl, paymentTransferReq.Amount, transferOptions)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer failed: " + err.Error()})
+		return db.Transaction{}, fmt.Errorf("transfer failed: %v", err.Error())
 	}
 
-	transferId := transferRes["id"]
-	transferUrl := fmt.Sprintf("%s/transfer/%v", DwollaBaseUrl, transferId)
+	transferId, ok := transferRes["id"].(string)
+	if !ok || len(transferId) == 0 {
+		return db.Transaction{}, fmt.Errorf("transfer failed: dwolla did not return a transfer id")
+	}
+	transferUrl := DwollaTransferUrl(transferId)
 	log.Println("Transfer URL: ", transferUrl)
-	if len(transferUrl) > 50 {
-
-		transactionReq := TransactionRequest{
-			Name:           paymentTransferReq.Name,
-			Amount:         paymentTransferReq.Amount,
-			SenderId:       senderBank.UserId,
-			SenderBankId:   senderBank.TrackId,
-			ReceiverId:     receiverBank.UserId,
-			ReceiverBankId: receiverBank.TrackId,
-			Email:          paymentTransferReq.Email,
+
+	transactionReq := TransactionRequest{
+		Name:                  paymentTransferReq.Name,
+		Amount:                paymentTransferReq.Amount,
+		SenderId:              senderBank.UserId,
+		SenderBankId:          senderBank.TrackId,
+		ReceiverId:            receiverBank.UserId,
+		ReceiverBankId:        receiverBank.TrackId,
+		Email:                 paymentTransferReq.Email,
+		DwollaTransferId:      transferId,
+		DwollaTransferUrl:     transferUrl,
+		SourceFundingSourceId: senderBank.FundingSourceId,
+		DestFundingSourceId:   receiverBank.FundingSourceId,
+		Status:                DwollaTransferPending,
+		RequestedClearing:     paymentTransferReq.Clearing,
+		CorrelationId:         paymentTransferReq.CorrelationId,
+	}
+	if feeQuote.TotalFee > 0 {
+		transactionReq.FeeAmount = strconv.FormatFloat(feeQuote.TotalFee, 'f', 2, 64)
+	}
+	if status, ok := transferRes["status"].(string); ok && len(status) > 0 {
+		transactionReq.Status = status
+	}
+	// Dwolla echoes the clearing it applied, which can fall back to standard
+	// after the same-day cutoff. Until it does the actual clearing is unknown
+	// and left empty; RefreshTransferStatus fills it in later.
+	clearing, hasClearing := transferRes["clearing"].(map[string]interface{})
+	channel, hasChannel := transferRes["processingChannel"].(map[string]interface{})
+	if hasClearing || hasChannel {
+		var appliedClearing *TransferClearing
+		var appliedChannel *TransferProcessingChannel
+		if hasClearing {
+			source, _ := clearing["source"].(string)
+			destination, _ := clearing["destination"].(string)
+			appliedClearing = &TransferClearing{Source: source, Destination: destination}
+		}
+		if hasChannel {
+			destination, _ := channel["destination"].(string)
+			appliedChannel = &TransferProcessingChannel{Destination: destination}
 		}
+		transactionReq.ActualClearing = ActualClearing(appliedClearing, appliedChannel)
+	}
 
-		transactionRes, err := CreateTransaction(PgDb, transactionReq)
+	var transactionRes db.Transaction
+	err = bankdb.Transaction(func(tx *gorm.DB) error {
+		transactionRes, err = CreateTransaction(tx, transactionReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "transaction failed: " + err.Error()})
-			return
+			return err
+		}
+		if err := PostTransferInitiated(tx, transactionRes); err != nil {
+			return err
 		}
+		return PostFeeCharged(tx, transactionRes)
+	})
+	if err != nil {
+		return db.Transaction{}, fmt.Errorf("transaction failed: %v", err.Error())
+	}
+	return transactionRes, nil
+}
+
+func OverrideTransactionCategory(c *gin.Context) {
+	var overrideReq CategoryOverrideRequest
+	if err := c.ShouldBindJSON(&overrideReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	category := strings.ToUpper(overrideReq.Category)
+	if !IsValidCategory(category) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown category: " + overrideReq.Category})
+		return
+	}
 
-		log.Println("Transaction Complete: ", transactionRes)
-		c.JSON(http.StatusOK, gin.H{"data": transactionRes})
+	if !IsTransactionOwnedByUser(PgDb, overrideReq.TransactionId, overrideReq.UserId) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found for user"})
+		return
 	}
 
+	override := db.CategoryOverride{
+		TransactionId: overrideReq.TransactionId,
+		UserId:        overrideReq.UserId,
+		Category:      category,
+		UpdatedAt:     time.Now(),
+	}
+	if err := db.SaveCategoryOverride(PgDb, override); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category Updated Successfully", "transactionId": override.TransactionId, "category": override.Category, "image": GetCategoryIcon(override.Category)})
+}
+
+// IsTransactionOwnedByUser reports whether the transaction is either a synced
+// Plaid transaction of the user or an internal transfer the user took part in.
+func IsTransactionOwnedByUser(bankdb *gorm.DB, transactionId string, userId string) bool {
+	if syncedTransaction, err := db.GetSyncedTransactionUsingId(bankdb, transactionId); err == nil {
+		return syncedTransaction.UserId == userId
+	}
+	transferTransaction, err := db.GetTransactionUsingId(bankdb, transactionId)
+	if err != nil {
+		return false
+	}
+	return transferTransaction.SenderId == userId || transferTransaction.ReceiverId == userId
 }
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"github.com/plaid/plaid-go/plaid"
+	"gorm.io/gorm"
+)
+
+var (
+	// IdentityHashKey keys the hashes of stored identity data. It is required,
+	// since unkeyed hashes of names and emails are easy to reverse.
+	IdentityHashKey string
+	// IdentityEnforce refuses links whose account holder does not match the
+	// user. When it is off those links are kept, flagged and cannot send.
+	IdentityEnforce = true
+)
+
+const (
+	IdentityMatched     = "matched"
+	IdentityReview      = "review"
+	IdentityMismatch    = "mismatch"
+	IdentityUnavailable = "unavailable"
+
+	// Name scores run from 0 to 1. At or above NameMatchThreshold the owner is
+	// the user; below NameReviewThreshold it is someone else.
+	NameMatchThreshold  = 0.85
+	NameReviewThreshold = 0.70
+
+	RejectIdentityMismatch = "IDENTITY_MISMATCH"
+)
+
+// LoadIdentitySettings reads the hash key from IDENTITY_HASH_KEY, refusing to
+// start without it, and turns enforcement off when IDENTITY_MATCH_ENFORCE is
+// false.
+func LoadIdentitySettings() {
+	IdentityHashKey = os.Getenv("IDENTITY_HASH_KEY")
+	if len(IdentityHashKey) == 0 {
+		log.Fatal("IDENTITY_HASH_KEY must be set to store identity data reported by banks")
+	}
+	if enforce, err := strconv.ParseBool(os.Getenv("IDENTITY_MATCH_ENFORCE")); err == nil {
+		IdentityEnforce = enforce
+	}
+}
+
+// nameNoise is dropped from names before they are compared.
+var nameNoise = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "miss": true, "dr": true, "prof": true,
+	"jr": true, "sr": true, "ii": true, "iii": true, "iv": true,
+}
+
+type IdentityCheck struct {
+	NameScore      float64 `json:"nameScore"`
+	EmailMatched   bool    `json:"emailMatched"`
+	AddressMatched bool    `json:"addressMatched"`
+	Status         string  `json:"status"`
+}
+
+func nameTokens(name string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	tokens := fields[:0]
+	for _, field := range fields {
+		if !nameNoise[field] {
+			tokens = append(tokens, field)
+		}
+	}
+	return tokens
+}
+
+// jaroWinkler scores how alike two strings are from 0 to 1, favouring a
+// shared prefix.
+func jaroWinkler(a string, b string) float64 {
+	s1, s2 := []rune(a), []rune(b)
+	if len(s1) == 0 || len(s2) == 0 {
+		return 0
+	}
+	if string(s1) == string(s2) {
+		return 1
+	}
+	window := max(len(s1), len(s2))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	matched1 := make([]bool, len(s1))
+	matched2 := make([]bool, len(s2))
+	matches := 0
+	for i := range s1 {
+		for j := max(0, i-window); j < min(len(s2), i+window+1); j++ {
+			if !matched2[j] && s1[i] == s2[j] {
+				matched1[i], matched2[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions, j := 0, 0
+	for i := range s1 {
+		if !matched1[i] {
+			continue
+		}
+		for !matched2[j] {
+			j++
+		}
+		if s1[i] != s2[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(s1)) + m/float64(len(s2)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(s1), len(s2)) && s1[prefix] == s2[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// NameMatchScore compares the user's first and last name with a name the
+// bank reports, in any order and ignoring titles and punctuation. Every part
+// of the last name must be found; the first name may appear as an initial.
+func NameMatchScore(firstName string, lastName string, ownerName string) float64 {
+	owner := nameTokens(ownerName)
+	first := nameTokens(firstName)
+	last := nameTokens(lastName)
+	if len(owner) == 0 || len(first) == 0 || len(last) == 0 {
+		return 0
+	}
+
+	var lastScore float64
+	for _, part := range last {
+		best := 0.0
+		for _, token := range owner {
+			best = max(best, jaroWinkler(part, token))
+		}
+		lastScore += best
+	}
+	lastScore /= float64(len(last))
+
+	firstScore := 0.0
+	for _, token := range owner {
+		if len([]rune(token)) == 1 && []rune(token)[0] == []rune(first[0])[0] {
+			firstScore = max(firstScore, 0.9)
+			continue
+		}
+		firstScore = max(firstScore, jaroWinkler(first[0], token))
+	}
+
+	score := (firstScore + lastScore) / 2
+	if lastScore < NameReviewThreshold {
+		score = min(score, lastScore)
+	}
+	return score
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// normalizePhone keeps the last ten digits so country codes and formatting
+// do not matter.
+func normalizePhone(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	value := digits.String()
+	if len(value) > 10 {
+		value = value[len(value)-10:]
+	}
+	return value
+}
+
+// normalizeAddress reduces an address to its letters and digits plus the
+// five digit ZIP code.
+func normalizeAddress(street string, postalCode string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(street) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+	zip := normalizePhone(postalCode)
+	if len(zip) > 5 {
+		zip = zip[:5]
+	}
+	return normalized.String() + "|" + zip
+}
+
+func hashIdentityValue(value string) string {
+	mac := hmac.New(sha256.New, []byte(IdentityHashKey))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashIdentityValues encodes the keyed hashes of the values as a JSON array.
+func hashIdentityValues(values []string) string {
+	hashes := make([]string, 0, len(values))
+	for _, value := range values {
+		if len(value) > 0 {
+			hashes = append(hashes, hashIdentityValue(value))
+		}
+	}
+	encoded, _ := json.Marshal(hashes)
+	return string(encoded)
+}
+
+// CheckBankIdentity compares the account holders of a linked account with
+// the user's profile and returns the result along with a hashed record of
+// what the bank reported.
+//
+// A strong name match passes. A near match passes when the email or address
+// also matches and is otherwise sent for review; a weak name match is a
+// mismatch unless both email and address match, as with a married name.
+func CheckBankIdentity(user BankUser, owners []plaid.Owner, now time.Time) (IdentityCheck, db.BankIdentity) {
+	var names, emails, phones, addresses []string
+	for _, owner := range owners {
+		names = append(names, owner.GetNames()...)
+		for _, email := range owner.GetEmails() {
+			emails = append(emails, normalizeEmail(email.GetData()))
+		}
+		for _, phone := range owner.GetPhoneNumbers() {
+			phones = append(phones, normalizePhone(phone.GetData()))
+		}
+		for _, address := range owner.GetAddresses() {
+			data := address.GetData()
+			addresses = append(addresses, normalizeAddress(data.GetStreet(), data.GetPostalCode()))
+		}
+	}
+
+	check := IdentityCheck{Status: IdentityUnavailable}
+	if len(names) > 0 {
+		for _, name := range names {
+			check.NameScore = max(check.NameScore, NameMatchScore(user.FirstName, user.LastName, name))
+		}
+		userEmail := normalizeEmail(user.Email)
+		for _, email := range emails {
+			check.EmailMatched = check.EmailMatched || (len(userEmail) > 0 && email == userEmail)
+		}
+		if len(user.Address1) > 0 {
+			userAddress := normalizeAddress(user.Address1, user.PostalCode)
+			for _, address := range addresses {
+				check.AddressMatched = check.AddressMatched || address == userAddress
+			}
+		}
+
+		switch {
+		case check.NameScore >= NameMatchThreshold:
+			check.Status = IdentityMatched
+		case check.NameScore >= NameReviewThreshold && (check.EmailMatched || check.AddressMatched):
+			check.Status = IdentityMatched
+		case check.NameScore >= NameReviewThreshold:
+			check.Status = IdentityReview
+		case check.EmailMatched && check.AddressMatched:
+			check.Status = IdentityReview
+		default:
+			check.Status = IdentityMismatch
+		}
+	}
+	check.NameScore = roundAmount(check.NameScore)
+
+	normalizedNames := make([]string, 0, len(names))
+	for _, name := range names {
+		normalizedNames = append(normalizedNames, strings.Join(nameTokens(name), " "))
+	}
+	identity := db.BankIdentity{
+		IdentityId:     utils.GenerateId("IDCHECK"),
+		NameHashes:     hashIdentityValues(normalizedNames),
+		EmailHashes:    hashIdentityValues(emails),
+		PhoneHashes:    hashIdentityValues(phones),
+		AddressHashes:  hashIdentityValues(addresses),
+		NameScore:      check.NameScore,
+		EmailMatched:   check.EmailMatched,
+		AddressMatched: check.AddressMatched,
+		Status:         check.Status,
+		CheckedAt:      now,
+	}
+	return check, identity
+}
+
+// VerifyBankIdentity fetches the account holders from Plaid and checks them
+// against the user. Institutions without identity data, or a failed lookup,
+// are unavailable rather than a mismatch; IdentityCheckRisk scores transfers
+// from those banks.
+func VerifyBankIdentity(accessToken string, accountId string, user BankUser) (IdentityCheck, db.BankIdentity) {
+	owners, err := GetIdentity(accessToken, accountId)
+	if err != nil {
+		log.Println("identity check unavailable: " + err.Error())
+		owners = nil
+	}
+	return CheckBankIdentity(user, owners, time.Now())
+}
+
+// IdentityCheckRisk scores transfers from banks whose account holder could
+// not be confirmed as the user.
+func IdentityCheckRisk(bankdb *gorm.DB, riskCtx RiskContext) (*RiskSignal, error) {
+	switch riskCtx.SenderBank.IdentityStatus {
+	case IdentityUnavailable:
+		return &RiskSignal{Name: "identity_unavailable", Score: 25, Detail: "the sending bank's account holder could not be checked"}, nil
+	case IdentityReview:
+		return &RiskSignal{Name: "identity_review", Score: 25, Detail: "the sending bank's account holder only partly matches the user"}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import "testing"
+
+func TestNameMatchScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		firstName string
+		lastName  string
+		ownerName string
+		wantMin   float64
+		wantMax   float64
+	}{
+		{"exact", "John", "Smith", "John Smith", 1, 1},
+		{"reversed with punctuation", "John", "Smith", "SMITH, JOHN", 1, 1},
+		{"titles and middle initial", "John", "Smith", "Mr. John A. Smith Jr.", 1, 1},
+		{"first initial", "John", "Smith", "J Smith", NameMatchThreshold, 0.99},
+		{"nickname spelling", "Jon", "Smith", "John Smith", NameMatchThreshold, 0.99},
+		{"double-barrelled last name", "Mary", "Smith-Jones", "Mary Smith Jones", 1, 1},
+		{"missing part of the last name", "Mary", "Smith-Jones", "Mary Smith", 0, NameMatchThreshold},
+		{"different person", "John", "Smith", "Jane Doe", 0, NameReviewThreshold},
+		{"same first name only", "John", "Smith", "John Brown", 0, NameReviewThreshold},
+		{"empty owner", "John", "Smith", "", 0, 0},
+		{"empty first name", "", "Smith", "John Smith", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := NameMatchScore(tt.firstName, tt.lastName, tt.ownerName)
+			if score < tt.wantMin || score > tt.wantMax {
+				t.Fatalf("NameMatchScore(%q, %q, %q) = %v, want between %v and %v", tt.firstName, tt.lastName, tt.ownerName, score, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
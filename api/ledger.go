@@ -0,0 +1,268 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+// Every transfer moves through the clearing account: the sender bank is
+// credited when the transfer is initiated, the receiver bank is debited when
+// it settles, and a reversal returns the money to the sender.
+const (
+	LedgerClearingAccount = "clearing:dwolla"
+	LedgerFeeAccount      = "revenue:fees"
+
+	JournalTransferInitiated = "transfer_initiated"
+	JournalTransferSettled   = "transfer_settled"
+	JournalTransferReversed  = "transfer_reversed"
+	JournalFeeCharged        = "fee_charged"
+	JournalFeeRefunded       = "fee_refunded"
+
+	PostingPending  = "pending"
+	PostingSettled  = "settled"
+	PostingReversed = "reversed"
+)
+
+type LedgerBalanceRequest struct {
+	TrackId string `json:"plaidTrackId" binding:"required"`
+	UserId  string `json:"userId" binding:"required"`
+}
+
+func BankLedgerAccount(trackId string) string {
+	return "bank:" + trackId
+}
+
+func PostTransferInitiated(bankdb *gorm.DB, transaction db.Transaction) error {
+	return postTransferJournal(bankdb, transaction, JournalTransferInitiated, "")
+}
+
+func PostTransferSettled(bankdb *gorm.DB, transaction db.Transaction) error {
+	return postTransferJournal(bankdb, transaction, JournalTransferSettled, "")
+}
+
+func PostTransferReversed(bankdb *gorm.DB, transaction db.Transaction, reason string) error {
+	return postTransferJournal(bankdb, transaction, JournalTransferReversed, reason)
+}
+
+// PostFeeCharged moves the transfer fee from the sender bank to fee revenue.
+// Fees are kept in their own journals so transfer amounts stay untouched.
+func PostFeeCharged(bankdb *gorm.DB, transaction db.Transaction) error {
+	return postFeeJournal(bankdb, transaction, JournalFeeCharged)
+}
+
+// PostFeeRefunded returns the fee of a transfer that failed or was cancelled.
+func PostFeeRefunded(bankdb *gorm.DB, transaction db.Transaction) error {
+	return postFeeJournal(bankdb, transaction, JournalFeeRefunded)
+}
+
+func postFeeJournal(bankdb *gorm.DB, transaction db.Transaction, entryType string) error {
+	if len(transaction.FeeAmount) == 0 {
+		return nil
+	}
+	fee, err := strconv.ParseFloat(transaction.FeeAmount, 64)
+	if err != nil {
+		return fmt.Errorf("invalid fee %s on transaction %s", transaction.FeeAmount, transaction.TransactionId)
+	}
+	fee = roundAmount(fee)
+	if fee <= 0 {
+		return nil
+	}
+
+	journals, err := db.GetJournalsUsingTransactionId(bankdb, transaction.TransactionId)
+	if err != nil {
+		return err
+	}
+	posted := make(map[string]bool)
+	for _, journal := range journals {
+		posted[journal.EntryType] = true
+	}
+	if posted[entryType] {
+		return nil
+	}
+
+	sender := BankLedgerAccount(transaction.SenderBankId)
+	debitAccount, creditAccount, description := LedgerFeeAccount, sender, "transfer fee"
+	if entryType == JournalFeeRefunded {
+		if !posted[JournalFeeCharged] {
+			return nil
+		}
+		debitAccount, creditAccount, description = sender, LedgerFeeAccount, "transfer fee refunded"
+	}
+
+	now := time.Now()
+	journal := db.LedgerJournal{
+		JournalId:     utils.GenerateId("JOURNAL"),
+		TransactionId: transaction.TransactionId,
+		EntryType:     entryType,
+		Description:   description,
+		CreatedAt:     now,
+	}
+	postings := []db.LedgerPosting{
+		{PostingId: utils.GenerateId("POSTING") + "D", JournalId: journal.JournalId, LedgerAccount: debitAccount, Amount: fee, State: PostingSettled, CreatedAt: now},
+		{PostingId: utils.GenerateId("POSTING") + "C", JournalId: journal.JournalId, LedgerAccount: creditAccount, Amount: -fee, State: PostingSettled, CreatedAt: now},
+	}
+	return db.AddJournal(bankdb, journal, postings)
+}
+
+// postTransferJournal appends the journal for the next state of a transfer.
+// Repeating a state is a no-op so status updates can be replayed safely.
+func postTransferJournal(bankdb *gorm.DB, transaction db.Transaction, entryType string, reason string) error {
+	amount, err := strconv.ParseFloat(transaction.Amount, 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %s on transaction %s", transaction.Amount, transaction.TransactionId)
+	}
+	amount = roundAmount(amount)
+
+	journals, err := db.GetJournalsUsingTransactionId(bankdb, transaction.TransactionId)
+	if err != nil {
+		return err
+	}
+	posted := make(map[string]bool)
+	for _, journal := range journals {
+		posted[journal.EntryType] = true
+	}
+	if posted[entryType] {
+		return nil
+	}
+
+	sender := BankLedgerAccount(transaction.SenderBankId)
+	receiver := BankLedgerAccount(transaction.ReceiverBankId)
+	var debitAccount, creditAccount, state, description string
+	switch entryType {
+	case JournalTransferInitiated:
+		debitAccount, creditAccount, state = LedgerClearingAccount, sender, PostingPending
+		description = "transfer initiated"
+	case JournalTransferSettled:
+		if !posted[JournalTransferInitiated] || posted[JournalTransferReversed] {
+			return fmt.Errorf("transaction %s cannot settle from its current ledger state", transaction.TransactionId)
+		}
+		debitAccount, creditAccount, state = receiver, LedgerClearingAccount, PostingSettled
+		description = "transfer settled"
+	case JournalTransferReversed:
+		if !posted[JournalTransferInitiated] {
+			return fmt.Errorf("transaction %s was never initiated in the ledger", transaction.TransactionId)
+		}
+		// A return after settlement pulls the money back from the receiver.
+		debitAccount, creditAccount, state = sender, LedgerClearingAccount, PostingReversed
+		if posted[JournalTransferSettled] {
+			creditAccount = receiver
+		}
+		description = "transfer reversed"
+		if len(reason) > 0 {
+			description += ": " + reason
+		}
+	default:
+		return fmt.Errorf("unknown journal entry type %s", entryType)
+	}
+
+	now := time.Now()
+	journal := db.LedgerJournal{
+		JournalId:     utils.GenerateId("JOURNAL"),
+		TransactionId: transaction.TransactionId,
+		EntryType:     entryType,
+		Description:   description,
+		CreatedAt:     now,
+	}
+	postings := []db.LedgerPosting{
+		{PostingId: utils.GenerateId("POSTING") + "D", JournalId: journal.JournalId, LedgerAccount: debitAccount, Amount: amount, State: state, CreatedAt: now},
+		{PostingId: utils.GenerateId("POSTING") + "C", JournalId: journal.JournalId, LedgerAccount: creditAccount, Amount: -amount, State: state, CreatedAt: now},
+	}
+	return db.AddJournal(bankdb, journal, postings)
+}
+
+func GetLedgerBalance(c *gin.Context) {
+	var balanceReq LedgerBalanceRequest
+	if err := c.ShouldBindJSON(&balanceReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	bankDetails, err := db.GetRecordUsingTrackId(PgDb, balanceReq.TrackId)
+	if err != nil || bankDetails.UserId != balanceReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no account found for user"})
+		return
+	}
+
+	ledgerAccount := BankLedgerAccount(bankDetails.TrackId)
+	balances, err := db.GetLedgerBalances(PgDb, []string{ledgerAccount}, PostingPending, []string{JournalTransferSettled, JournalTransferReversed})
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	balance := db.LedgerBalance{LedgerAccount: ledgerAccount}
+	if len(balances) > 0 {
+		balance = balances[0]
+	}
+	balance.Balance = roundAmount(balance.Balance)
+	balance.PendingBalance = roundAmount(balance.PendingBalance)
+
+	c.JSON(http.StatusOK, gin.H{"data": balance})
+}
+
+func CheckLedger(c *gin.Context) {
+	unbalanced, err := db.GetUnbalancedJournals(PgDb)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	balances, err := db.GetLedgerBalances(PgDb, []string{LedgerClearingAccount}, PostingPending, []string{JournalTransferSettled, JournalTransferReversed})
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var inTransit float64
+	if len(balances) > 0 {
+		inTransit = roundAmount(balances[0].Balance)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"balanced": len(unbalanced) == 0, "unbalancedJournals": unbalanced, "inTransit": inTransit})
+}
+
+// BackfillLedger posts journals for transfers recorded before the ledger
+// existed, following each transfer's status: pending transfers are only
+// initiated, unsettled ones are reversed, and processed transfers or legacy
+// rows without a status are settled.
+func BackfillLedger(c *gin.Context) {
+	transactions, err := db.GetTransactionsWithoutJournal(PgDb)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var failed []string
+	for _, transaction := range transactions {
+		err := PgDb.Transaction(func(tx *gorm.DB) error {
+			if err := PostTransferInitiated(tx, transaction); err != nil {
+				return err
+			}
+			switch {
+			case transaction.Status == DwollaTransferPending:
+				return nil
+			case !TransferMovedMoney(transaction.Status):
+				return PostTransferReversed(tx, transaction, transaction.FailureReason)
+			}
+			return PostTransferSettled(tx, transaction)
+		})
+		if err != nil {
+			log.Println(err.Error())
+			failed = append(failed, transaction.TransactionId)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ledger Backfill Complete", "posted": len(transactions) - len(failed), "failed": failed})
+}
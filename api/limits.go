@@ -0,0 +1,269 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"gorm.io/gorm"
+)
+
+// GlobalTransferLimit applies to every user; a per-user limit overrides the
+// fields it sets to a non-zero value.
+var GlobalTransferLimit = db.TransferLimit{
+	MaxPerTransfer: 5000,
+	DailyLimit:     10000,
+	WeeklyLimit:    25000,
+	DailyCount:     10,
+}
+
+var amountPattern = regexp.MustCompile(`^\d+(\.\d{1,2})?$`)
+
+const (
+	RejectInvalidAmount      = "INVALID_AMOUNT"
+	RejectSameAccount        = "SAME_ACCOUNT"
+	RejectPerTransferLimit   = "PER_TRANSFER_LIMIT"
+	RejectDailyLimit         = "DAILY_LIMIT"
+	RejectWeeklyLimit        = "WEEKLY_LIMIT"
+	RejectDailyCount         = "DAILY_COUNT_LIMIT"
+	RejectInsufficientFunds  = "INSUFFICIENT_FUNDS"
+	RejectBalanceUnavailable = "BALANCE_UNAVAILABLE"
+	RejectUnverifiedSource   = "UNVERIFIED_FUNDING_SOURCE"
+	RejectNotFundingSource   = "NOT_A_FUNDING_SOURCE"
+)
+
+type TransferRejection struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// TransferRejectedError is returned when a transfer fails one or more rules.
+// Callers surface Rejections to the client as structured reasons.
+type TransferRejectedError struct {
+	Rejections []TransferRejection
+}
+
+func (e *TransferRejectedError) Error() string {
+	var messages []string
+	for _, rejection := range e.Rejections {
+		messages = append(messages, rejection.Message)
+	}
+	return "transfer rejected: " + strings.Join(messages, "; ")
+}
+
+type TransferRuleResult struct {
+	Amount           float64             `json:"amount"`
+	AvailableBalance float64             `json:"availableBalance"`
+	LiveBalance      bool                `json:"liveBalance"`
+	Limit            db.TransferLimit    `json:"limit"`
+	DailyRemaining   float64             `json:"dailyRemaining"`
+	WeeklyRemaining  float64             `json:"weeklyRemaining"`
+	Rejections       []TransferRejection `json:"rejections"`
+}
+
+type TransferLimitRequest struct {
+	UserId         string `json:"userId" binding:"required"`
+	MaxPerTransfer string `json:"maxPerTransfer"`
+	DailyLimit     string `json:"dailyLimit"`
+	WeeklyLimit    string `json:"weeklyLimit"`
+	DailyCount     int    `json:"dailyCount"`
+}
+
+func LoadGlobalTransferLimit() {
+	if value, err := strconv.ParseFloat(os.Getenv("TRANSFER_MAX_AMOUNT"), 64); err == nil {
+		GlobalTransferLimit.MaxPerTransfer = value
+	}
+	if value, err := strconv.ParseFloat(os.Getenv("TRANSFER_DAILY_LIMIT"), 64); err == nil {
+		GlobalTransferLimit.DailyLimit = value
+	}
+	if value, err := strconv.ParseFloat(os.Getenv("TRANSFER_WEEKLY_LIMIT"), 64); err == nil {
+		GlobalTransferLimit.WeeklyLimit = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("TRANSFER_DAILY_COUNT")); err == nil {
+		GlobalTransferLimit.DailyCount = value
+	}
+}
+
+func GetEffectiveTransferLimit(bankdb *gorm.DB, userId string) db.TransferLimit {
+	limit := GlobalTransferLimit
+	limit.UserId = userId
+	userLimit, err := db.GetTransferLimit(bankdb, userId)
+	if err != nil {
+		return limit
+	}
+	if userLimit.MaxPerTransfer > 0 {
+		limit.MaxPerTransfer = userLimit.MaxPerTransfer
+	}
+	if userLimit.DailyLimit > 0 {
+		limit.DailyLimit = userLimit.DailyLimit
+	}
+	if userLimit.WeeklyLimit > 0 {
+		limit.WeeklyLimit = userLimit.WeeklyLimit
+	}
+	if userLimit.DailyCount > 0 {
+		limit.DailyCount = userLimit.DailyCount
+	}
+	limit.UpdatedAt = userLimit.UpdatedAt
+	return limit
+}
+
+// CheckTransferRules runs every pre-transfer rule and collects all rejections
+// instead of stopping at the first one. An error is returned only when a rule
+// could not be evaluated.
+func CheckTransferRules(bankdb *gorm.DB, senderBank db.PlaidUser, receiverBank db.PlaidUser, amount string) (TransferRuleResult, error) {
+	var result TransferRuleResult
+	reject := func(code string, message string) {
+		result.Rejections = append(result.Rejections, TransferRejection{Code: code, Message: message})
+	}
+
+	transferAmount, err := strconv.ParseFloat(amount, 64)
+	if !amountPattern.MatchString(amount) || err != nil || transferAmount <= 0 {
+		reject(RejectInvalidAmount, fmt.Sprintf("amount %q must be a positive value with at most two decimals", amount))
+		return result, nil
+	}
+	result.Amount = transferAmount
+
+	if senderBank.TrackId == receiverBank.TrackId || senderBank.AccountId == receiverBank.AccountId {
+		reject(RejectSameAccount, "sender and receiver are the same account")
+	}
+	if !IsFundingBank(senderBank) || !IsFundingBank(receiverBank) {
+		reject(RejectNotFundingSource, "credit, loan and investment accounts cannot send or receive transfers")
+	}
+	if !IsFundingSourceVerified(senderBank) {
+		reject(RejectUnverifiedSource, "the sending bank has not completed micro-deposit verification")
+	}
+	if senderBank.IdentityStatus == IdentityMismatch {
+		reject(RejectIdentityMismatch, "the sending bank's account holder does not match the user")
+	}
+
+	limit := GetEffectiveTransferLimit(bankdb, senderBank.UserId)
+	result.Limit = limit
+	if transferAmount > limit.MaxPerTransfer {
+		reject(RejectPerTransferLimit, fmt.Sprintf("amount exceeds the per-transfer limit of %.2f", limit.MaxPerTransfer))
+	}
+
+	now := time.Now()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dailyTotal, dailyCount, err := db.GetOutgoingTransferTotals(bankdb, senderBank.UserId, startOfDay)
+	if err != nil {
+		return TransferRuleResult{}, err
+	}
+	weeklyTotal, _, err := db.GetOutgoingTransferTotals(bankdb, senderBank.UserId, startOfDay.AddDate(0, 0, -6))
+	if err != nil {
+		return TransferRuleResult{}, err
+	}
+	result.DailyRemaining = roundAmount(limit.DailyLimit - dailyTotal)
+	result.WeeklyRemaining = roundAmount(limit.WeeklyLimit - weeklyTotal)
+	if dailyTotal+transferAmount > limit.DailyLimit {
+		reject(RejectDailyLimit, fmt.Sprintf("amount exceeds the remaining daily limit of %.2f", result.DailyRemaining))
+	}
+	if weeklyTotal+transferAmount > limit.WeeklyLimit {
+		reject(RejectWeeklyLimit, fmt.Sprintf("amount exceeds the remaining weekly limit of %.2f", result.WeeklyRemaining))
+	}
+	if dailyCount >= limit.DailyCount {
+		reject(RejectDailyCount, fmt.Sprintf("daily limit of %d transfers reached", limit.DailyCount))
+	}
+
+	// Banks added with micro-deposits have no Plaid item to read a balance
+	// from; Dwolla returns the transfer if the account cannot cover it.
+	if IsManualBank(senderBank) {
+		return result, nil
+	}
+
+	availableBalance, live, err := GetAvailableBalance(senderBank.AccessToken, senderBank.AccountId)
+	if err != nil {
+		log.Println(err.Error())
+		reject(RejectBalanceUnavailable, "unable to verify the sender's available balance")
+		return result, nil
+	}
+	result.AvailableBalance = roundAmount(availableBalance)
+	result.LiveBalance = live
+	if transferAmount > availableBalance {
+		reject(RejectInsufficientFunds, fmt.Sprintf("amount exceeds the available balance of %.2f", result.AvailableBalance))
+	}
+
+	return result, nil
+}
+
+func GetTransferLimits(c *gin.Context) {
+	var userData BankUserId
+	if err := c.ShouldBindJSON(&userData); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	limit := GetEffectiveTransferLimit(PgDb, userData.UserId)
+
+	now := time.Now()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dailyTotal, dailyCount, err := db.GetOutgoingTransferTotals(PgDb, userData.UserId, startOfDay)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	weeklyTotal, _, err := db.GetOutgoingTransferTotals(PgDb, userData.UserId, startOfDay.AddDate(0, 0, -6))
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"limit": limit, "dailyTotal": roundAmount(dailyTotal), "dailyCount": dailyCount, "weeklyTotal": roundAmount(weeklyTotal)})
+}
+
+// SetTransferLimits overrides the global limits for one user. It is an admin
+// route because a per-user limit can raise the global ones.
+func SetTransferLimits(c *gin.Context) {
+	var limitReq TransferLimitRequest
+	if err := c.ShouldBindJSON(&limitReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	limit := db.TransferLimit{
+		UserId:     limitReq.UserId,
+		DailyCount: limitReq.DailyCount,
+		UpdatedAt:  time.Now(),
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		dest  *float64
+	}{
+		{"maxPerTransfer", limitReq.MaxPerTransfer, &limit.MaxPerTransfer},
+		{"dailyLimit", limitReq.DailyLimit, &limit.DailyLimit},
+		{"weeklyLimit", limitReq.WeeklyLimit, &limit.WeeklyLimit},
+	} {
+		if len(field.value) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(field.value, 64)
+		if err != nil || value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: %s", field.name, field.value)})
+			return
+		}
+		*field.dest = value
+	}
+	if limit.DailyCount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dailyCount"})
+		return
+	}
+
+	if err := db.SaveTransferLimit(PgDb, limit); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer Limits Updated Successfully", "limit": GetEffectiveTransferLimit(PgDb, limit.UserId)})
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+const (
+	// LinkPurposeFunding links a checking or savings account that can send and
+	// receive money through Dwolla.
+	LinkPurposeFunding = "funding"
+	// LinkPurposeLiabilities links credit cards and loans for the net-worth
+	// view. They never become funding sources.
+	LinkPurposeLiabilities = "liabilities"
+	// LinkPurposeInvestments links brokerage and retirement accounts for the
+	// net-worth view. They never become funding sources.
+	LinkPurposeInvestments = "investments"
+)
+
+// LinkProductSet is what Link asks the user for when linking for a purpose.
+type LinkProductSet struct {
+	Products       []plaid.Products
+	AccountFilters plaid.LinkTokenAccountFilters
+}
+
+// LinkProductSets maps each link purpose to its products and account filters.
+// The products can be replaced with LINK_PRODUCTS_<PURPOSE>.
+var LinkProductSets = map[string]LinkProductSet{
+	LinkPurposeFunding: {
+		Products: []plaid.Products{plaid.PRODUCTS_AUTH, plaid.PRODUCTS_TRANSACTIONS, plaid.PRODUCTS_IDENTITY},
+		AccountFilters: plaid.LinkTokenAccountFilters{
+			Depository: &plaid.DepositoryFilter{
+				AccountSubtypes: []plaid.AccountSubtype{plaid.ACCOUNTSUBTYPE_CHECKING, plaid.ACCOUNTSUBTYPE_SAVINGS},
+			},
+		},
+	},
+	LinkPurposeLiabilities: {
+		Products: []plaid.Products{plaid.PRODUCTS_LIABILITIES},
+		AccountFilters: plaid.LinkTokenAccountFilters{
+			Credit: &plaid.CreditFilter{
+				AccountSubtypes: []plaid.AccountSubtype{plaid.ACCOUNTSUBTYPE_CREDIT_CARD},
+			},
+			Loan: &plaid.LoanFilter{
+				AccountSubtypes: []plaid.AccountSubtype{plaid.ACCOUNTSUBTYPE_STUDENT, plaid.ACCOUNTSUBTYPE_MORTGAGE},
+			},
+		},
+	},
+	LinkPurposeInvestments: {
+		Products: []plaid.Products{plaid.PRODUCTS_INVESTMENTS},
+		AccountFilters: plaid.LinkTokenAccountFilters{
+			Investment: &plaid.InvestmentFilter{
+				AccountSubtypes: []plaid.AccountSubtype{
+					plaid.ACCOUNTSUBTYPE_BROKERAGE,
+					plaid.ACCOUNTSUBTYPE_IRA,
+					plaid.ACCOUNTSUBTYPE_ROTH,
+					plaid.ACCOUNTSUBTYPE__401K,
+					plaid.ACCOUNTSUBTYPE_ROTH_401K,
+					plaid.ACCOUNTSUBTYPE__403_B,
+					plaid.ACCOUNTSUBTYPE__529,
+					plaid.ACCOUNTSUBTYPE_HSA,
+				},
+			},
+		},
+	},
+}
+
+// requiredLinkProducts must stay in a purpose's product set whatever the
+// environment says: funding links need Auth for the Dwolla processor token
+// and each net-worth purpose needs the product it reads.
+var requiredLinkProducts = map[string]plaid.Products{
+	LinkPurposeFunding:     plaid.PRODUCTS_AUTH,
+	LinkPurposeLiabilities: plaid.PRODUCTS_LIABILITIES,
+	LinkPurposeInvestments: plaid.PRODUCTS_INVESTMENTS,
+}
+
+// LoadLinkProductSets replaces the products of each purpose with the comma
+// separated list in LINK_PRODUCTS_<PURPOSE> when it is set and valid.
+func LoadLinkProductSets() {
+	for purpose, productSet := range LinkProductSets {
+		value := os.Getenv("LINK_PRODUCTS_" + strings.ToUpper(purpose))
+		if len(value) == 0 {
+			continue
+		}
+		products, err := parseLinkProducts(value)
+		if err == nil && !slices.Contains(products, requiredLinkProducts[purpose]) {
+			err = fmt.Errorf("%s links require the %s product", purpose, requiredLinkProducts[purpose])
+		}
+		if err != nil {
+			log.Println("ignoring LINK_PRODUCTS_" + strings.ToUpper(purpose) + ": " + err.Error())
+			continue
+		}
+		productSet.Products = products
+		LinkProductSets[purpose] = productSet
+	}
+}
+
+func parseLinkProducts(value string) ([]plaid.Products, error) {
+	var products []plaid.Products
+	for _, name := range strings.Split(value, ",") {
+		product, err := plaid.NewProductsFromValue(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, *product)
+	}
+	return products, nil
+}
+
+// ResolveLinkPurpose defaults an empty purpose to funding and rejects
+// unknown ones.
+func ResolveLinkPurpose(purpose string) (string, error) {
+	if len(purpose) == 0 {
+		return LinkPurposeFunding, nil
+	}
+	if _, ok := LinkProductSets[purpose]; !ok {
+		return "", fmt.Errorf("unknown link purpose %q", purpose)
+	}
+	return purpose, nil
+}
@@ -0,0 +1,396 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/plaid/plaid-go/plaid"
+	"gorm.io/gorm"
+)
+
+const (
+	LiabilityCreditCard  = "credit_card"
+	LiabilityStudentLoan = "student_loan"
+	LiabilityMortgage    = "mortgage"
+
+	// InvestmentHistoryDays is how far back investment transactions are
+	// loaded when an item is linked; Plaid keeps 24 months.
+	InvestmentHistoryDays = 730
+	// InvestmentRefreshDays is the window reloaded on later refreshes, wide
+	// enough to pick up late corrections.
+	InvestmentRefreshDays = 90
+)
+
+type NetWorthRequest struct {
+	UserId    string `json:"userId" binding:"required"`
+	TrackId   string `json:"plaidTrackId"`
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+}
+
+type NetWorthRefreshRequest struct {
+	UserId  string `json:"userId" binding:"required"`
+	TrackId string `json:"plaidTrackId" binding:"required"`
+}
+
+type LiabilityApr struct {
+	Type                string  `json:"type"`
+	Percentage          float64 `json:"percentage"`
+	BalanceSubjectToApr float64 `json:"balanceSubjectToApr"`
+}
+
+type LiabilityView struct {
+	db.Liability
+	Aprs []LiabilityApr `json:"aprs,omitempty"`
+}
+
+type LiabilityTotals struct {
+	CreditCard  float64 `json:"creditCard"`
+	StudentLoan float64 `json:"studentLoan"`
+	Mortgage    float64 `json:"mortgage"`
+	Total       float64 `json:"total"`
+}
+
+// newLiability starts a liability from the account Plaid reports it on.
+func newLiability(bank db.PlaidUser, kind string, accountId string, accounts map[string]plaid.AccountBase, now time.Time) db.Liability {
+	account := accounts[accountId]
+	balances := account.GetBalances()
+	return db.Liability{
+		AccountId:      accountId,
+		TrackId:        bank.TrackId,
+		UserId:         bank.UserId,
+		Kind:           kind,
+		Name:           account.GetName(),
+		Mask:           account.GetMask(),
+		CurrentBalance: roundAmount(float64(balances.GetCurrent())),
+		UpdatedAt:      now,
+	}
+}
+
+// ConvertToLiabilities flattens credit cards, student loans and mortgages
+// into one row per account.
+func ConvertToLiabilities(bank db.PlaidUser, liabilitiesResp plaid.LiabilitiesGetResponse, now time.Time) []db.Liability {
+	accounts := make(map[string]plaid.AccountBase)
+	for _, account := range liabilitiesResp.GetAccounts() {
+		accounts[account.GetAccountId()] = account
+	}
+	object := liabilitiesResp.GetLiabilities()
+	var liabilities []db.Liability
+
+	for _, credit := range object.GetCredit() {
+		liability := newLiability(bank, LiabilityCreditCard, credit.GetAccountId(), accounts, now)
+		var aprs []LiabilityApr
+		for _, apr := range credit.GetAprs() {
+			aprs = append(aprs, LiabilityApr{
+				Type:                apr.GetAprType(),
+				Percentage:          float64(apr.GetAprPercentage()),
+				BalanceSubjectToApr: roundAmount(float64(apr.GetBalanceSubjectToApr())),
+			})
+			if apr.GetAprType() == "purchase_apr" {
+				liability.InterestRate = float64(apr.GetAprPercentage())
+				liability.InterestRateType = apr.GetAprType()
+			}
+		}
+		encoded, _ := json.Marshal(aprs)
+		liability.Aprs = string(encoded)
+		liability.MinimumPayment = roundAmount(float64(credit.GetMinimumPaymentAmount()))
+		liability.NextPaymentDueDate = credit.GetNextPaymentDueDate()
+		liability.LastPaymentAmount = roundAmount(float64(credit.GetLastPaymentAmount()))
+		liability.LastPaymentDate = credit.GetLastPaymentDate()
+		liability.LastStatementBalance = roundAmount(float64(credit.GetLastStatementBalance()))
+		liability.IsOverdue = credit.GetIsOverdue()
+		liabilities = append(liabilities, liability)
+	}
+
+	for _, student := range object.GetStudent() {
+		liability := newLiability(bank, LiabilityStudentLoan, student.GetAccountId(), accounts, now)
+		repaymentPlan := student.GetRepaymentPlan()
+		liability.InterestRate = float64(student.GetInterestRatePercentage())
+		liability.MinimumPayment = roundAmount(float64(student.GetMinimumPaymentAmount()))
+		liability.NextPaymentDueDate = student.GetNextPaymentDueDate()
+		liability.LastPaymentAmount = roundAmount(float64(student.GetLastPaymentAmount()))
+		liability.LastPaymentDate = student.GetLastPaymentDate()
+		liability.IsOverdue = student.GetIsOverdue()
+		liability.OriginationPrincipal = roundAmount(float64(student.GetOriginationPrincipalAmount()))
+		liability.OriginationDate = student.GetOriginationDate()
+		liability.PayoffDate = student.GetExpectedPayoffDate()
+		liability.Description = student.GetLoanName()
+		if len(repaymentPlan.GetDescription()) > 0 {
+			liability.Description += " - " + repaymentPlan.GetDescription()
+		}
+		liabilities = append(liabilities, liability)
+	}
+
+	for _, mortgage := range object.GetMortgage() {
+		liability := newLiability(bank, LiabilityMortgage, mortgage.GetAccountId(), accounts, now)
+		interestRate := mortgage.GetInterestRate()
+		liability.InterestRate = float64(interestRate.GetPercentage())
+		liability.InterestRateType = interestRate.GetType()
+		liability.MinimumPayment = roundAmount(float64(mortgage.GetNextMonthlyPayment()))
+		liability.NextPaymentDueDate = mortgage.GetNextPaymentDueDate()
+		liability.LastPaymentAmount = roundAmount(float64(mortgage.GetLastPaymentAmount()))
+		liability.LastPaymentDate = mortgage.GetLastPaymentDate()
+		liability.PastDueAmount = roundAmount(float64(mortgage.GetPastDueAmount()))
+		liability.IsOverdue = liability.PastDueAmount > 0
+		liability.OriginationPrincipal = roundAmount(float64(mortgage.GetOriginationPrincipalAmount()))
+		liability.OriginationDate = mortgage.GetOriginationDate()
+		liability.PayoffDate = mortgage.GetMaturityDate()
+		liability.Description = mortgage.GetLoanTypeDescription()
+		liabilities = append(liabilities, liability)
+	}
+	return liabilities
+}
+
+// securityDetails indexes securities by id so holdings and transactions can
+// carry a name and ticker.
+func securityDetails(securities []plaid.Security) map[string]plaid.Security {
+	details := make(map[string]plaid.Security, len(securities))
+	for _, security := range securities {
+		details[security.GetSecurityId()] = security
+	}
+	return details
+}
+
+func ConvertToInvestmentHoldings(bank db.PlaidUser, holdingsResp plaid.InvestmentsHoldingsGetResponse, now time.Time) []db.InvestmentHolding {
+	accountNames := make(map[string]string)
+	for _, account := range holdingsResp.GetAccounts() {
+		accountNames[account.GetAccountId()] = account.GetName()
+	}
+	securities := securityDetails(holdingsResp.GetSecurities())
+
+	var holdings []db.InvestmentHolding
+	for _, holding := range holdingsResp.GetHoldings() {
+		security := securities[holding.GetSecurityId()]
+		investmentHolding := db.InvestmentHolding{
+			AccountId:            holding.GetAccountId(),
+			SecurityId:           holding.GetSecurityId(),
+			TrackId:              bank.TrackId,
+			UserId:               bank.UserId,
+			AccountName:          accountNames[holding.GetAccountId()],
+			SecurityName:         security.GetName(),
+			TickerSymbol:         security.GetTickerSymbol(),
+			SecurityType:         security.GetType(),
+			Quantity:             float64(holding.GetQuantity()),
+			InstitutionPrice:     float64(holding.GetInstitutionPrice()),
+			InstitutionPriceAsOf: holding.GetInstitutionPriceAsOf(),
+			InstitutionValue:     roundAmount(float64(holding.GetInstitutionValue())),
+			IsoCurrencyCode:      holding.GetIsoCurrencyCode(),
+			UpdatedAt:            now,
+		}
+		if costBasis, ok := holding.GetCostBasisOk(); ok && costBasis != nil {
+			value := roundAmount(float64(*costBasis))
+			investmentHolding.CostBasis = &value
+		}
+		holdings = append(holdings, investmentHolding)
+	}
+	return holdings
+}
+
+func ConvertToInvestmentTransactions(bank db.PlaidUser, transactions []plaid.InvestmentTransaction, securityList []plaid.Security) []db.InvestmentTransaction {
+	securities := securityDetails(securityList)
+	var investmentTransactions []db.InvestmentTransaction
+	for _, transaction := range transactions {
+		security := securities[transaction.GetSecurityId()]
+		investmentTransactions = append(investmentTransactions, db.InvestmentTransaction{
+			InvestmentTransactionId: transaction.GetInvestmentTransactionId(),
+			AccountId:               transaction.GetAccountId(),
+			TrackId:                 bank.TrackId,
+			UserId:                  bank.UserId,
+			SecurityId:              transaction.GetSecurityId(),
+			SecurityName:            security.GetName(),
+			TickerSymbol:            security.GetTickerSymbol(),
+			Date:                    transaction.GetDate(),
+			Name:                    transaction.GetName(),
+			Type:                    transaction.GetType(),
+			Subtype:                 transaction.GetSubtype(),
+			Quantity:                float64(transaction.GetQuantity()),
+			Price:                   float64(transaction.GetPrice()),
+			Amount:                  roundAmount(float64(transaction.GetAmount())),
+			Fees:                    roundAmount(float64(transaction.GetFees())),
+			IsoCurrencyCode:         transaction.GetIsoCurrencyCode(),
+		})
+	}
+	return investmentTransactions
+}
+
+func RefreshLiabilities(bankdb *gorm.DB, bank db.PlaidUser) error {
+	liabilitiesResp, err := GetLiabilitiesFromPlaid(bank.AccessToken)
+	if err != nil {
+		return err
+	}
+	return db.ReplaceLiabilities(bankdb, bank.TrackId, ConvertToLiabilities(bank, liabilitiesResp, time.Now()))
+}
+
+func RefreshInvestmentHoldings(bankdb *gorm.DB, bank db.PlaidUser) error {
+	holdingsResp, err := GetInvestmentHoldingsFromPlaid(bank.AccessToken)
+	if err != nil {
+		return err
+	}
+	return db.ReplaceInvestmentHoldings(bankdb, bank.TrackId, ConvertToInvestmentHoldings(bank, holdingsResp, time.Now()))
+}
+
+// RefreshInvestmentTransactions reloads the last days of investment
+// transactions. Rows are upserted, so overlapping windows are harmless.
+func RefreshInvestmentTransactions(bankdb *gorm.DB, bank db.PlaidUser, days int) error {
+	now := time.Now()
+	startDate := now.AddDate(0, 0, -days).Format("2006-01-02")
+	transactions, securities, err := GetInvestmentTransactionsFromPlaid(bank.AccessToken, startDate, now.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	return db.UpsertInvestmentTransactions(bankdb, ConvertToInvestmentTransactions(bank, transactions, securities))
+}
+
+// RefreshNetWorthItem reloads whatever the item was linked for. A newly
+// linked investment item loads its full transaction history.
+func RefreshNetWorthItem(bankdb *gorm.DB, bank db.PlaidUser) error {
+	switch bank.LinkPurpose {
+	case LinkPurposeLiabilities:
+		return RefreshLiabilities(bankdb, bank)
+	case LinkPurposeInvestments:
+		if err := RefreshInvestmentHoldings(bankdb, bank); err != nil {
+			return err
+		}
+		days := InvestmentRefreshDays
+		if count, err := db.CountInvestmentTransactions(bankdb, bank.TrackId); err == nil && count == 0 {
+			days = InvestmentHistoryDays
+		}
+		return RefreshInvestmentTransactions(bankdb, bank, days)
+	}
+	return fmt.Errorf("bank %s is not linked for liabilities or investments", bank.TrackId)
+}
+
+// ownedNetWorthBank loads a credit, loan or investment item that belongs to
+// the user.
+func ownedNetWorthBank(userId string, trackId string) (db.PlaidUser, bool) {
+	bank, err := db.GetRecordUsingTrackId(PgDb, trackId)
+	if err != nil || bank.UserId != userId || IsFundingBank(bank) {
+		return db.PlaidUser{}, false
+	}
+	return bank, true
+}
+
+func RefreshNetWorth(c *gin.Context) {
+	var refreshReq NetWorthRefreshRequest
+	if err := c.ShouldBindJSON(&refreshReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	bank, ok := ownedNetWorthBank(refreshReq.UserId, refreshReq.TrackId)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no liabilities or investments account found for user"})
+		return
+	}
+	if err := RefreshNetWorthItem(PgDb, bank); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account Refreshed Successfully", "plaidTrackId": bank.TrackId, "purpose": bank.LinkPurpose})
+}
+
+func GetLiabilities(c *gin.Context) {
+	var userReq BankUserId
+	if err := c.ShouldBindJSON(&userReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	liabilities, err := db.GetLiabilitiesUsingUserId(PgDb, userReq.UserId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var totals LiabilityTotals
+	views := make([]LiabilityView, 0, len(liabilities))
+	for _, liability := range liabilities {
+		view := LiabilityView{Liability: liability}
+		if len(liability.Aprs) > 0 {
+			if err := json.Unmarshal([]byte(liability.Aprs), &view.Aprs); err != nil {
+				log.Println("unable to decode aprs of " + liability.AccountId + ": " + err.Error())
+			}
+		}
+		views = append(views, view)
+
+		switch liability.Kind {
+		case LiabilityCreditCard:
+			totals.CreditCard += liability.CurrentBalance
+		case LiabilityStudentLoan:
+			totals.StudentLoan += liability.CurrentBalance
+		case LiabilityMortgage:
+			totals.Mortgage += liability.CurrentBalance
+		}
+		totals.Total += liability.CurrentBalance
+	}
+	totals.CreditCard = roundAmount(totals.CreditCard)
+	totals.StudentLoan = roundAmount(totals.StudentLoan)
+	totals.Mortgage = roundAmount(totals.Mortgage)
+	totals.Total = roundAmount(totals.Total)
+
+	c.JSON(http.StatusOK, gin.H{"data": views, "totals": totals})
+}
+
+func GetInvestmentHoldings(c *gin.Context) {
+	var holdingsReq NetWorthRequest
+	if err := c.ShouldBindJSON(&holdingsReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	holdings, err := db.GetInvestmentHoldingsUsingUserId(PgDb, holdingsReq.UserId, holdingsReq.TrackId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var totalValue float64
+	for _, holding := range holdings {
+		totalValue += holding.InstitutionValue
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": holdings, "totalValue": roundAmount(totalValue)})
+}
+
+// GetInvestmentTransactions lists stored investment transactions, the last
+// InvestmentRefreshDays by default.
+func GetInvestmentTransactions(c *gin.Context) {
+	var transactionsReq NetWorthRequest
+	if err := c.ShouldBindJSON(&transactionsReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	now := time.Now()
+	if len(transactionsReq.StartDate) == 0 {
+		transactionsReq.StartDate = now.AddDate(0, 0, -InvestmentRefreshDays).Format("2006-01-02")
+	}
+	if len(transactionsReq.EndDate) == 0 {
+		transactionsReq.EndDate = now.Format("2006-01-02")
+	}
+	start, startErr := time.Parse("2006-01-02", transactionsReq.StartDate)
+	end, endErr := time.Parse("2006-01-02", transactionsReq.EndDate)
+	if startErr != nil || endErr != nil || end.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "startDate and endDate must look like 2024-01-31, with startDate first"})
+		return
+	}
+
+	transactions, err := db.GetInvestmentTransactionsUsingUserId(PgDb, transactionsReq.UserId, transactionsReq.TrackId, transactionsReq.StartDate, transactionsReq.EndDate)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": transactions})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	NotificationWebhookUrl string
+	// NotificationHook receives every event the service emits. It defaults to
+	// posting the event to NotificationWebhookUrl and can be swapped out.
+	NotificationHook = PostNotificationWebhook
+	// StepUpCodeHook delivers one-time codes. Unlike NotificationHook it must
+	// report a failure, since the caller cannot go on without the code.
+	StepUpCodeHook = PostStepUpCode
+
+	ErrNoDeliveryChannel = errors.New("no notification delivery channel is configured")
+)
+
+const (
+	EventBudgetThreshold = "budget.threshold_reached"
+
+	EventPaymentRequestCreated  = "payment_request.created"
+	EventPaymentRequestAccepted = "payment_request.accepted"
+	EventPaymentRequestDeclined = "payment_request.declined"
+	EventPaymentRequestExpired  = "payment_request.expired"
+
+	EventStepUpCodeIssued = "step_up.code_issued"
+)
+
+type NotificationEvent struct {
+	Type      string                 `json:"type"`
+	UserId    string                 `json:"userId"`
+	Payload   map[string]interface{} `json:"payload"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+func Notify(eventType string, userId string, payload map[string]interface{}) {
+	event := NotificationEvent{
+		Type:      eventType,
+		UserId:    userId,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}
+	if err := NotificationHook(event); err != nil {
+		log.Println("unable to deliver notification " + eventType + ": " + err.Error())
+	}
+}
+
+func PostNotificationWebhook(event NotificationEvent) error {
+	log.Println("Notification Event: ", event)
+	if len(NotificationWebhookUrl) == 0 {
+		return nil
+	}
+	return postNotification(event)
+}
+
+// PostStepUpCode posts a step-up code to NotificationWebhookUrl. The payload
+// carries the code, so only the event type is logged.
+func PostStepUpCode(event NotificationEvent) error {
+	log.Println("Notification Event: ", event.Type, " for user ", event.UserId)
+	if len(NotificationWebhookUrl) == 0 {
+		return ErrNoDeliveryChannel
+	}
+	return postNotification(event)
+}
+
+func postNotification(event NotificationEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error while encoding notification: %v", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, NotificationWebhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error while creating notification request: %v", err.Error())
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error while posting notification: %v", err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook responded with status %d", response.StatusCode)
+	}
+	return nil
+}
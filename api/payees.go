@@ -0,0 +1,211 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+// PayeeCoolingOff is how long a newly saved payee waits before transfers can
+// use it. It only covers transfers that name a payee; a first transfer to a
+// raw shareable id is scored by NewReceiverCheck instead.
+var PayeeCoolingOff = 24 * time.Hour
+
+const (
+	PayeeStatusActive      = "active"
+	PayeeStatusCoolingOff  = "cooling_off"
+	MaxPayeeNicknameLength = 64
+
+	RejectPayeeNotFound   = "PAYEE_NOT_FOUND"
+	RejectPayeeCoolingOff = "PAYEE_COOLING_OFF"
+)
+
+type PayeeRequest struct {
+	UserId      string `json:"userId" binding:"required"`
+	ShareableId string `json:"sharableId" binding:"required"`
+	Nickname    string `json:"nickname" binding:"required"`
+}
+
+type PayeeIdRequest struct {
+	UserId  string `json:"userId" binding:"required"`
+	PayeeId string `json:"payeeId" binding:"required"`
+}
+
+type PayeeListRequest struct {
+	UserId string `json:"userId" binding:"required"`
+}
+
+type PayeeStatus struct {
+	db.Payee
+	Status string `json:"status"`
+}
+
+// LoadPayeeCoolingOff replaces the default cooling-off period with
+// PAYEE_COOLING_OFF_HOURS when it is set. Zero turns it off.
+func LoadPayeeCoolingOff() {
+	if hours, err := strconv.Atoi(os.Getenv("PAYEE_COOLING_OFF_HOURS")); err == nil && hours >= 0 {
+		PayeeCoolingOff = time.Duration(hours) * time.Hour
+	}
+}
+
+func payeeStatus(payee db.Payee, now time.Time) PayeeStatus {
+	status := PayeeStatusActive
+	if now.Before(payee.ActiveAt) {
+		status = PayeeStatusCoolingOff
+	}
+	return PayeeStatus{Payee: payee, Status: status}
+}
+
+// ReceiverInstitution names the receiver's bank, from the stored name for
+// manual banks or from Plaid for linked ones.
+func ReceiverInstitution(receiverBank db.PlaidUser) string {
+	if IsManualBank(receiverBank) {
+		return receiverBank.BankName
+	}
+	_, accountItem, err := GetAccounts(receiverBank.AccessToken)
+	if err != nil {
+		log.Println(err.Error())
+		return ""
+	}
+	institutionId, _ := GetDefaultInstitutionId(accountItem)
+	if len(institutionId) == 0 {
+		return ""
+	}
+	name, err := GetInstitutionName(institutionId)
+	if err != nil {
+		log.Println(err.Error())
+		return ""
+	}
+	return name
+}
+
+// ApplyPayee fills in the receiver's shareable id when a transfer names a
+// saved payee. The payee must belong to the owner of the sending bank and be
+// past its cooling-off period.
+func ApplyPayee(bankdb *gorm.DB, paymentTransferReq *PaymentTransfer, now time.Time) error {
+	if len(paymentTransferReq.PayeeId) == 0 {
+		return nil
+	}
+	if len(paymentTransferReq.ShareableId) > 0 {
+		return fmt.Errorf("give either a payee id or a shareable id, not both")
+	}
+	senderBank, err := db.GetRecordUsingTrackId(bankdb, paymentTransferReq.SenderBank)
+	if err != nil {
+		return fmt.Errorf("unable to fetch record using track id: %v", err.Error())
+	}
+	payee, err := db.GetPayeeUsingId(bankdb, paymentTransferReq.PayeeId)
+	if err != nil || payee.UserId != senderBank.UserId {
+		return &TransferRejectedError{Rejections: []TransferRejection{{Code: RejectPayeeNotFound, Message: "payee not found for user"}}}
+	}
+	if now.Before(payee.ActiveAt) {
+		return &TransferRejectedError{Rejections: []TransferRejection{{
+			Code:    RejectPayeeCoolingOff,
+			Message: fmt.Sprintf("payee %s can receive transfers from %s", payee.Nickname, payee.ActiveAt.Format(time.RFC3339)),
+		}}}
+	}
+	paymentTransferReq.ShareableId = payee.ShareableId
+	return nil
+}
+
+func CreatePayee(c *gin.Context) {
+	var payeeReq PayeeRequest
+	if err := c.ShouldBindJSON(&payeeReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if len(payeeReq.Nickname) > MaxPayeeNicknameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("nickname must be at most %d characters", MaxPayeeNicknameLength)})
+		return
+	}
+
+	receiverBank, err := ResolveShareableId(PgDb, payeeReq.ShareableId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown receiver"})
+		return
+	}
+	if receiverBank.UserId == payeeReq.UserId {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot save your own bank as a payee"})
+		return
+	}
+
+	receiverName, receiverMask := ReceiverDisplay(PgDb, receiverBank)
+	now := time.Now()
+	payee := db.Payee{
+		PayeeId:         utils.GenerateId("PAYEE"),
+		UserId:          payeeReq.UserId,
+		ReceiverTrackId: receiverBank.TrackId,
+		Nickname:        payeeReq.Nickname,
+		ShareableId:     payeeReq.ShareableId,
+		ReceiverName:    receiverName,
+		AccountMask:     receiverMask,
+		InstitutionName: ReceiverInstitution(receiverBank),
+		CreatedAt:       now,
+		ActiveAt:        now.Add(PayeeCoolingOff),
+	}
+	created, err := db.AddPayee(PgDb, payee)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !created {
+		c.JSON(http.StatusConflict, gin.H{"error": "payee already saved"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payee Created Successfully", "data": payeeStatus(payee, now)})
+}
+
+func GetPayees(c *gin.Context) {
+	var listReq PayeeListRequest
+	if err := c.ShouldBindJSON(&listReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	payees, err := db.GetPayeesUsingUserId(PgDb, listReq.UserId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	statuses := make([]PayeeStatus, 0, len(payees))
+	for _, payee := range payees {
+		statuses = append(statuses, payeeStatus(payee, now))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": statuses})
+}
+
+func DeletePayee(c *gin.Context) {
+	var payeeReq PayeeIdRequest
+	if err := c.ShouldBindJSON(&payeeReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	payee, err := db.GetPayeeUsingId(PgDb, payeeReq.PayeeId)
+	if err != nil || payee.UserId != payeeReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payee not found for user"})
+		return
+	}
+	if err := db.DeletePayee(PgDb, payee.PayeeId); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payee Deleted Successfully", "payeeId": payee.PayeeId})
+}
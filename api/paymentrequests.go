@@ -0,0 +1,337 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+// PaymentRequestTTL is how long a payer has to answer a payment request.
+var PaymentRequestTTL = 7 * 24 * time.Hour
+
+const (
+	PaymentRequestPending   = "pending"
+	PaymentRequestAccepting = "accepting"
+	PaymentRequestAccepted  = "accepted"
+	PaymentRequestHeld      = "held"
+	PaymentRequestDeclined  = "declined"
+	PaymentRequestExpired   = "expired"
+
+	MaxPaymentRequestNoteLength = 140
+)
+
+type PaymentRequestBody struct {
+	UserId      string `json:"userId" binding:"required"`
+	TrackId     string `json:"plaidTrackId" binding:"required"`
+	ShareableId string `json:"sharableId" binding:"required"`
+	Amount      string `json:"amount" binding:"required"`
+	Note        string `json:"note"`
+}
+
+type PaymentRequestListRequest struct {
+	UserId string `json:"userId" binding:"required"`
+	Status string `json:"status"`
+}
+
+type PaymentRequestActionRequest struct {
+	UserId     string `json:"userId" binding:"required"`
+	RequestId  string `json:"requestId" binding:"required"`
+	SenderBank string `json:"senderBank"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+}
+
+// LoadPaymentRequestTTL replaces the default expiry with PAYMENT_REQUEST_TTL_HOURS
+// when it is set.
+func LoadPaymentRequestTTL() {
+	if hours, err := strconv.Atoi(os.Getenv("PAYMENT_REQUEST_TTL_HOURS")); err == nil && hours > 0 {
+		PaymentRequestTTL = time.Duration(hours) * time.Hour
+	}
+}
+
+// ResolveShareableId returns the bank behind a shareable id.
+func ResolveShareableId(bankdb *gorm.DB, shareableId string) (db.PlaidUser, error) {
+	accountId, err := utils.DecryptID(shareableId)
+	if err != nil {
+		return db.PlaidUser{}, fmt.Errorf("unable to decrypt shareable id: %v", err.Error())
+	}
+	bank, err := db.GetRecordUsingAccountId(bankdb, accountId)
+	if err != nil {
+		return db.PlaidUser{}, err
+	}
+	if !IsFundingBank(bank) {
+		return db.PlaidUser{}, fmt.Errorf("bank %s is not a funding source", bank.TrackId)
+	}
+	return bank, nil
+}
+
+// NewPaymentRequest builds a pending request for the payer to pay into the
+// requester's bank.
+func NewPaymentRequest(requesterBank db.PlaidUser, payerBank db.PlaidUser, payerShareableId string, amount string, note string, now time.Time) db.PaymentRequest {
+	return db.PaymentRequest{
+		RequestId:            utils.GenerateId("PAYREQ"),
+		RequesterId:          requesterBank.UserId,
+		RequesterTrackId:     requesterBank.TrackId,
+		RequesterShareableId: requesterBank.ShareableId,
+		PayerId:              payerBank.UserId,
+		PayerShareableId:     payerShareableId,
+		Amount:               amount,
+		Note:                 note,
+		Status:               PaymentRequestPending,
+		CreatedAt:            now,
+		ExpiresAt:            now.Add(PaymentRequestTTL),
+	}
+}
+
+func paymentRequestPayload(request db.PaymentRequest) map[string]interface{} {
+	return map[string]interface{}{
+		"requestId":     request.RequestId,
+		"requesterId":   request.RequesterId,
+		"payerId":       request.PayerId,
+		"amount":        request.Amount,
+		"note":          request.Note,
+		"status":        request.Status,
+		"transactionId": request.TransactionId,
+		"billId":        request.BillId,
+	}
+}
+
+// ExpirePaymentRequests expires pending requests past their expiry and tells
+// both sides.
+func ExpirePaymentRequests(bankdb *gorm.DB, now time.Time) {
+	expired, err := db.ExpirePaymentRequests(bankdb, PaymentRequestPending, PaymentRequestExpired, now)
+	if err != nil {
+		log.Println("unable to expire payment requests: " + err.Error())
+		return
+	}
+	for _, request := range expired {
+		payload := paymentRequestPayload(request)
+		Notify(EventPaymentRequestExpired, request.RequesterId, payload)
+		Notify(EventPaymentRequestExpired, request.PayerId, payload)
+	}
+}
+
+func CreatePaymentRequest(c *gin.Context) {
+	var requestBody PaymentRequestBody
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	amount, err := strconv.ParseFloat(requestBody.Amount, 64)
+	if !amountPattern.MatchString(requestBody.Amount) || err != nil || amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("amount %q must be a positive value with at most two decimals", requestBody.Amount)})
+		return
+	}
+	if len(requestBody.Note) > MaxPaymentRequestNoteLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("note must be at most %d characters", MaxPaymentRequestNoteLength)})
+		return
+	}
+
+	requesterBank, err := db.GetRecordUsingTrackId(PgDb, requestBody.TrackId)
+	if err != nil || requesterBank.UserId != requestBody.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no account found for user"})
+		return
+	}
+	payerBank, err := ResolveShareableId(PgDb, requestBody.ShareableId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown payer"})
+		return
+	}
+	if payerBank.UserId == requestBody.UserId {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot request money from yourself"})
+		return
+	}
+
+	paymentRequest := NewPaymentRequest(requesterBank, payerBank, requestBody.ShareableId, requestBody.Amount, requestBody.Note, time.Now())
+	if err := db.AddPaymentRequest(PgDb, paymentRequest); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	Notify(EventPaymentRequestCreated, paymentRequest.PayerId, paymentRequestPayload(paymentRequest))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment Request Created Successfully", "data": paymentRequest})
+}
+
+// ListPaymentRequests returns the requests the user has to answer and the ones
+// they sent. Only pending requests are listed unless a status is given.
+func ListPaymentRequests(c *gin.Context) {
+	var listReq PaymentRequestListRequest
+	if err := c.ShouldBindJSON(&listReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	statuses := []string{PaymentRequestPending}
+	if len(listReq.Status) > 0 {
+		statuses = []string{listReq.Status}
+	}
+
+	ExpirePaymentRequests(PgDb, time.Now())
+	incoming, outgoing, err := db.GetPaymentRequestsUsingUserId(PgDb, listReq.UserId, statuses)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"incoming": incoming, "outgoing": outgoing})
+}
+
+// AcceptPaymentRequest pays the requester from one of the payer's banks. The
+// amount and receiving bank come from the stored request.
+func AcceptPaymentRequest(c *gin.Context) {
+	var actionReq PaymentRequestActionRequest
+	if err := c.ShouldBindJSON(&actionReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if len(actionReq.SenderBank) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - senderBank is required"})
+		return
+	}
+
+	paymentRequest, err := db.GetPaymentRequestUsingId(PgDb, actionReq.RequestId)
+	if err != nil || paymentRequest.PayerId != actionReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment request not found for user"})
+		return
+	}
+	senderBank, err := db.GetRecordUsingTrackId(PgDb, actionReq.SenderBank)
+	if err != nil || senderBank.UserId != actionReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no account found for user"})
+		return
+	}
+
+	claimed, err := db.UpdatePaymentRequestStatus(PgDb, paymentRequest.RequestId, PaymentRequestPending, map[string]interface{}{"status": PaymentRequestAccepting}, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !claimed {
+		respondPaymentRequestNotPending(c, paymentRequest)
+		return
+	}
+
+	name := actionReq.Name
+	if len(name) == 0 {
+		name = paymentRequest.Note
+	}
+	if len(name) == 0 {
+		name = "Payment Request"
+	}
+	transactionRes, err := ExecuteTransfer(PgDb, PaymentTransfer{
+		Name:        name,
+		Email:       actionReq.Email,
+		Amount:      paymentRequest.Amount,
+		SenderBank:  senderBank.TrackId,
+		ShareableId: paymentRequest.RequesterShareableId,
+	})
+	respondedAt := time.Now()
+	if err != nil {
+		log.Println(err.Error())
+		var heldErr *TransferHeldError
+		if errors.As(err, &heldErr) {
+			paymentRequest.DecisionId = heldErr.DecisionId
+			finishPaymentRequest(paymentRequest, PaymentRequestHeld, "", "", respondedAt)
+			c.JSON(http.StatusAccepted, gin.H{"message": "Transfer Held For Review", "decisionId": heldErr.DecisionId})
+			return
+		}
+		// A failed transfer leaves the request pending so the payer can retry
+		// from another bank.
+		finishPaymentRequest(paymentRequest, PaymentRequestPending, "", err.Error(), respondedAt)
+		var rejectedErr *TransferRejectedError
+		if errors.As(err, &rejectedErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "reasons": rejectedErr.Rejections})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	paymentRequest = finishPaymentRequest(paymentRequest, PaymentRequestAccepted, transactionRes.TransactionId, "", respondedAt)
+	Notify(EventPaymentRequestAccepted, paymentRequest.RequesterId, paymentRequestPayload(paymentRequest))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment Request Accepted", "data": transactionRes})
+}
+
+func DeclinePaymentRequest(c *gin.Context) {
+	var actionReq PaymentRequestActionRequest
+	if err := c.ShouldBindJSON(&actionReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	paymentRequest, err := db.GetPaymentRequestUsingId(PgDb, actionReq.RequestId)
+	if err != nil || paymentRequest.PayerId != actionReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment request not found for user"})
+		return
+	}
+	respondedAt := time.Now()
+	claimed, err := db.UpdatePaymentRequestStatus(PgDb, paymentRequest.RequestId, PaymentRequestPending, map[string]interface{}{"status": PaymentRequestDeclined, "responded_at": respondedAt}, respondedAt)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !claimed {
+		respondPaymentRequestNotPending(c, paymentRequest)
+		return
+	}
+	paymentRequest.Status = PaymentRequestDeclined
+	paymentRequest.RespondedAt = &respondedAt
+	Notify(EventPaymentRequestDeclined, paymentRequest.RequesterId, paymentRequestPayload(paymentRequest))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment Request Declined"})
+}
+
+func respondPaymentRequestNotPending(c *gin.Context, paymentRequest db.PaymentRequest) {
+	status := paymentRequest.Status
+	if status == PaymentRequestPending {
+		status = PaymentRequestExpired
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("payment request is %s", status), "status": status})
+}
+
+func finishPaymentRequest(paymentRequest db.PaymentRequest, status string, transactionId string, failureReason string, respondedAt time.Time) db.PaymentRequest {
+	updates := map[string]interface{}{"status": status, "transaction_id": transactionId, "decision_id": paymentRequest.DecisionId, "failure_reason": failureReason}
+	if status != PaymentRequestPending {
+		updates["responded_at"] = respondedAt
+		paymentRequest.RespondedAt = &respondedAt
+	}
+	if err := db.FinishPaymentRequest(PgDb, paymentRequest.RequestId, updates); err != nil {
+		log.Println(err.Error())
+	}
+	paymentRequest.Status = status
+	paymentRequest.TransactionId = transactionId
+	paymentRequest.FailureReason = failureReason
+	return paymentRequest
+}
+
+// settleHeldPaymentRequest moves on a payment request whose transfer was held
+// for review: it is accepted once the transfer is approved and sent, and goes
+// back to pending when the review rejects it or the transfer fails, so the
+// payer can pay another way.
+func settleHeldPaymentRequest(decisionId string, transactionId string, failureReason string) {
+	paymentRequest, err := db.GetPaymentRequestUsingDecisionId(PgDb, decisionId)
+	if err != nil || paymentRequest.Status != PaymentRequestHeld {
+		return
+	}
+	if len(transactionId) == 0 {
+		finishPaymentRequest(paymentRequest, PaymentRequestPending, "", failureReason, time.Now())
+		return
+	}
+	paymentRequest = finishPaymentRequest(paymentRequest, PaymentRequestAccepted, transactionId, "", time.Now())
+	Notify(EventPaymentRequestAccepted, paymentRequest.RequesterId, paymentRequestPayload(paymentRequest))
+}
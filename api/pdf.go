@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/go-pdf/fpdf"
+)
+
+const pdfIssuer = "BitsBank"
+
+// newPdfDocument starts a Letter page with the issuer header and a page
+// number footer. The returned function converts text to the core font
+// encoding.
+func newPdfDocument(title string) (*fpdf.Fpdf, func(string) string) {
+	pdf := fpdf.New("P", "mm", "Letter", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator(pdfIssuer, true)
+	pdf.SetMargins(15, 15, 15)
+	pdf.AliasNbPages("")
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetHeaderFunc(func() {
+		pdf.SetFont("Helvetica", "B", 16)
+		pdf.CellFormat(0, 10, translate(pdfIssuer), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 11)
+		pdf.CellFormat(0, 10, translate(title), "", 1, "R", false, 0, "")
+		pdf.Line(15, pdf.GetY(), 200.9, pdf.GetY())
+		pdf.Ln(4)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 10, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+	return pdf, translate
+}
+
+func pdfBytes(pdf *fpdf.Fpdf) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("error while rendering pdf: %v", err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfField writes a label and value pair on its own row.
+func pdfField(pdf *fpdf.Fpdf, translate func(string) string, label string, value string) {
+	if len(value) == 0 {
+		return
+	}
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(50, 7, translate(label), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 7, translate(value), "", 1, "L", false, 0, "")
+}
+
+func RenderReceiptPdf(receipt TransferReceipt) ([]byte, error) {
+	pdf, translate := newPdfDocument("Transfer Receipt")
+
+	pdf.SetFont("Helvetica", "B", 22)
+	pdf.CellFormat(0, 14, translate("$"+receipt.Amount), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 7, translate(fmt.Sprintf("Transfer %s - %s", receipt.Direction, receipt.Status)), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	pdfField(pdf, translate, "Transaction", receipt.TransactionId)
+	pdfField(pdf, translate, "Dwolla transfer", receipt.DwollaTransferId)
+	pdfField(pdf, translate, "Description", receipt.Description)
+	pdfField(pdf, translate, "Initiated", receipt.InitiatedDate)
+	if receipt.ProcessedAt != nil {
+		pdfField(pdf, translate, "Processed", receipt.ProcessedAt.Format("2006-01-02 15:04 MST"))
+	}
+	if receipt.FailedAt != nil {
+		pdfField(pdf, translate, "Failed", receipt.FailedAt.Format("2006-01-02 15:04 MST"))
+		pdfField(pdf, translate, "Failure reason", receipt.FailureReason)
+	}
+	pdfField(pdf, translate, "Clearing", receipt.Clearing)
+	pdf.Ln(3)
+	pdfField(pdf, translate, "From", fmt.Sprintf("%s %s", receipt.SenderName, receipt.SenderMask))
+	pdfField(pdf, translate, "To", fmt.Sprintf("%s %s", receipt.ReceiverName, receipt.ReceiverMask))
+	pdf.Ln(3)
+	pdfField(pdf, translate, "Amount", "$"+receipt.Amount)
+	if len(receipt.FeeAmount) > 0 {
+		pdfField(pdf, translate, "Fee", "$"+receipt.FeeAmount)
+		pdfField(pdf, translate, "Total", "$"+receipt.Total)
+	}
+
+	pdf.Ln(8)
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.CellFormat(0, 5, translate("Issued "+receipt.IssuedAt.Format("2006-01-02 15:04 MST")), "", 1, "L", false, 0, "")
+	return pdfBytes(pdf)
+}
+
+func RenderStatementPdf(statement AccountStatement) ([]byte, error) {
+	pdf, translate := newPdfDocument("Statement " + statement.Month)
+
+	account := statement.AccountName
+	if len(statement.AccountMask) > 0 {
+		account += " ****" + statement.AccountMask
+	}
+	pdfField(pdf, translate, "Account", account)
+	pdfField(pdf, translate, "Period", statement.StartDate+" to "+statement.EndDate)
+	balance := func(amount *float64) string {
+		if amount == nil {
+			return "Not available"
+		}
+		return formatMoney(*amount)
+	}
+	pdfField(pdf, translate, "Opening balance", balance(statement.OpeningBalance))
+	pdfField(pdf, translate, "Closing balance", balance(statement.ClosingBalance))
+	if statement.BalanceSource == BalanceDerived {
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 5, translate("One balance is derived from the period's activity."), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	widths := []float64{24, 86, 38, 38}
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(235, 235, 235)
+	for i, heading := range []string{"Date", "Description", "Category", "Amount"} {
+		align := "L"
+		if i == len(widths)-1 {
+			align = "R"
+		}
+		pdf.CellFormat(widths[i], 7, heading, "B", 0, align, true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 9)
+	for _, line := range statement.Lines {
+		description := line.Description
+		if line.Pending {
+			description += " (pending)"
+		} else if !activityCounts(line) {
+			description += " (" + line.Status + ")"
+		}
+		if pdf.GetStringWidth(translate(description)) > widths[1]-2 {
+			runes := []rune(description)
+			for len(runes) > 0 && pdf.GetStringWidth(translate(string(runes)+"...")) > widths[1]-2 {
+				runes = runes[:len(runes)-1]
+			}
+			description = string(runes) + "..."
+		}
+		pdf.CellFormat(widths[0], 6, line.Date, "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 6, translate(description), "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[2], 6, translate(line.Category), "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[3], 6, formatMoney(line.Amount), "", 1, "R", false, 0, "")
+	}
+	if len(statement.Lines) == 0 {
+		pdf.CellFormat(0, 6, "No activity this period.", "", 1, "L", false, 0, "")
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 10)
+	totals := [][2]string{
+		{"Transactions", strconv.Itoa(statement.Totals.Count)},
+		{"Money in", formatMoney(statement.Totals.Credits)},
+		{"Money out", formatMoney(-statement.Totals.Debits)},
+		{"Net change", formatMoney(statement.Totals.Net)},
+	}
+	for _, total := range totals {
+		pdf.CellFormat(widths[0]+widths[1]+widths[2], 6, total[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 6, total[1], "", 1, "R", false, 0, "")
+	}
+
+	pdf.Ln(8)
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.CellFormat(0, 5, "Generated "+statement.GeneratedAt.Format("2006-01-02 15:04 MST"), "", 1, "L", false, 0, "")
+	return pdfBytes(pdf)
+}
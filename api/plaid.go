@@ -2,12 +2,16 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
 	"github.com/plaid/plaid-go/plaid"
+	"gorm.io/gorm"
 )
 
 var (
@@ -18,19 +22,77 @@ var (
 	PaymentProcessor   = "dwolla"
 	BOAInstitutionId   = "ins_1"
 	ChaseInstitutionId = "ins_56"
+	CategoryIconUrl    = "https://plaid-category-icons.plaid.com/PFC_%s.png"
+	DefaultCategory    = "GENERAL_MERCHANDISE"
 )
 
+const (
+	TransferInCategory  = "TRANSFER_IN"
+	TransferOutCategory = "TRANSFER_OUT"
+)
+
+// Primary personal finance categories from Plaid's taxonomy. Internal
+// transfers use the TRANSFER_IN / TRANSFER_OUT pair so they line up with
+// Plaid-synced transfers.
+var PersonalFinanceCategories = []string{
+	"INCOME",
+	"TRANSFER_IN",
+	"TRANSFER_OUT",
+	"LOAN_PAYMENTS",
+	"BANK_FEES",
+	"ENTERTAINMENT",
+	"FOOD_AND_DRINK",
+	"GENERAL_MERCHANDISE",
+	"HOME_IMPROVEMENT",
+	"MEDICAL",
+	"PERSONAL_CARE",
+	"GENERAL_SERVICES",
+	"GOVERNMENT_AND_NON_PROFIT",
+	"TRANSPORTATION",
+	"TRAVEL",
+	"RENT_AND_UTILITIES",
+}
+
 type PlaidTransaction struct {
-	Id             string `json:"id"`
-	Name           string `json:"name"`
-	PaymentChannel string `json:"paymentChannel"`
-	Type           string `json:"type"`
-	AccountId      string `json:"accountId"`
-	Amount         string `json:"amount"`
-	Pending        string `json:"pending"`
-	Category       string `json:"category"`
-	Date           string `json:"date"`
-	Image          string `json:"image"`
+	Id                 string                    `json:"id"`
+	Name               string                    `json:"name"`
+	PaymentChannel     string                    `json:"paymentChannel"`
+	Type               string                    `json:"type"`
+	AccountId          string                    `json:"accountId"`
+	Amount             string                    `json:"amount"`
+	Pending            string                    `json:"pending"`
+	Category           string                    `json:"category"`
+	CategoryDetailed   string                    `json:"categoryDetailed"`
+	CategoryOverridden bool                      `json:"categoryOverridden"`
+	Date               string                    `json:"date"`
+	Image              string                    `json:"image"`
+	MerchantName       string                    `json:"merchantName"`
+	LogoUrl            string                    `json:"logoUrl"`
+	Website            string                    `json:"website"`
+	Counterparties     []TransactionCounterparty `json:"counterparties"`
+}
+
+type TransactionCounterparty struct {
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	LogoUrl         string `json:"logo_url"`
+	Website         string `json:"website"`
+	EntityId        string `json:"entity_id"`
+	ConfidenceLevel string `json:"confidence_level"`
+}
+
+// transactionEnrichment holds the enrichment fields that the pinned plaid-go
+// models do not expose yet; they are decoded from the raw sync response.
+type transactionEnrichment struct {
+	TransactionId  string                    `json:"transaction_id"`
+	LogoUrl        string                    `json:"logo_url"`
+	Website        string                    `json:"website"`
+	Counterparties []TransactionCounterparty `json:"counterparties"`
+}
+
+type transactionsSyncEnrichment struct {
+	Added    []transactionEnrichment `json:"added"`
+	Modified []transactionEnrichment `json:"modified"`
 }
 
 type GetInstitutionReq struct {
@@ -48,7 +110,7 @@ func CreatePlaidConfig() {
 
 }
 
-func CreatePlaidLinkToken(plaidUser User) (string, error) {
+func CreatePlaidLinkToken(plaidUser User, purpose string) (string, error) {
 	ctx := context.Background()
 	user := plaid.LinkTokenCreateRequestUser{
 		ClientUserId: plaidUser.UserId,
@@ -59,15 +121,14 @@ func CreatePlaidLinkToken(plaidUser User) (string, error) {
 		[]plaid.CountryCode{plaid.COUNTRYCODE_US},
 		user,
 	)
-	request.SetProducts([]plaid.Products{plaid.PRODUCTS_AUTH, plaid.PRODUCTS_TRANSACTIONS, plaid.PRODUCTS_IDENTITY})
+	productSet := LinkProductSets[purpose]
+	request.SetProducts(productSet.Products)
 	request.SetLinkCustomizationName("default")
-	// request.SetWebhook("https://webhook-uri.com")
+	if len(PlaidWebhookUrl) > 0 {
+		request.SetWebhook(PlaidWebhookUrl)
+	}
 	// request.SetRedirectUri("https://domainname.com/oauth-page.html")
-	request.SetAccountFilters(plaid.LinkTokenAccountFilters{
-		Depository: &plaid.DepositoryFilter{
-			AccountSubtypes: []plaid.AccountSubtype{plaid.ACCOUNTSUBTYPE_CHECKING, plaid.ACCOUNTSUBTYPE_SAVINGS},
-		},
-	})
+	request.SetAccountFilters(productSet.AccountFilters)
 	resp, _, err := PlaidAPIClient.PlaidApi.LinkTokenCreate(ctx).LinkTokenCreateRequest(*request).Execute()
 	if err != nil {
 		log.Println(err.Error())
@@ -79,7 +140,7 @@ func CreatePlaidLinkToken(plaidUser User) (string, error) {
 
 func ExchangePublicTokenSandBoxMethod(plaidUser User) (string, error) {
 	ctx := context.Background()
-	testProducts := []plaid.Products{plaid.PRODUCTS_AUTH, plaid.PRODUCTS_TRANSACTIONS, plaid.PRODUCTS_IDENTITY}
+	testProducts := LinkProductSets[LinkPurposeFunding].Products
 	sandboxPublicTokenResp, _, err := PlaidAPIClient.PlaidApi.SandboxPublicTokenCreate(ctx).SandboxPublicTokenCreateRequest(
 		*plaid.NewSandboxPublicTokenCreateRequest(
 			SandboxInstitution,
@@ -111,6 +172,126 @@ func ExchangePublicToken(publicToken string) (string, string, error) {
 
 }
 
+// GetIdentity returns the account holders the institution reports for one
+// account of the item.
+func GetIdentity(accessToken string, accountId string) ([]plaid.Owner, error) {
+	ctx := context.Background()
+	options := plaid.NewIdentityGetRequestOptions()
+	options.SetAccountIds([]string{accountId})
+	request := plaid.NewIdentityGetRequest(accessToken)
+	request.SetOptions(*options)
+	identityResp, _, err := PlaidAPIClient.PlaidApi.IdentityGet(ctx).IdentityGetRequest(*request).Execute()
+	if err != nil {
+		log.Println(err.Error())
+		return nil, fmt.Errorf("error while getting identity: %v", err.Error())
+	}
+	var owners []plaid.Owner
+	for _, account := range identityResp.GetAccounts() {
+		if account.GetAccountId() == accountId {
+			owners = append(owners, account.GetOwners()...)
+		}
+	}
+	return owners, nil
+}
+
+// GetAuthNumbers returns the ACH account and routing numbers for one account
+// of the item. Callers must not log or store the result.
+func GetAuthNumbers(accessToken string, accountId string) (plaid.NumbersACH, error) {
+	ctx := context.Background()
+	options := plaid.NewAuthGetRequestOptions()
+	options.SetAccountIds([]string{accountId})
+	request := plaid.NewAuthGetRequest(accessToken)
+	request.SetOptions(*options)
+	authResp, _, err := PlaidAPIClient.PlaidApi.AuthGet(ctx).AuthGetRequest(*request).Execute()
+	if err != nil {
+		log.Println(err.Error())
+		return plaid.NumbersACH{}, fmt.Errorf("error while getting auth numbers: %v", err.Error())
+	}
+	numbers := authResp.GetNumbers()
+	for _, ach := range numbers.GetAch() {
+		if ach.GetAccountId() == accountId {
+			return ach, nil
+		}
+	}
+	return plaid.NumbersACH{}, fmt.Errorf("no ach numbers for account")
+}
+
+// RemoveItem revokes the access token so a refused link leaves nothing
+// behind at Plaid.
+func RemoveItem(accessToken string) error {
+	ctx := context.Background()
+	_, _, err := PlaidAPIClient.PlaidApi.ItemRemove(ctx).ItemRemoveRequest(*plaid.NewItemRemoveRequest(accessToken)).Execute()
+	if err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while removing item: %v", err.Error())
+	}
+	return nil
+}
+
+// GetWebhookVerificationKey fetches the public key Plaid signed a webhook
+// with.
+func GetWebhookVerificationKey(keyId string) (plaid.JWKPublicKey, error) {
+	ctx := context.Background()
+	keyResp, _, err := PlaidAPIClient.PlaidApi.WebhookVerificationKeyGet(ctx).WebhookVerificationKeyGetRequest(
+		*plaid.NewWebhookVerificationKeyGetRequest(keyId),
+	).Execute()
+	if err != nil {
+		log.Println(err.Error())
+		return plaid.JWKPublicKey{}, fmt.Errorf("error while getting webhook verification key: %v", err.Error())
+	}
+	return keyResp.GetKey(), nil
+}
+
+func GetLiabilitiesFromPlaid(accessToken string) (plaid.LiabilitiesGetResponse, error) {
+	ctx := context.Background()
+	liabilitiesResp, _, err := PlaidAPIClient.PlaidApi.LiabilitiesGet(ctx).LiabilitiesGetRequest(
+		*plaid.NewLiabilitiesGetRequest(accessToken),
+	).Execute()
+	if err != nil {
+		log.Println(err.Error())
+		return plaid.LiabilitiesGetResponse{}, fmt.Errorf("error while getting liabilities: %v", err.Error())
+	}
+	return liabilitiesResp, nil
+}
+
+func GetInvestmentHoldingsFromPlaid(accessToken string) (plaid.InvestmentsHoldingsGetResponse, error) {
+	ctx := context.Background()
+	holdingsResp, _, err := PlaidAPIClient.PlaidApi.InvestmentsHoldingsGet(ctx).InvestmentsHoldingsGetRequest(
+		*plaid.NewInvestmentsHoldingsGetRequest(accessToken),
+	).Execute()
+	if err != nil {
+		log.Println(err.Error())
+		return plaid.InvestmentsHoldingsGetResponse{}, fmt.Errorf("error while getting investment holdings: %v", err.Error())
+	}
+	return holdingsResp, nil
+}
+
+// GetInvestmentTransactionsFromPlaid pages through the investment transactions of an
+// item between two dates and returns them with the securities they refer to.
+func GetInvestmentTransactionsFromPlaid(accessToken string, startDate string, endDate string) ([]plaid.InvestmentTransaction, []plaid.Security, error) {
+	ctx := context.Background()
+	var transactions []plaid.InvestmentTransaction
+	var securities []plaid.Security
+	for {
+		options := plaid.NewInvestmentsTransactionsGetRequestOptions()
+		options.SetCount(500)
+		options.SetOffset(int32(len(transactions)))
+		request := plaid.NewInvestmentsTransactionsGetRequest(accessToken, startDate, endDate)
+		request.SetOptions(*options)
+		transactionsResp, _, err := PlaidAPIClient.PlaidApi.InvestmentsTransactionsGet(ctx).InvestmentsTransactionsGetRequest(*request).Execute()
+		if err != nil {
+			log.Println(err.Error())
+			return nil, nil, fmt.Errorf("error while getting investment transactions: %v", err.Error())
+		}
+		page := transactionsResp.GetInvestmentTransactions()
+		transactions = append(transactions, page...)
+		securities = append(securities, transactionsResp.GetSecurities()...)
+		if len(page) == 0 || len(transactions) >= int(transactionsResp.GetTotalInvestmentTransactions()) {
+			return transactions, securities, nil
+		}
+	}
+}
+
 func GetAccounts(accessToken string) (plaid.AccountBase, plaid.Item, error) {
 	ctx := context.Background()
 	accountsGetResp, _, err := PlaidAPIClient.PlaidApi.AccountsGet(ctx).AccountsGetRequest(
@@ -131,6 +312,25 @@ func GetAccounts(accessToken string) (plaid.AccountBase, plaid.Item, error) {
 	return accountData, accountItem, nil
 }
 
+// GetAccount returns the cached data of one account of the item. Unlike
+// GetAccounts it does not fall back to the item's first account.
+func GetAccount(accessToken string, accountId string) (plaid.AccountBase, error) {
+	ctx := context.Background()
+	accountsReq := plaid.NewAccountsGetRequest(accessToken)
+	accountsReq.SetOptions(plaid.AccountsGetRequestOptions{AccountIds: &[]string{accountId}})
+	accountsGetResp, _, err := PlaidAPIClient.PlaidApi.AccountsGet(ctx).AccountsGetRequest(*accountsReq).Execute()
+	if err != nil {
+		log.Println(err.Error())
+		return plaid.AccountBase{}, fmt.Errorf("error while getting account info: %v", err.Error())
+	}
+	for _, account := range accountsGetResp.GetAccounts() {
+		if account.GetAccountId() == accountId {
+			return account, nil
+		}
+	}
+	return plaid.AccountBase{}, fmt.Errorf("account %s was not returned by plaid", accountId)
+}
+
 func CreataDwollaAccount(accessToken string, accountID string) (string, error) {
 	ctx := context.Background()
 	processorTokenCreateResp, _, err := PlaidAPIClient.PlaidApi.ProcessorTokenCreate(ctx).ProcessorTokenCreateRequest(
@@ -155,6 +355,16 @@ func GetAccountInstituionId(institutionId string) (string, error) {
 	return instId, nil
 }
 
+func GetInstitutionName(institutionId string) (string, error) {
+	ctx := context.Background()
+	requestPayload := PlaidAPIClient.PlaidApi.InstitutionsGetById(ctx).InstitutionsGetByIdRequest(plaid.InstitutionsGetByIdRequest{InstitutionId: institutionId, CountryCodes: []plaid.CountryCode{plaid.COUNTRYCODE_US}})
+	institutionResponse, _, err := PlaidAPIClient.PlaidApi.InstitutionsGetByIdExecute(requestPayload)
+	if err != nil {
+		return "", fmt.Errorf("error while getting institution: %v", err.Error())
+	}
+	return institutionResponse.Institution.Name, nil
+}
+
 func GetDefaultInstitutionId(accountItem plaid.Item) (string, error) {
 	instId := accountItem.GetInstitutionId()
 	fmt.Println("Account Item: ", accountItem)
@@ -180,43 +390,216 @@ func GetDefaultInstitutionId(accountItem plaid.Item) (string, error) {
 	return institutionId, nil
 }
 
-func GetTransactionsFromPlaid(accessToken string) ([]PlaidTransaction, error) {
+func GetCategoryIcon(category string) string {
+	if len(category) == 0 {
+		category = DefaultCategory
+	}
+	return fmt.Sprintf(CategoryIconUrl, category)
+}
+
+func IsValidCategory(category string) bool {
+	for _, eachCategory := range PersonalFinanceCategories {
+		if eachCategory == category {
+			return true
+		}
+	}
+	return false
+}
 
-	var plaidTransactions []PlaidTransaction
+func decodeTransactionEnrichment(httpResp *http.Response) map[string]transactionEnrichment {
+	enrichment := make(map[string]transactionEnrichment)
+	if httpResp == nil || httpResp.Body == nil {
+		return enrichment
+	}
+	var syncEnrichment transactionsSyncEnrichment
+	if err := json.NewDecoder(httpResp.Body).Decode(&syncEnrichment); err != nil {
+		log.Println("unable to decode transaction enrichment: " + err.Error())
+		return enrichment
+	}
+	for _, eachTransaction := range append(syncEnrichment.Added, syncEnrichment.Modified...) {
+		enrichment[eachTransaction.TransactionId] = eachTransaction
+	}
+	return enrichment
+}
 
+func ConvertToSyncedTransaction(plaidUser db.PlaidUser, transaction plaid.Transaction, enrichment transactionEnrichment) db.SyncedTransaction {
+	syncedTransaction := db.SyncedTransaction{
+		TransactionId:  transaction.TransactionId,
+		AccountId:      transaction.AccountId,
+		TrackId:        plaidUser.TrackId,
+		UserId:         plaidUser.UserId,
+		Name:           transaction.Name,
+		Amount:         float64(transaction.Amount),
+		Date:           transaction.GetDate(),
+		PaymentChannel: transaction.PaymentChannel,
+		Pending:        transaction.Pending,
+		MerchantName:   transaction.GetMerchantName(),
+		LogoUrl:        enrichment.LogoUrl,
+		Website:        enrichment.Website,
+		Counterparties: "[]",
+	}
+	if category, ok := transaction.GetPersonalFinanceCategoryOk(); ok && category != nil {
+		syncedTransaction.CategoryPrimary = category.Primary
+		syncedTransaction.CategoryDetailed = category.Detailed
+	} else if len(transaction.Category) > 0 {
+		syncedTransaction.CategoryPrimary = strings.ToUpper(strings.ReplaceAll(transaction.Category[0], " ", "_"))
+	}
+	if len(enrichment.Counterparties) > 0 {
+		counterparties, err := json.Marshal(enrichment.Counterparties)
+		if err != nil {
+			log.Println("unable to encode counterparties: " + err.Error())
+		} else {
+			syncedTransaction.Counterparties = string(counterparties)
+		}
+	}
+	return syncedTransaction
+}
+
+func SyncTransactions(bankdb *gorm.DB, plaidUser db.PlaidUser) error {
 	ctx := context.Background()
+	cursor := plaidUser.SyncCursor
+	hasMore := true
+
+	for hasMore {
+		transactionsSyncReq := plaid.NewTransactionsSyncRequest(plaidUser.AccessToken)
+		if len(cursor) > 0 {
+			transactionsSyncReq.SetCursor(cursor)
+		}
 
-	transactionsSyncReq := plaid.NewTransactionsSyncRequest(accessToken)
+		apiTransactionReq := PlaidAPIClient.PlaidApi.TransactionsSync(ctx).TransactionsSyncRequest(*transactionsSyncReq)
 
-	apiTransactionReq := PlaidAPIClient.PlaidApi.TransactionsSync(ctx).TransactionsSyncRequest(*transactionsSyncReq)
+		response, httpResp, err := apiTransactionReq.Execute()
+		if err != nil {
+			return fmt.Errorf("error while executing transactions sync request: %v", err.Error())
+		}
+		enrichment := decodeTransactionEnrichment(httpResp)
+
+		var syncedTransactions []db.SyncedTransaction
+		for _, transaction := range append(response.GetAdded(), response.GetModified()...) {
+			syncedTransactions = append(syncedTransactions, ConvertToSyncedTransaction(plaidUser, transaction, enrichment[transaction.TransactionId]))
+		}
+		if err := db.UpsertSyncedTransactions(bankdb, syncedTransactions); err != nil {
+			return err
+		}
+
+		var removedIds []string
+		for _, removed := range response.GetRemoved() {
+			removedIds = append(removedIds, removed.GetTransactionId())
+		}
+		if err := db.DeleteSyncedTransactions(bankdb, removedIds); err != nil {
+			return err
+		}
+
+		cursor = response.GetNextCursor()
+		hasMore = response.GetHasMore()
+	}
 
-	response, _, err := apiTransactionReq.Execute()
+	if err := db.UpdateSyncCursor(bankdb, plaidUser.TrackId, cursor); err != nil {
+		return err
+	}
+
+	if err := EvaluateBudgets(bankdb, plaidUser.UserId); err != nil {
+		log.Println("unable to evaluate budgets: " + err.Error())
+	}
+	return nil
+}
+
+func ConvertToPlaidTransaction(transaction db.SyncedTransaction, overrides map[string]string) PlaidTransaction {
+	plaidTransaction := PlaidTransaction{
+		Id:               transaction.TransactionId,
+		Name:             transaction.Name,
+		PaymentChannel:   transaction.PaymentChannel,
+		Type:             transaction.PaymentChannel,
+		AccountId:        transaction.AccountId,
+		Amount:           strconv.FormatFloat(transaction.Amount, 'f', -1, 64),
+		Pending:          strconv.FormatBool(transaction.Pending),
+		Category:         transaction.CategoryPrimary,
+		CategoryDetailed: transaction.CategoryDetailed,
+		Date:             transaction.Date,
+		MerchantName:     transaction.MerchantName,
+		LogoUrl:          transaction.LogoUrl,
+		Website:          transaction.Website,
+		Counterparties:   []TransactionCounterparty{},
+	}
+	if category, ok := overrides[transaction.TransactionId]; ok {
+		plaidTransaction.Category = category
+		plaidTransaction.CategoryDetailed = ""
+		plaidTransaction.CategoryOverridden = true
+	}
+	plaidTransaction.Image = GetCategoryIcon(plaidTransaction.Category)
+	if len(transaction.Counterparties) > 0 {
+		if err := json.Unmarshal([]byte(transaction.Counterparties), &plaidTransaction.Counterparties); err != nil {
+			log.Println("unable to decode counterparties: " + err.Error())
+		}
+	}
+	return plaidTransaction
+}
+
+func GetTransactionsFromPlaid(bankdb *gorm.DB, plaidUser db.PlaidUser) ([]PlaidTransaction, error) {
+
+	var plaidTransactions []PlaidTransaction
+
+	if err := SyncTransactions(bankdb, plaidUser); err != nil {
+		return []PlaidTransaction{}, err
+	}
+
+	syncedTransactions, err := db.GetSyncedTransactionsUsingAccountId(bankdb, plaidUser.AccountId)
 	if err != nil {
-		return []PlaidTransaction{}, fmt.Errorf("error while executing transactions sync request: %v", err.Error())
+		return []PlaidTransaction{}, fmt.Errorf("error while fetching synced transactions: %v", err.Error())
 	}
 
-	data := response.GetAdded()
+	overrides, err := db.GetCategoryOverridesUsingUserId(bankdb, plaidUser.UserId)
+	if err != nil {
+		return []PlaidTransaction{}, fmt.Errorf("error while fetching category overrides: %v", err.Error())
+	}
 
-	for _, transaction := range data {
-		plaidTransactions = append(plaidTransactions, PlaidTransaction{
-			Id:             transaction.TransactionId,
-			Name:           transaction.Name,
-			PaymentChannel: transaction.PaymentChannel,
-			Type:           transaction.PaymentChannel,
-			AccountId:      transaction.AccountId,
-			Amount:         strconv.FormatFloat(float64(transaction.Amount), 'f', -1, 32),
-			Pending:        strconv.FormatBool(transaction.Pending),
-			Category: func() string {
-				if len(transaction.Category) > 0 {
-					return transaction.Category[0]
-				}
-				return ""
-			}(),
-			Date:  transaction.GetDate(),
-			Image: "https://plaid-category-icons.plaid.com/PFC_GENERAL_MERCHANDISE.png",
-		})
+	for _, transaction := range syncedTransactions {
+		plaidTransactions = append(plaidTransactions, ConvertToPlaidTransaction(transaction, overrides))
 	}
 
 	return plaidTransactions, nil
 
 }
+
+func GetRecurringTransactions(accessToken string, accountId string) ([]plaid.TransactionStream, []plaid.TransactionStream, error) {
+	ctx := context.Background()
+	recurringResp, _, err := PlaidAPIClient.PlaidApi.TransactionsRecurringGet(ctx).TransactionsRecurringGetRequest(
+		*plaid.NewTransactionsRecurringGetRequest(accessToken, []string{accountId}),
+	).Execute()
+	if err != nil {
+		log.Println(err.Error())
+		return nil, nil, fmt.Errorf("error while getting recurring transactions: %v", err.Error())
+	}
+	return recurringResp.GetInflowStreams(), recurringResp.GetOutflowStreams(), nil
+}
+
+// GetAvailableBalance returns the available balance of the account, falling
+// back to the current balance when the institution does not report one. It
+// asks /accounts/balance/get for a live balance and uses the cached balance
+// from AccountsGet when the live call fails.
+func GetAvailableBalance(accessToken string, accountId string) (float64, bool, error) {
+	ctx := context.Background()
+	balanceReq := plaid.NewAccountsBalanceGetRequest(accessToken)
+	balanceReq.SetOptions(plaid.AccountsBalanceGetRequestOptions{AccountIds: &[]string{accountId}})
+
+	var accountData plaid.AccountBase
+	live := true
+	balanceResp, _, err := PlaidAPIClient.PlaidApi.AccountsBalanceGet(ctx).AccountsBalanceGetRequest(*balanceReq).Execute()
+	if err == nil && len(balanceResp.GetAccounts()) > 0 {
+		accountData = balanceResp.GetAccounts()[0]
+	} else {
+		if err != nil {
+			log.Println("live balance unavailable, using cached balance: " + err.Error())
+		}
+		live = false
+		accountData, err = GetAccount(accessToken, accountId)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	if available, ok := accountData.Balances.GetAvailableOk(); ok && available != nil {
+		return float64(*available), live, nil
+	}
+	return float64(accountData.Balances.GetCurrent()), live, nil
+}
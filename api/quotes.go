@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+// TransferQuoteTTL is how long a user has to confirm a reviewed transfer.
+var TransferQuoteTTL = 5 * time.Minute
+
+const (
+	QuoteOpen       = "open"
+	QuoteConfirming = "confirming"
+	QuoteConfirmed  = "confirmed"
+	QuoteHeld       = "held"
+	QuoteFailed     = "failed"
+
+	RejectQuoteChanged = "QUOTE_CHANGED"
+)
+
+type TransferQuoteRequest struct {
+	UserId        string            `json:"userId" binding:"required"`
+	Name          string            `json:"name"`
+	Email         string            `json:"email"`
+	Amount        string            `json:"amount" binding:"required"`
+	SenderBank    string            `json:"senderBank" binding:"required"`
+	ShareableId   string            `json:"sharableId"`
+	PayeeId       string            `json:"payeeId"`
+	Clearing      string            `json:"clearing"`
+	Metadata      map[string]string `json:"metadata"`
+	CorrelationId string            `json:"correlationId"`
+}
+
+type QuoteConfirmRequest struct {
+	UserId  string `json:"userId" binding:"required"`
+	QuoteId string `json:"quoteId" binding:"required"`
+}
+
+// ReceiverDisplay returns the receiver's name as "First L." and the last four
+// digits of the receiving account, for the review screen.
+func ReceiverDisplay(bankdb *gorm.DB, receiverBank db.PlaidUser) (string, string) {
+	var name string
+	if customer, err := db.GetDwollaCustomerUsingUserId(bankdb, receiverBank.UserId); err == nil {
+		name = customer.FirstName
+		if len(customer.LastName) > 0 {
+			name += " " + strings.ToUpper(customer.LastName[:1]) + "."
+		}
+	}
+
+	mask := receiverBank.AccountMask
+	if len(mask) == 0 && !IsManualBank(receiverBank) {
+		if accountData, _, err := GetAccounts(receiverBank.AccessToken); err == nil {
+			mask = accountData.GetMask()
+		}
+	}
+	if len(mask) > 0 {
+		mask = "****" + mask
+	}
+	return name, mask
+}
+
+// CompareWithQuote reports what changed between a reviewed quote and the
+// transfer about to run.
+func CompareWithQuote(quote db.TransferQuote, receiverBank db.PlaidUser, feeQuote FeeQuote) []TransferRejection {
+	var rejections []TransferRejection
+	if receiverBank.TrackId != quote.ReceiverTrackId {
+		rejections = append(rejections, TransferRejection{Code: RejectQuoteChanged, Message: "the receiving bank changed since the quote"})
+	}
+	if strconv.FormatFloat(feeQuote.TotalFee, 'f', 2, 64) != quote.FeeAmount {
+		rejections = append(rejections, TransferRejection{Code: RejectQuoteChanged, Message: "fees changed since the quote, request a new quote"})
+	}
+	return rejections
+}
+
+func CreateTransferQuote(c *gin.Context) {
+	var quoteReq TransferQuoteRequest
+	if err := c.ShouldBindJSON(&quoteReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	paymentTransferReq := PaymentTransfer{
+		Name:          quoteReq.Name,
+		Email:         quoteReq.Email,
+		Amount:        quoteReq.Amount,
+		SenderBank:    quoteReq.SenderBank,
+		ShareableId:   quoteReq.ShareableId,
+		PayeeId:       quoteReq.PayeeId,
+		Clearing:      quoteReq.Clearing,
+		Metadata:      quoteReq.Metadata,
+		CorrelationId: quoteReq.CorrelationId,
+	}
+	if len(paymentTransferReq.Clearing) == 0 {
+		paymentTransferReq.Clearing = ClearingStandard
+	}
+
+	if len(paymentTransferReq.ShareableId) == 0 && len(paymentTransferReq.PayeeId) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - sharableId or payeeId is required"})
+		return
+	}
+	if err := ApplyPayee(PgDb, &paymentTransferReq, time.Now()); err != nil {
+		log.Println(err.Error())
+		var rejectedErr *TransferRejectedError
+		if errors.As(err, &rejectedErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "reasons": rejectedErr.Rejections})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	senderBank, receiverBank, err := ResolveTransferBanks(PgDb, paymentTransferReq)
+	if err != nil || senderBank.UserId != quoteReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown sender bank or receiver"})
+		return
+	}
+
+	_, feeQuote, err := CheckTransfer(PgDb, paymentTransferReq, senderBank, receiverBank)
+	if err != nil {
+		log.Println(err.Error())
+		var rejectedErr *TransferRejectedError
+		if errors.As(err, &rejectedErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "reasons": rejectedErr.Rejections})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	receiverName, receiverMask := ReceiverDisplay(PgDb, receiverBank)
+	now := time.Now()
+	quote := db.TransferQuote{
+		QuoteId:             utils.GenerateId("TRQUOTE"),
+		UserId:              quoteReq.UserId,
+		SenderTrackId:       senderBank.TrackId,
+		ReceiverTrackId:     receiverBank.TrackId,
+		ReceiverShareableId: paymentTransferReq.ShareableId,
+		ReceiverName:        receiverName,
+		ReceiverMask:        receiverMask,
+		Name:                quoteReq.Name,
+		Email:               quoteReq.Email,
+		Amount:              quoteReq.Amount,
+		Clearing:            paymentTransferReq.Clearing,
+		CorrelationId:       quoteReq.CorrelationId,
+		FeeAmount:           strconv.FormatFloat(feeQuote.TotalFee, 'f', 2, 64),
+		Total:               strconv.FormatFloat(feeQuote.Total, 'f', 2, 64),
+		Status:              QuoteOpen,
+		ExpiresAt:           now.Add(TransferQuoteTTL),
+		CreatedAt:           now,
+	}
+	if len(quoteReq.Metadata) > 0 {
+		metadata, err := json.Marshal(quoteReq.Metadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metadata: " + err.Error()})
+			return
+		}
+		quote.Metadata = string(metadata)
+	}
+	if err := db.AddTransferQuote(PgDb, quote); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": quote, "fees": feeQuote.Fees})
+}
+
+// ConfirmTransferQuote executes exactly the transfer that was quoted. The
+// amount, banks and options come from the stored quote, never the request.
+func ConfirmTransferQuote(c *gin.Context) {
+	var confirmReq QuoteConfirmRequest
+	if err := c.ShouldBindJSON(&confirmReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	quote, err := db.GetTransferQuoteUsingId(PgDb, confirmReq.QuoteId)
+	if err != nil || quote.UserId != confirmReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "quote not found for user"})
+		return
+	}
+	claimed, err := db.ClaimTransferQuote(PgDb, quote.QuoteId, confirmReq.UserId, QuoteOpen, QuoteConfirming, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !claimed {
+		status := quote.Status
+		if status == QuoteOpen {
+			status = "expired"
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("quote is %s and cannot be confirmed", status), "status": status})
+		return
+	}
+
+	paymentTransferReq := PaymentTransfer{
+		Name:          quote.Name,
+		Email:         quote.Email,
+		Amount:        quote.Amount,
+		SenderBank:    quote.SenderTrackId,
+		ShareableId:   quote.ReceiverShareableId,
+		Clearing:      quote.Clearing,
+		CorrelationId: quote.CorrelationId,
+	}
+	if len(quote.Metadata) > 0 {
+		if err := json.Unmarshal([]byte(quote.Metadata), &paymentTransferReq.Metadata); err != nil {
+			log.Println("unable to decode quote metadata: " + err.Error())
+		}
+	}
+
+	transactionRes, err := executeTransfer(PgDb, paymentTransferReq, transferOptions{quote: &quote})
+	if err != nil {
+		log.Println(err.Error())
+		var heldErr *TransferHeldError
+		if errors.As(err, &heldErr) {
+			if err := db.HoldTransferQuote(PgDb, quote.QuoteId, QuoteHeld, heldErr.DecisionId); err != nil {
+				log.Println(err.Error())
+			}
+			c.JSON(http.StatusAccepted, gin.H{"message": "Transfer Held For Review", "decisionId": heldErr.DecisionId})
+			return
+		}
+		finishQuote(quote.QuoteId, QuoteFailed, "", err.Error())
+		var rejectedErr *TransferRejectedError
+		if errors.As(err, &rejectedErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "reasons": rejectedErr.Rejections})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	finishQuote(quote.QuoteId, QuoteConfirmed, transactionRes.TransactionId, "")
+
+	c.JSON(http.StatusOK, gin.H{"data": transactionRes})
+}
+
+func finishQuote(quoteId string, status string, transactionId string, note string) {
+	if err := db.FinishTransferQuote(PgDb, quoteId, status, transactionId, note); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// settleHeldQuote finishes a quote whose transfer was held for review: it is
+// confirmed with the transaction once the transfer is approved and sent, and
+// fails when the review rejects it or the transfer fails.
+func settleHeldQuote(decisionId string, transactionId string, failureReason string) {
+	quote, err := db.GetTransferQuoteUsingDecisionId(PgDb, decisionId)
+	if err != nil || quote.Status != QuoteHeld {
+		return
+	}
+	if len(transactionId) == 0 {
+		finishQuote(quote.QuoteId, QuoteFailed, "", failureReason)
+		return
+	}
+	finishQuote(quote.QuoteId, QuoteConfirmed, transactionId, "")
+}
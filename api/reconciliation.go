@@ -0,0 +1,340 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ReconciliationInterval   = 6 * time.Hour
+	ReconciliationWindowDays = 7
+	ReconciliationAutoRepair = false
+	ReconciliationLease      = "reconciliation"
+)
+
+const (
+	ReconcileMissing    = "missing"
+	ReconcileOrphaned   = "orphaned"
+	ReconcileMismatched = "status_mismatch"
+	// ReconcileUntracked marks rows from before Dwolla transfer ids were
+	// stored; they cannot be checked and are not counted as orphaned.
+	ReconcileUntracked = "untracked"
+
+	TransferOrphaned = "orphaned"
+)
+
+type ReconciliationRequest struct {
+	StartDate  string `json:"startDate" binding:"required"`
+	EndDate    string `json:"endDate" binding:"required"`
+	AutoRepair bool   `json:"autoRepair"`
+}
+
+// Reconcile compares the Dwolla transfers of every linked funding source with
+// the transactions created in the window and reports transfers missing
+// locally, local rows without a Dwolla transfer and status mismatches. With
+// autoRepair the local side is brought in line with Dwolla.
+func Reconcile(bankdb *gorm.DB, start time.Time, end time.Time, autoRepair bool) (db.ReconciliationReport, []db.ReconciliationItem, error) {
+	ctx := context.Background()
+	report := db.ReconciliationReport{
+		ReportId:   utils.GenerateId("RECONCL"),
+		StartDate:  start.Format("2006-01-02"),
+		EndDate:    end.Format("2006-01-02"),
+		AutoRepair: autoRepair,
+		CreatedAt:  time.Now(),
+	}
+
+	records, err := db.GetAllRecords(bankdb)
+	if err != nil {
+		return db.ReconciliationReport{}, nil, fmt.Errorf("unable to fetch accounts - %v", err.Error())
+	}
+	banksByFundingSource := make(map[string]db.PlaidUser)
+	customerUrls := make(map[string]bool)
+	for _, record := range records {
+		if !IsFundingBank(record) {
+			continue
+		}
+		banksByFundingSource[record.FundingSourceId] = record
+		customerUrl, err := RetrieveFundingSourceCustomerUrl(ctx, record.FundingSourceUrl)
+		if err != nil {
+			log.Println("skipping funding source of " + record.TrackId + ": " + err.Error())
+			continue
+		}
+		customerUrls[customerUrl] = true
+	}
+
+	dwollaTransfers := make(map[string]DwollaTransfer)
+	var transferIds []string
+	for customerUrl := range customerUrls {
+		transfers, err := ListCustomerTransfers(ctx, customerUrl, start, end)
+		if err != nil {
+			return db.ReconciliationReport{}, nil, err
+		}
+		for _, transfer := range transfers {
+			_, knownSource := banksByFundingSource[DwollaResourceId(transfer.Links["source-funding-source"].Href)]
+			_, knownDestination := banksByFundingSource[DwollaResourceId(transfer.Links["destination-funding-source"].Href)]
+			if _, seen := dwollaTransfers[transfer.Id]; seen || !(knownSource || knownDestination) {
+				continue
+			}
+			dwollaTransfers[transfer.Id] = transfer
+			transferIds = append(transferIds, transfer.Id)
+		}
+	}
+
+	localTransactions, err := db.GetTransactionsCreatedBetween(bankdb, start, end.AddDate(0, 0, 1))
+	if err != nil {
+		return db.ReconciliationReport{}, nil, fmt.Errorf("unable to fetch transactions - %v", err.Error())
+	}
+
+	var items []db.ReconciliationItem
+	addItem := func(item db.ReconciliationItem) {
+		item.ItemId = fmt.Sprintf("%s-%d", report.ReportId, len(items)+1)
+		item.ReportId = report.ReportId
+		switch item.Kind {
+		case ReconcileMissing:
+			report.Missing++
+		case ReconcileOrphaned:
+			report.Orphaned++
+		case ReconcileMismatched:
+			report.Mismatched++
+		case ReconcileUntracked:
+			report.Untracked++
+		}
+		if item.Repaired {
+			report.Repaired++
+		}
+		items = append(items, item)
+	}
+
+	matched := make(map[string]bool)
+	for _, transferId := range transferIds {
+		transfer := dwollaTransfers[transferId]
+		transaction, err := db.GetTransactionUsingTransferId(bankdb, transfer.Id)
+		if err != nil {
+			item := db.ReconciliationItem{Kind: ReconcileMissing, TransferId: transfer.Id, DwollaStatus: transfer.Status, Amount: transfer.Amount.Value}
+			if autoRepair {
+				item.TransactionId, item.Note = repairMissingTransaction(bankdb, transfer, banksByFundingSource)
+				item.Repaired = len(item.TransactionId) > 0
+			}
+			addItem(item)
+			continue
+		}
+		matched[transaction.TransactionId] = true
+		if transaction.Status == transfer.Status {
+			report.Matched++
+			recordActualClearing(bankdb, transaction, transfer, autoRepair)
+			continue
+		}
+		addItem(reconcileStatus(bankdb, transaction, transfer, autoRepair))
+	}
+
+	for _, transaction := range localTransactions {
+		if matched[transaction.TransactionId] || transaction.Status == TransferOrphaned {
+			continue
+		}
+		item := db.ReconciliationItem{Kind: ReconcileOrphaned, TransferId: transaction.DwollaTransferId, TransactionId: transaction.TransactionId, LocalStatus: transaction.Status, Amount: transaction.Amount}
+		if len(transaction.DwollaTransferId) == 0 {
+			item.Kind, item.Note = ReconcileUntracked, "no dwolla transfer id recorded"
+			addItem(item)
+			continue
+		}
+		// The transfer may just fall outside the listing window, so look it up
+		// directly before calling it orphaned.
+		transfer, err := RetrieveTransfer(ctx, transaction.DwollaTransferId)
+		if err == nil {
+			if transaction.Status == transfer.Status {
+				report.Matched++
+				recordActualClearing(bankdb, transaction, transfer, autoRepair)
+				continue
+			}
+			addItem(reconcileStatus(bankdb, transaction, transfer, autoRepair))
+			continue
+		}
+		item.Note = err.Error()
+		// Dwolla has no such transfer, so no money moved: the row is marked
+		// orphaned and its journal reversed together.
+		if autoRepair && errors.Is(err, ErrDwollaNotFound) {
+			if err := ApplyTransferStatus(bankdb, transaction, TransferOrphaned, "dwolla has no record of the transfer"); err != nil {
+				item.Note = err.Error()
+			} else {
+				item.Repaired = true
+				item.Note = "marked orphaned and reversed in the ledger; review manually"
+			}
+		}
+		addItem(item)
+	}
+
+	if err := db.AddReconciliationReport(bankdb, report, items); err != nil {
+		return db.ReconciliationReport{}, nil, err
+	}
+	return report, items, nil
+}
+
+func reconcileStatus(bankdb *gorm.DB, transaction db.Transaction, transfer DwollaTransfer, autoRepair bool) db.ReconciliationItem {
+	item := db.ReconciliationItem{
+		Kind:          ReconcileMismatched,
+		TransferId:    transfer.Id,
+		TransactionId: transaction.TransactionId,
+		LocalStatus:   transaction.Status,
+		DwollaStatus:  transfer.Status,
+		Amount:        transaction.Amount,
+	}
+	if !autoRepair {
+		return item
+	}
+	transaction.ActualClearing = ActualClearing(transfer.Clearing, transfer.ProcessingChannel)
+	if err := ApplyTransferStatus(bankdb, transaction, transfer.Status, TransferFailureReason(context.Background(), transfer)); err != nil {
+		item.Note = err.Error()
+		return item
+	}
+	item.Repaired = true
+	return item
+}
+
+// recordActualClearing fills in the clearing of a matched transfer that was
+// created before Dwolla reported one.
+func recordActualClearing(bankdb *gorm.DB, transaction db.Transaction, transfer DwollaTransfer, autoRepair bool) {
+	if !autoRepair || len(transaction.ActualClearing) > 0 {
+		return
+	}
+	transaction.ActualClearing = ActualClearing(transfer.Clearing, transfer.ProcessingChannel)
+	if err := db.UpdateTransferStatus(bankdb, transaction); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// repairMissingTransaction records a Dwolla transfer that has no local row when
+// both of its funding sources belong to linked banks.
+func repairMissingTransaction(bankdb *gorm.DB, transfer DwollaTransfer, banksByFundingSource map[string]db.PlaidUser) (string, string) {
+	senderBank, knownSource := banksByFundingSource[DwollaResourceId(transfer.Links["source-funding-source"].Href)]
+	receiverBank, knownDestination := banksByFundingSource[DwollaResourceId(transfer.Links["destination-funding-source"].Href)]
+	if !knownSource || !knownDestination {
+		return "", "counterparty funding source is not linked here"
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, transfer.Created)
+	if err != nil {
+		createdAt = time.Now()
+	}
+	transaction := db.Transaction{
+		TransactionId:         utils.GenerateIdAt("TRANSCT", createdAt.Local()),
+		Name:                  "Dwolla transfer " + transfer.Id,
+		Amount:                transfer.Amount.Value,
+		Channel:               "online",
+		Category:              TransferOutCategory,
+		SenderId:              senderBank.UserId,
+		ReceiverId:            receiverBank.UserId,
+		SenderBankId:          senderBank.TrackId,
+		ReceiverBankId:        receiverBank.TrackId,
+		DwollaTransferId:      transfer.Id,
+		DwollaTransferUrl:     DwollaTransferUrl(transfer.Id),
+		SourceFundingSourceId: senderBank.FundingSourceId,
+		DestFundingSourceId:   receiverBank.FundingSourceId,
+		Status:                DwollaTransferPending,
+		ActualClearing:        ActualClearing(transfer.Clearing, transfer.ProcessingChannel),
+		InitiatedAt:           &createdAt,
+		StatusUpdatedAt:       &createdAt,
+	}
+	reason := TransferFailureReason(context.Background(), transfer)
+	err = bankdb.Transaction(func(tx *gorm.DB) error {
+		if err := db.AddTransaction(tx, transaction); err != nil {
+			return err
+		}
+		return ApplyTransferStatus(tx, transaction, transfer.Status, reason)
+	})
+	if err != nil {
+		return "", err.Error()
+	}
+	return transaction.TransactionId, "recorded from dwolla"
+}
+
+func ReconcileTransfers(c *gin.Context) {
+	var reconcileReq ReconciliationRequest
+	if err := c.ShouldBindJSON(&reconcileReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	start, err := time.ParseInLocation("2006-01-02", reconcileReq.StartDate, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start date: " + err.Error()})
+		return
+	}
+	end, err := time.ParseInLocation("2006-01-02", reconcileReq.EndDate, time.Local)
+	if err != nil || end.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end date: " + reconcileReq.EndDate})
+		return
+	}
+
+	report, items, err := Reconcile(PgDb, start, end, reconcileReq.AutoRepair)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report, "items": items})
+}
+
+// StartReconciliationWorker reconciles the trailing window on every tick while
+// this replica holds the reconciliation lease.
+func StartReconciliationWorker(ctx context.Context, bankdb *gorm.DB) {
+	ticker := time.NewTicker(ReconciliationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		isLeader, err := db.AcquireLease(bankdb, ReconciliationLease, WorkerId, 2*ReconciliationInterval)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		if !isLeader {
+			continue
+		}
+		end := time.Now()
+		report, _, err := Reconcile(bankdb, end.AddDate(0, 0, -ReconciliationWindowDays), end, ReconciliationAutoRepair)
+		if err != nil {
+			log.Println("reconciliation failed: " + err.Error())
+			continue
+		}
+		log.Println("Reconciliation Report: ", report)
+	}
+}
+
+// RunReconciliationCommand runs one reconciliation from the command line and
+// prints the report, e.g. `plaid-service reconcile -days 3 -repair`.
+func RunReconciliationCommand(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	days := flags.Int("days", ReconciliationWindowDays, "number of days to reconcile, ending today")
+	repair := flags.Bool("repair", false, "repair local records to match dwolla")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	end := time.Now()
+	report, items, err := Reconcile(PgDb, end.AddDate(0, 0, -*days), end, *repair)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(gin.H{"report": report, "items": items})
+}
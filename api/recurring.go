@@ -0,0 +1,304 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"github.com/plaid/plaid-go/plaid"
+	"gorm.io/gorm"
+)
+
+var (
+	// MinRecurringTransfers is the number of transfers between the same pair
+	// of banks needed before the local detector reports a stream.
+	MinRecurringTransfers = 3
+)
+
+const (
+	RecurringSourcePlaid    = "plaid"
+	RecurringSourceInternal = "internal"
+	RecurringInflow         = "inflow"
+	RecurringOutflow        = "outflow"
+
+	StreamStatusMature         = "MATURE"
+	StreamStatusEarlyDetection = "EARLY_DETECTION"
+	StreamStatusTombstoned     = "TOMBSTONED"
+	StreamStatusUnknown        = "UNKNOWN"
+
+	FrequencyWeekly      = "WEEKLY"
+	FrequencyBiweekly    = "BIWEEKLY"
+	FrequencySemiMonthly = "SEMI_MONTHLY"
+	FrequencyMonthly     = "MONTHLY"
+	FrequencyAnnually    = "ANNUALLY"
+	FrequencyUnknown     = "UNKNOWN"
+)
+
+type RecurringRequest struct {
+	UserId  string `json:"userId" binding:"required"`
+	Refresh bool   `json:"refresh"`
+}
+
+func GetRecurringTransactionStreams(c *gin.Context) {
+	var recurringReq RecurringRequest
+	if err := c.ShouldBindJSON(&recurringReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	if recurringReq.Refresh {
+		if err := RefreshRecurringStreams(PgDb, recurringReq.UserId); err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	streams, err := db.GetRecurringStreamsUsingUserId(PgDb, recurringReq.UserId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to fetch recurring streams - " + err.Error()})
+		return
+	}
+
+	inflowStreams, outflowStreams := []db.RecurringStream{}, []db.RecurringStream{}
+	for _, stream := range streams {
+		if stream.Direction == RecurringInflow {
+			inflowStreams = append(inflowStreams, stream)
+		} else {
+			outflowStreams = append(outflowStreams, stream)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"inflowStreams": inflowStreams, "outflowStreams": outflowStreams})
+}
+
+// RefreshRecurringStreams reloads Plaid's recurring streams for every linked
+// bank of the user and reruns the local detector over internal transfers.
+func RefreshRecurringStreams(bankdb *gorm.DB, userId string) error {
+	plaidDBRecords, err := db.GetAllRecordUsingUserId(bankdb, userId)
+	if err != nil {
+		return fmt.Errorf("unable to fetch accounts - %v", err.Error())
+	}
+
+	for _, eachRecord := range plaidDBRecords {
+		if IsManualBank(eachRecord) || !IsFundingBank(eachRecord) {
+			continue
+		}
+		// One broken item must not keep the others, or the internal
+		// detector, from refreshing.
+		inflowStreams, outflowStreams, err := GetRecurringTransactions(eachRecord.AccessToken, eachRecord.AccountId)
+		if err != nil {
+			log.Println("unable to refresh recurring streams for " + eachRecord.TrackId + ": " + err.Error())
+			continue
+		}
+		var streams []db.RecurringStream
+		for _, stream := range inflowStreams {
+			streams = append(streams, ConvertPlaidStream(eachRecord, stream, RecurringInflow))
+		}
+		for _, stream := range outflowStreams {
+			streams = append(streams, ConvertPlaidStream(eachRecord, stream, RecurringOutflow))
+		}
+		if err := db.ReplaceRecurringStreams(bankdb, userId, RecurringSourcePlaid, eachRecord.TrackId, streams); err != nil {
+			return err
+		}
+	}
+
+	transactions, err := db.GetTransactionsUsingUserId(bankdb, userId)
+	if err != nil {
+		return fmt.Errorf("unable to fetch transfers - %v", err.Error())
+	}
+	return db.ReplaceRecurringStreams(bankdb, userId, RecurringSourceInternal, "", DetectRecurringTransfers(userId, transactions, time.Now()))
+}
+
+func ConvertPlaidStream(plaidUser db.PlaidUser, stream plaid.TransactionStream, direction string) db.RecurringStream {
+	recurringStream := db.RecurringStream{
+		StreamId:    stream.StreamId,
+		UserId:      plaidUser.UserId,
+		TrackId:     plaidUser.TrackId,
+		AccountId:   stream.AccountId,
+		Source:      RecurringSourcePlaid,
+		Direction:   direction,
+		Description: stream.Description,
+		Frequency:   string(stream.Frequency),
+		FirstDate:   stream.FirstDate,
+		LastDate:    stream.LastDate,
+		Status:      StreamStatusUnknown,
+		IsActive:    stream.IsActive,
+		Occurrences: len(stream.TransactionIds),
+		UpdatedAt:   time.Now(),
+	}
+	if stream.AverageAmount.Amount != nil {
+		recurringStream.AverageAmount = roundAmount(math.Abs(float64(*stream.AverageAmount.Amount)))
+	}
+	recurringStream.LastAmount = recurringStream.AverageAmount
+
+	// Newer stream fields are not modelled by the pinned plaid-go release and
+	// arrive in AdditionalProperties.
+	if status, ok := stream.AdditionalProperties["status"].(string); ok {
+		recurringStream.Status = status
+	}
+	if merchantName, ok := stream.AdditionalProperties["merchant_name"].(string); ok {
+		recurringStream.MerchantName = merchantName
+	}
+	if nextDate, ok := stream.AdditionalProperties["predicted_next_date"].(string); ok {
+		recurringStream.NextDate = nextDate
+	}
+	if lastAmount, ok := stream.AdditionalProperties["last_amount"].(map[string]interface{}); ok {
+		if amount, ok := lastAmount["amount"].(float64); ok {
+			recurringStream.LastAmount = roundAmount(math.Abs(amount))
+		}
+	}
+	if category, ok := stream.AdditionalProperties["personal_finance_category"].(map[string]interface{}); ok {
+		recurringStream.Category, _ = category["primary"].(string)
+	} else if len(stream.Category) > 0 {
+		recurringStream.Category = strings.ToUpper(strings.ReplaceAll(stream.Category[0], " ", "_"))
+	}
+	if len(recurringStream.NextDate) == 0 {
+		recurringStream.NextDate = predictNextDate(recurringStream.LastDate, recurringStream.Frequency, 0)
+	}
+	return recurringStream
+}
+
+// DetectRecurringTransfers groups the user's internal transfers by sender and
+// receiver bank and reports every pair that repeats at least
+// MinRecurringTransfers times, with the cadence inferred from the average gap.
+// Transfers that failed or were cancelled are not occurrences.
+func DetectRecurringTransfers(userId string, transactions []db.Transaction, now time.Time) []db.RecurringStream {
+	type transferPair struct {
+		senderBankId   string
+		receiverBankId string
+	}
+	groups := make(map[transferPair][]db.Transaction)
+	var pairs []transferPair
+	for _, transaction := range transactions {
+		if transaction.SenderId == transaction.ReceiverId || !TransferMovedMoney(transaction.Status) {
+			continue
+		}
+		pair := transferPair{transaction.SenderBankId, transaction.ReceiverBankId}
+		if _, ok := groups[pair]; !ok {
+			pairs = append(pairs, pair)
+		}
+		groups[pair] = append(groups[pair], transaction)
+	}
+
+	dateFormat := "2006-01-02"
+	var streams []db.RecurringStream
+	for _, pair := range pairs {
+		group := groups[pair]
+		if len(group) < MinRecurringTransfers {
+			continue
+		}
+
+		var dates []time.Time
+		var total float64
+		for _, transaction := range group {
+			date, err := time.Parse(dateFormat, utils.ExtractTimeStamp(transaction.TransactionId))
+			if err != nil {
+				continue
+			}
+			amount, err := strconv.ParseFloat(transaction.Amount, 64)
+			if err != nil {
+				continue
+			}
+			dates = append(dates, date)
+			total += amount
+		}
+		if len(dates) < MinRecurringTransfers {
+			continue
+		}
+		sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+		sort.Slice(group, func(i, j int) bool { return group[i].TransactionId < group[j].TransactionId })
+
+		averageGap := dates[len(dates)-1].Sub(dates[0]).Hours() / 24 / float64(len(dates)-1)
+		frequency := frequencyFromGap(averageGap)
+		lastTransfer := group[len(group)-1]
+		lastAmount, _ := strconv.ParseFloat(lastTransfer.Amount, 64)
+		lastDate := dates[len(dates)-1].Format(dateFormat)
+
+		direction, trackId := RecurringInflow, pair.receiverBankId
+		if lastTransfer.SenderId == userId {
+			direction, trackId = RecurringOutflow, pair.senderBankId
+		}
+
+		stream := db.RecurringStream{
+			StreamId:      fmt.Sprintf("INTERNAL_%s_%s_%s", strings.ToUpper(direction), pair.senderBankId, pair.receiverBankId),
+			UserId:        userId,
+			TrackId:       trackId,
+			Source:        RecurringSourceInternal,
+			Direction:     direction,
+			Description:   lastTransfer.Name,
+			Category:      TransferOutCategory,
+			Frequency:     frequency,
+			AverageAmount: roundAmount(total / float64(len(dates))),
+			LastAmount:    roundAmount(lastAmount),
+			FirstDate:     dates[0].Format(dateFormat),
+			LastDate:      lastDate,
+			NextDate:      predictNextDate(lastDate, frequency, averageGap),
+			Status:        StreamStatusEarlyDetection,
+			IsActive:      true,
+			Occurrences:   len(dates),
+			UpdatedAt:     now,
+		}
+		if direction == RecurringInflow {
+			stream.Category = TransferInCategory
+		}
+		if len(dates) > MinRecurringTransfers && frequency != FrequencyUnknown {
+			stream.Status = StreamStatusMature
+		}
+		// A stream is considered lapsed once it misses a full expected cycle.
+		if nextDate, err := time.Parse(dateFormat, stream.NextDate); err == nil && now.Sub(nextDate).Hours()/24 > averageGap {
+			stream.Status = StreamStatusTombstoned
+			stream.IsActive = false
+		}
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+func frequencyFromGap(days float64) string {
+	switch {
+	case days >= 5 && days <= 9:
+		return FrequencyWeekly
+	case days >= 12 && days <= 17:
+		return FrequencyBiweekly
+	case days >= 26 && days <= 35:
+		return FrequencyMonthly
+	case days >= 350 && days <= 380:
+		return FrequencyAnnually
+	}
+	return FrequencyUnknown
+}
+
+func predictNextDate(lastDate string, frequency string, averageGap float64) string {
+	dateFormat := "2006-01-02"
+	date, err := time.Parse(dateFormat, lastDate)
+	if err != nil {
+		return ""
+	}
+	switch frequency {
+	case FrequencyWeekly:
+		return date.AddDate(0, 0, 7).Format(dateFormat)
+	case FrequencyBiweekly:
+		return date.AddDate(0, 0, 14).Format(dateFormat)
+	case FrequencySemiMonthly:
+		return date.AddDate(0, 0, 15).Format(dateFormat)
+	case FrequencyMonthly:
+		return date.AddDate(0, 1, 0).Format(dateFormat)
+	case FrequencyAnnually:
+		return date.AddDate(1, 0, 0).Format(dateFormat)
+	}
+	if averageGap > 0 {
+		return date.AddDate(0, 0, int(math.Round(averageGap))).Format(dateFormat)
+	}
+	return ""
+}
@@ -0,0 +1,376 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	AdminApiKey string
+	// RiskChecks are evaluated for every outgoing transfer; each returns a
+	// signal when it fires. Append to this slice to plug in more checks.
+	RiskChecks = []RiskCheck{
+		NewReceiverCheck,
+		RecentlyLinkedSenderCheck,
+		AmountAnomalyCheck,
+		RapidTransfersCheck,
+		RecentReauthCheck,
+		IdentityCheckRisk,
+	}
+	RiskHoldScore  = 50
+	RiskBlockScore = 80
+)
+
+const (
+	RiskAllow = "allow"
+	RiskHold  = "hold"
+	RiskBlock = "block"
+
+	ReviewNotRequired = "not_required"
+	ReviewPending     = "pending_review"
+	ReviewApproved    = "approved"
+	ReviewRejected    = "rejected"
+	ReviewFailed      = "failed"
+
+	RejectRiskBlocked = "RISK_BLOCKED"
+)
+
+type RiskContext struct {
+	SenderBank   db.PlaidUser
+	ReceiverBank db.PlaidUser
+	Amount       float64
+	Now          time.Time
+}
+
+type RiskSignal struct {
+	Name   string `json:"name"`
+	Score  int    `json:"score"`
+	Detail string `json:"detail"`
+}
+
+type RiskCheck func(bankdb *gorm.DB, riskCtx RiskContext) (*RiskSignal, error)
+
+type RiskAssessment struct {
+	Score    int          `json:"score"`
+	Decision string       `json:"decision"`
+	Signals  []RiskSignal `json:"signals"`
+}
+
+// TransferHeldError is returned when a transfer is parked for manual review.
+type TransferHeldError struct {
+	DecisionId string
+	Assessment RiskAssessment
+}
+
+func (e *TransferHeldError) Error() string {
+	return "transfer held for review: " + e.DecisionId
+}
+
+type RiskReviewRequest struct {
+	DecisionId string `json:"decisionId" binding:"required"`
+	Reviewer   string `json:"reviewer" binding:"required"`
+	Note       string `json:"note"`
+}
+
+func AssessTransferRisk(bankdb *gorm.DB, riskCtx RiskContext) (RiskAssessment, error) {
+	assessment := RiskAssessment{Decision: RiskAllow, Signals: []RiskSignal{}}
+	for _, check := range RiskChecks {
+		signal, err := check(bankdb, riskCtx)
+		if err != nil {
+			return RiskAssessment{}, err
+		}
+		if signal != nil {
+			assessment.Score += signal.Score
+			assessment.Signals = append(assessment.Signals, *signal)
+		}
+	}
+	if assessment.Score >= RiskBlockScore {
+		assessment.Decision = RiskBlock
+	} else if assessment.Score >= RiskHoldScore {
+		assessment.Decision = RiskHold
+	}
+	return assessment, nil
+}
+
+// ReviewTransferRisk scores the transfer, stores the decision with its signals
+// and returns an error when the transfer must not go ahead right away.
+func ReviewTransferRisk(bankdb *gorm.DB, paymentTransferReq PaymentTransfer, senderBank db.PlaidUser, receiverBank db.PlaidUser, amount float64) (db.RiskDecision, error) {
+	assessment, err := AssessTransferRisk(bankdb, RiskContext{
+		SenderBank:   senderBank,
+		ReceiverBank: receiverBank,
+		Amount:       amount,
+		Now:          time.Now(),
+	})
+	if err != nil {
+		return db.RiskDecision{}, fmt.Errorf("unable to assess transfer risk: %v", err.Error())
+	}
+
+	signals, err := json.Marshal(assessment.Signals)
+	if err != nil {
+		return db.RiskDecision{}, fmt.Errorf("unable to encode risk signals: %v", err.Error())
+	}
+
+	decision := db.RiskDecision{
+		DecisionId:          utils.GenerateId("RISKDEC"),
+		UserId:              senderBank.UserId,
+		SenderTrackId:       senderBank.TrackId,
+		ReceiverTrackId:     receiverBank.TrackId,
+		ReceiverShareableId: paymentTransferReq.ShareableId,
+		Name:                paymentTransferReq.Name,
+		Email:               paymentTransferReq.Email,
+		Amount:              paymentTransferReq.Amount,
+		Clearing:            paymentTransferReq.Clearing,
+		CorrelationId:       paymentTransferReq.CorrelationId,
+		Score:               assessment.Score,
+		Decision:            assessment.Decision,
+		Signals:             string(signals),
+		ReviewStatus:        ReviewNotRequired,
+		CreatedAt:           time.Now(),
+	}
+	if len(paymentTransferReq.Metadata) > 0 {
+		metadata, err := json.Marshal(paymentTransferReq.Metadata)
+		if err != nil {
+			return db.RiskDecision{}, fmt.Errorf("unable to encode transfer metadata: %v", err.Error())
+		}
+		decision.Metadata = string(metadata)
+	}
+	if assessment.Decision == RiskHold {
+		decision.ReviewStatus = ReviewPending
+	}
+	if err := db.AddRiskDecision(bankdb, decision); err != nil {
+		return db.RiskDecision{}, err
+	}
+	log.Println("Risk Decision: ", decision.DecisionId, decision.Decision, decision.Score)
+
+	switch assessment.Decision {
+	case RiskBlock:
+		return decision, &TransferRejectedError{Rejections: []TransferRejection{{
+			Code:    RejectRiskBlocked,
+			Message: fmt.Sprintf("transfer blocked by risk review (score %d)", assessment.Score),
+		}}}
+	case RiskHold:
+		return decision, &TransferHeldError{DecisionId: decision.DecisionId, Assessment: assessment}
+	}
+	return decision, nil
+}
+
+func NewReceiverCheck(bankdb *gorm.DB, riskCtx RiskContext) (*RiskSignal, error) {
+	count, err := db.CountTransfersBetween(bankdb, riskCtx.SenderBank.UserId, riskCtx.ReceiverBank.TrackId)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 || riskCtx.SenderBank.UserId == riskCtx.ReceiverBank.UserId {
+		return nil, nil
+	}
+	return &RiskSignal{Name: "new_receiver", Score: 25, Detail: "first transfer to this receiver"}, nil
+}
+
+func RecentlyLinkedSenderCheck(bankdb *gorm.DB, riskCtx RiskContext) (*RiskSignal, error) {
+	linkedAt, err := utils.ExtractTrackIdTime(riskCtx.SenderBank.TrackId)
+	if err != nil || riskCtx.Now.Sub(linkedAt) > 72*time.Hour {
+		return nil, nil
+	}
+	return &RiskSignal{Name: "recently_linked_sender", Score: 25, Detail: "sender bank linked " + linkedAt.Format(time.RFC3339)}, nil
+}
+
+// AmountAnomalyCheck compares the amount with the sender's earlier transfers.
+// Without enough history only large first transfers are flagged.
+func AmountAnomalyCheck(bankdb *gorm.DB, riskCtx RiskContext) (*RiskSignal, error) {
+	amounts, err := db.GetOutgoingTransferAmounts(bankdb, riskCtx.SenderBank.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []float64
+	for _, amount := range amounts {
+		if value, err := strconv.ParseFloat(amount, 64); err == nil {
+			history = append(history, value)
+		}
+	}
+	if len(history) < 3 {
+		if riskCtx.Amount >= 1000 {
+			return &RiskSignal{Name: "large_amount_without_history", Score: 15, Detail: fmt.Sprintf("%.2f with %d earlier transfers", riskCtx.Amount, len(history))}, nil
+		}
+		return nil, nil
+	}
+
+	var sum, squares float64
+	for _, value := range history {
+		sum += value
+	}
+	mean := sum / float64(len(history))
+	for _, value := range history {
+		squares += (value - mean) * (value - mean)
+	}
+	stddev := math.Sqrt(squares / float64(len(history)))
+	if riskCtx.Amount > mean+3*stddev && riskCtx.Amount > 2*mean {
+		return &RiskSignal{Name: "amount_anomaly", Score: 30, Detail: fmt.Sprintf("%.2f against an average of %.2f", riskCtx.Amount, mean)}, nil
+	}
+	return nil, nil
+}
+
+func RapidTransfersCheck(bankdb *gorm.DB, riskCtx RiskContext) (*RiskSignal, error) {
+	_, count, err := db.GetOutgoingTransferTotals(bankdb, riskCtx.SenderBank.UserId, riskCtx.Now.Add(-10*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	if count < 3 {
+		return nil, nil
+	}
+	return &RiskSignal{Name: "rapid_transfers", Score: 30, Detail: fmt.Sprintf("%d transfers in the last 10 minutes", count)}, nil
+}
+
+func RecentReauthCheck(bankdb *gorm.DB, riskCtx RiskContext) (*RiskSignal, error) {
+	reauthAt := riskCtx.SenderBank.ReauthAt
+	if reauthAt == nil || riskCtx.Now.Sub(*reauthAt) > 24*time.Hour {
+		return nil, nil
+	}
+	return &RiskSignal{Name: "recent_reauthentication", Score: 20, Detail: "sender bank re-authenticated " + reauthAt.Format(time.RFC3339)}, nil
+}
+
+// RequireAdminKey guards the review endpoints with the shared admin key.
+func RequireAdminKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(AdminApiKey) == 0 || c.GetHeader("X-Admin-Key") != AdminApiKey {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "admin access required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func GetHeldTransfers(c *gin.Context) {
+	decisions, err := db.GetRiskDecisionsUsingReviewStatus(PgDb, ReviewPending)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to fetch held transfers - " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"transfers": decisions})
+}
+
+func ApproveHeldTransfer(c *gin.Context) {
+	var reviewReq RiskReviewRequest
+	if err := c.ShouldBindJSON(&reviewReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	decision, ok := claimHeldTransfer(c, reviewReq, ReviewApproved)
+	if !ok {
+		return
+	}
+
+	paymentTransferReq := PaymentTransfer{
+		Name:          decision.Name,
+		Email:         decision.Email,
+		Amount:        decision.Amount,
+		SenderBank:    decision.SenderTrackId,
+		ShareableId:   decision.ReceiverShareableId,
+		Clearing:      decision.Clearing,
+		CorrelationId: decision.CorrelationId,
+	}
+	if len(decision.Metadata) > 0 {
+		if err := json.Unmarshal([]byte(decision.Metadata), &paymentTransferReq.Metadata); err != nil {
+			log.Println("unable to decode held transfer metadata: " + err.Error())
+		}
+	}
+	transaction, err := executeTransfer(PgDb, paymentTransferReq, transferOptions{skipRiskReview: true})
+	if err != nil {
+		log.Println(err.Error())
+		decision.ReviewStatus = ReviewFailed
+		decision.ReviewNote = err.Error()
+		if _, updateErr := db.UpdateRiskDecisionReview(PgDb, decision.DecisionId, ReviewApproved, decision); updateErr != nil {
+			log.Println(updateErr.Error())
+		}
+		closeHeldRun(decision, RunFailed, "", err.Error())
+		settleHeldPaymentRequest(decision.DecisionId, "", err.Error())
+		settleHeldQuote(decision.DecisionId, "", err.Error())
+		var rejectedErr *TransferRejectedError
+		if errors.As(err, &rejectedErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "reasons": rejectedErr.Rejections})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	decision.TransactionId = transaction.TransactionId
+	if _, err := db.UpdateRiskDecisionReview(PgDb, decision.DecisionId, ReviewApproved, decision); err != nil {
+		log.Println(err.Error())
+	}
+	closeHeldRun(decision, RunSucceeded, transaction.TransactionId, "")
+	settleHeldPaymentRequest(decision.DecisionId, transaction.TransactionId, "")
+	settleHeldQuote(decision.DecisionId, transaction.TransactionId, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer Approved", "decision": decision, "data": transaction})
+}
+
+func RejectHeldTransfer(c *gin.Context) {
+	var reviewReq RiskReviewRequest
+	if err := c.ShouldBindJSON(&reviewReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	decision, ok := claimHeldTransfer(c, reviewReq, ReviewRejected)
+	if !ok {
+		return
+	}
+	closeHeldRun(decision, RunFailed, "", "rejected in review: "+decision.ReviewNote)
+	settleHeldPaymentRequest(decision.DecisionId, "", "rejected in review: "+decision.ReviewNote)
+	settleHeldQuote(decision.DecisionId, "", "rejected in review: "+decision.ReviewNote)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer Rejected", "decision": decision})
+}
+
+// closeHeldRun finishes the scheduled run that was parked with the decision,
+// if the held transfer came from a schedule.
+func closeHeldRun(decision db.RiskDecision, status string, transactionId string, failureReason string) {
+	if len(decision.ScheduledRunId) == 0 {
+		return
+	}
+	if err := db.FinishScheduledTransferRun(PgDb, decision.ScheduledRunId, status, transactionId, failureReason); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// claimHeldTransfer moves a pending decision to the review outcome so two
+// reviewers cannot act on the same transfer.
+func claimHeldTransfer(c *gin.Context, reviewReq RiskReviewRequest, reviewStatus string) (db.RiskDecision, bool) {
+	decision, err := db.GetRiskDecisionUsingId(PgDb, reviewReq.DecisionId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "held transfer not found"})
+		return db.RiskDecision{}, false
+	}
+
+	reviewedAt := time.Now()
+	decision.ReviewStatus = reviewStatus
+	decision.ReviewedBy = reviewReq.Reviewer
+	decision.ReviewNote = reviewReq.Note
+	decision.ReviewedAt = &reviewedAt
+	claimed, err := db.UpdateRiskDecisionReview(PgDb, decision.DecisionId, ReviewPending, decision)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return db.RiskDecision{}, false
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "transfer is not pending review"})
+		return db.RiskDecision{}, false
+	}
+	return decision, true
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ScheduledTransferInterval = time.Minute
+	ScheduledTransferLease    = "scheduled-transfers"
+	// ScheduledRunStaleAfter is how long a run may stay processing before it
+	// is treated as abandoned by a worker that stopped mid-run.
+	ScheduledRunStaleAfter = 30 * time.Minute
+	// WorkerId identifies this replica when competing for worker leases.
+	WorkerId = fmt.Sprintf("%s-%d", hostname(), os.Getpid())
+)
+
+const (
+	ScheduleOnce    = "once"
+	ScheduleWeekly  = "weekly"
+	ScheduleMonthly = "monthly"
+
+	ScheduleActive    = "active"
+	SchedulePaused    = "paused"
+	ScheduleCancelled = "cancelled"
+	ScheduleCompleted = "completed"
+
+	RunProcessing = "processing"
+	RunSucceeded  = "succeeded"
+	RunFailed     = "failed"
+	RunHeld       = "held"
+)
+
+type ScheduledTransferRequest struct {
+	UserId      string `json:"userId" binding:"required"`
+	Name        string `json:"name" binding:"required"`
+	Email       string `json:"email"`
+	Amount      string `json:"amount" binding:"required"`
+	SenderBank  string `json:"senderBank" binding:"required"`
+	ShareableId string `json:"sharableId" binding:"required"`
+	Frequency   string `json:"frequency" binding:"required"`
+	StartDate   string `json:"startDate" binding:"required"`
+	DayOfMonth  int    `json:"dayOfMonth"`
+	EndDate     string `json:"endDate"`
+}
+
+type ScheduleIdRequest struct {
+	ScheduleId string `json:"scheduleId" binding:"required"`
+	UserId     string `json:"userId" binding:"required"`
+}
+
+func CreateScheduledTransfer(c *gin.Context) {
+	var scheduleReq ScheduledTransferRequest
+	if err := c.ShouldBindJSON(&scheduleReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	schedule, err := BuildScheduledTransfer(PgDb, scheduleReq, time.Now())
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.CreateScheduledTransfer(PgDb, schedule); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer Scheduled Successfully", "schedule": schedule})
+}
+
+func GetScheduledTransfers(c *gin.Context) {
+	var userData BankUserId
+	if err := c.ShouldBindJSON(&userData); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	schedules, err := db.GetScheduledTransfersUsingUserId(PgDb, userData.UserId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to fetch scheduled transfers - " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+func GetScheduledTransferRuns(c *gin.Context) {
+	var scheduleReq ScheduleIdRequest
+	if err := c.ShouldBindJSON(&scheduleReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	schedule, err := db.GetScheduledTransferUsingId(PgDb, scheduleReq.ScheduleId)
+	if err != nil || schedule.UserId != scheduleReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "scheduled transfer not found for user"})
+		return
+	}
+
+	runs, err := db.GetScheduledTransferRuns(PgDb, schedule.ScheduleId)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to fetch scheduled transfer runs - " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedule": schedule, "runs": runs})
+}
+
+func PauseScheduledTransfer(c *gin.Context) {
+	changeScheduleStatus(c, []string{ScheduleActive}, SchedulePaused, "Scheduled Transfer Paused")
+}
+
+func ResumeScheduledTransfer(c *gin.Context) {
+	changeScheduleStatus(c, []string{SchedulePaused}, ScheduleActive, "Scheduled Transfer Resumed")
+}
+
+func CancelScheduledTransfer(c *gin.Context) {
+	changeScheduleStatus(c, []string{ScheduleActive, SchedulePaused}, ScheduleCancelled, "Scheduled Transfer Cancelled")
+}
+
+func changeScheduleStatus(c *gin.Context, fromStatuses []string, toStatus string, message string) {
+	var scheduleReq ScheduleIdRequest
+	if err := c.ShouldBindJSON(&scheduleReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	schedule, err := db.GetScheduledTransferUsingId(PgDb, scheduleReq.ScheduleId)
+	if err != nil || schedule.UserId != scheduleReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "scheduled transfer not found for user"})
+		return
+	}
+
+	allowed := false
+	for _, status := range fromStatuses {
+		if schedule.Status == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("scheduled transfer is %s and cannot be %s", schedule.Status, toStatus)})
+		return
+	}
+
+	schedule.Status = toStatus
+	if toStatus == ScheduleActive {
+		// Occurrences missed while paused are skipped rather than run late.
+		today := time.Now().Format("2006-01-02")
+		for len(schedule.NextRunDate) > 0 && schedule.NextRunDate < today {
+			schedule.NextRunDate = NextOccurrence(schedule, schedule.NextRunDate)
+		}
+		if len(schedule.NextRunDate) == 0 {
+			schedule.Status = ScheduleCompleted
+		}
+	}
+	schedule.UpdatedAt = time.Now()
+	if err := db.UpdateScheduledTransfer(PgDb, schedule); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "schedule": schedule})
+}
+
+// BuildScheduledTransfer validates the request and computes the first
+// occurrence on or after today.
+func BuildScheduledTransfer(bankdb *gorm.DB, scheduleReq ScheduledTransferRequest, now time.Time) (db.ScheduledTransfer, error) {
+	dateFormat := "2006-01-02"
+	today := now.Format(dateFormat)
+
+	senderBank, err := db.GetRecordUsingTrackId(bankdb, scheduleReq.SenderBank)
+	if err != nil || senderBank.UserId != scheduleReq.UserId {
+		return db.ScheduledTransfer{}, fmt.Errorf("sender bank %s not found for user", scheduleReq.SenderBank)
+	}
+	receiverAccountId, err := utils.DecryptID(scheduleReq.ShareableId)
+	if err != nil {
+		return db.ScheduledTransfer{}, fmt.Errorf("unable to decrypt shareable id: %v", err.Error())
+	}
+	if _, err := db.GetRecordUsingAccountId(bankdb, receiverAccountId); err != nil {
+		return db.ScheduledTransfer{}, fmt.Errorf("receiver not found for shareable id")
+	}
+	if amount, err := strconv.ParseFloat(scheduleReq.Amount, 64); err != nil || amount <= 0 {
+		return db.ScheduledTransfer{}, fmt.Errorf("invalid amount: %s", scheduleReq.Amount)
+	}
+
+	startDate, err := time.Parse(dateFormat, scheduleReq.StartDate)
+	if err != nil {
+		return db.ScheduledTransfer{}, fmt.Errorf("invalid start date: %v", err.Error())
+	}
+	if len(scheduleReq.EndDate) > 0 {
+		if _, err := time.Parse(dateFormat, scheduleReq.EndDate); err != nil {
+			return db.ScheduledTransfer{}, fmt.Errorf("invalid end date: %v", err.Error())
+		}
+		if scheduleReq.EndDate < scheduleReq.StartDate {
+			return db.ScheduledTransfer{}, fmt.Errorf("end date %s is before start date %s", scheduleReq.EndDate, scheduleReq.StartDate)
+		}
+	}
+
+	schedule := db.ScheduledTransfer{
+		ScheduleId:          utils.GenerateId("SCHEDUL"),
+		UserId:              scheduleReq.UserId,
+		SenderTrackId:       senderBank.TrackId,
+		ReceiverShareableId: scheduleReq.ShareableId,
+		Name:                scheduleReq.Name,
+		Email:               scheduleReq.Email,
+		Amount:              scheduleReq.Amount,
+		Frequency:           strings.ToLower(scheduleReq.Frequency),
+		StartDate:           scheduleReq.StartDate,
+		EndDate:             scheduleReq.EndDate,
+		Status:              ScheduleActive,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+
+	switch schedule.Frequency {
+	case ScheduleOnce:
+		if scheduleReq.StartDate <= today {
+			return db.ScheduledTransfer{}, fmt.Errorf("a one-off transfer must be scheduled for a future date")
+		}
+		schedule.EndDate = scheduleReq.StartDate
+		schedule.NextRunDate = scheduleReq.StartDate
+	case ScheduleWeekly:
+		schedule.NextRunDate = scheduleReq.StartDate
+	case ScheduleMonthly:
+		if scheduleReq.DayOfMonth < 1 || scheduleReq.DayOfMonth > 31 {
+			return db.ScheduledTransfer{}, fmt.Errorf("day of month must be between 1 and 31")
+		}
+		schedule.DayOfMonth = scheduleReq.DayOfMonth
+		schedule.NextRunDate = monthlyOccurrence(startDate.Year(), startDate.Month(), schedule.DayOfMonth).Format(dateFormat)
+		if schedule.NextRunDate < scheduleReq.StartDate {
+			schedule.NextRunDate = NextOccurrence(schedule, schedule.NextRunDate)
+		}
+	default:
+		return db.ScheduledTransfer{}, fmt.Errorf("unsupported frequency %s, expected once, weekly or monthly", scheduleReq.Frequency)
+	}
+
+	for len(schedule.NextRunDate) > 0 && schedule.NextRunDate < today {
+		schedule.NextRunDate = NextOccurrence(schedule, schedule.NextRunDate)
+	}
+	if len(schedule.NextRunDate) == 0 {
+		return db.ScheduledTransfer{}, fmt.Errorf("schedule has no occurrence on or after today")
+	}
+	return schedule, nil
+}
+
+// NextOccurrence returns the occurrence after the given date, or an empty
+// string when the schedule has no further runs.
+func NextOccurrence(schedule db.ScheduledTransfer, after string) string {
+	dateFormat := "2006-01-02"
+	date, err := time.Parse(dateFormat, after)
+	if err != nil {
+		return ""
+	}
+
+	var next time.Time
+	switch schedule.Frequency {
+	case ScheduleWeekly:
+		next = date.AddDate(0, 0, 7)
+	case ScheduleMonthly:
+		firstOfNextMonth := time.Date(date.Year(), date.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		next = monthlyOccurrence(firstOfNextMonth.Year(), firstOfNextMonth.Month(), schedule.DayOfMonth)
+	default:
+		return ""
+	}
+
+	nextDate := next.Format(dateFormat)
+	if len(schedule.EndDate) > 0 && nextDate > schedule.EndDate {
+		return ""
+	}
+	return nextDate
+}
+
+// monthlyOccurrence clamps the day to the length of the month, so a schedule
+// on the 31st runs on the last day of shorter months.
+func monthlyOccurrence(year int, month time.Month, day int) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// StartScheduledTransferWorker runs due schedules and expires stale payment
+// requests on every tick while this replica holds the scheduler lease.
+func StartScheduledTransferWorker(ctx context.Context, bankdb *gorm.DB) {
+	ticker := time.NewTicker(ScheduledTransferInterval)
+	defer ticker.Stop()
+	for {
+		isLeader, err := db.AcquireLease(bankdb, ScheduledTransferLease, WorkerId, 3*ScheduledTransferInterval)
+		if err != nil {
+			log.Println(err.Error())
+		} else if isLeader {
+			RunDueScheduledTransfers(bankdb, time.Now())
+			ExpirePaymentRequests(bankdb, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func RunDueScheduledTransfers(bankdb *gorm.DB, now time.Time) {
+	today := now.Format("2006-01-02")
+	schedules, err := db.GetDueScheduledTransfers(bankdb, ScheduleActive, today)
+	if err != nil {
+		log.Println("unable to fetch due scheduled transfers: " + err.Error())
+		return
+	}
+
+	for _, schedule := range schedules {
+		RunScheduledTransfer(bankdb, schedule)
+	}
+}
+
+// RunScheduledTransfer executes the schedule's pending occurrence once and
+// advances the schedule to its next occurrence.
+func RunScheduledTransfer(bankdb *gorm.DB, schedule db.ScheduledTransfer) {
+	run := db.ScheduledTransferRun{
+		RunId:          utils.GenerateId("SCHDRUN"),
+		ScheduleId:     schedule.ScheduleId,
+		OccurrenceDate: schedule.NextRunDate,
+		Status:         RunProcessing,
+		StartedAt:      time.Now(),
+	}
+	claimed, err := db.StartScheduledTransferRun(bankdb, run)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	if claimed {
+		transaction, err := ExecuteTransfer(bankdb, PaymentTransfer{
+			Name:        schedule.Name,
+			Email:       schedule.Email,
+			Amount:      schedule.Amount,
+			SenderBank:  schedule.SenderTrackId,
+			ShareableId: schedule.ReceiverShareableId,
+		})
+		status, failureReason := RunSucceeded, ""
+		var heldErr *TransferHeldError
+		if errors.As(err, &heldErr) {
+			status, failureReason = RunHeld, err.Error()
+			if err := db.SetRiskDecisionScheduledRun(bankdb, heldErr.DecisionId, run.RunId); err != nil {
+				log.Println(err.Error())
+			}
+		} else if err != nil {
+			log.Println("scheduled transfer " + schedule.ScheduleId + " failed: " + err.Error())
+			status, failureReason = RunFailed, err.Error()
+		}
+		if err := db.FinishScheduledTransferRun(bankdb, run.RunId, status, transaction.TransactionId, failureReason); err != nil {
+			log.Println(err.Error())
+		}
+	} else {
+		existing, err := db.GetScheduledTransferRunUsingOccurrence(bankdb, schedule.ScheduleId, schedule.NextRunDate)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+		if existing.Status == RunProcessing {
+			if time.Since(existing.StartedAt) < ScheduledRunStaleAfter {
+				log.Println("scheduled transfer " + schedule.ScheduleId + " is still running for " + schedule.NextRunDate)
+				return
+			}
+			// The transfer may have reached Dwolla before the worker stopped, so
+			// the occurrence is failed for review rather than sent again.
+			log.Println("scheduled transfer " + schedule.ScheduleId + " run " + existing.RunId + " went stale")
+			if err := db.FinishScheduledTransferRun(bankdb, existing.RunId, RunFailed, existing.TransactionId, "run did not finish before the claim went stale"); err != nil {
+				log.Println(err.Error())
+				return
+			}
+		} else {
+			log.Println("scheduled transfer " + schedule.ScheduleId + " already ran for " + schedule.NextRunDate)
+		}
+	}
+
+	nextRunDate, status := NextOccurrence(schedule, schedule.NextRunDate), ScheduleActive
+	if len(nextRunDate) == 0 {
+		status = ScheduleCompleted
+	}
+	if err := db.AdvanceScheduledTransfer(bankdb, schedule.ScheduleId, nextRunDate, status, time.Now()); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "plaid-service"
+	}
+	return name
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule db.ScheduledTransfer
+		after    string
+		want     string
+	}{
+		{"weekly", db.ScheduledTransfer{Frequency: ScheduleWeekly}, "2024-01-01", "2024-01-08"},
+		{"weekly across a year", db.ScheduledTransfer{Frequency: ScheduleWeekly}, "2024-12-28", "2025-01-04"},
+		{"monthly", db.ScheduledTransfer{Frequency: ScheduleMonthly, DayOfMonth: 15}, "2024-12-15", "2025-01-15"},
+		{"monthly clamps to a leap February", db.ScheduledTransfer{Frequency: ScheduleMonthly, DayOfMonth: 31}, "2024-01-31", "2024-02-29"},
+		{"monthly clamps to February", db.ScheduledTransfer{Frequency: ScheduleMonthly, DayOfMonth: 31}, "2023-01-31", "2023-02-28"},
+		{"monthly returns to the day after clamping", db.ScheduledTransfer{Frequency: ScheduleMonthly, DayOfMonth: 31}, "2024-02-29", "2024-03-31"},
+		{"on the end date", db.ScheduledTransfer{Frequency: ScheduleWeekly, EndDate: "2024-01-08"}, "2024-01-01", "2024-01-08"},
+		{"past the end date", db.ScheduledTransfer{Frequency: ScheduleWeekly, EndDate: "2024-01-07"}, "2024-01-01", ""},
+		{"once", db.ScheduledTransfer{Frequency: ScheduleOnce}, "2024-01-01", ""},
+		{"invalid date", db.ScheduledTransfer{Frequency: ScheduleWeekly}, "01/01/2024", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NextOccurrence(tt.schedule, tt.after); got != tt.want {
+				t.Fatalf("NextOccurrence(%q) = %q, want %q", tt.after, got, tt.want)
+			}
+		})
+	}
+}
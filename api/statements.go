@@ -0,0 +1,553 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/utils"
+	"github.com/plaid/plaid-go/plaid"
+	"gorm.io/gorm"
+)
+
+const (
+	ActivitySourcePlaid    = "plaid"
+	ActivitySourceTransfer = "transfer"
+	ActivitySourceFee      = "fee"
+
+	BalanceFromSnapshot = "snapshot"
+	BalanceDerived      = "derived"
+	BalanceUnavailable  = "unavailable"
+
+	FormatJson = "json"
+	FormatPdf  = "pdf"
+
+	// OpeningSnapshotMaxDays is how long before the month the snapshot used
+	// as the opening balance may be taken. Older snapshots miss the activity
+	// since, so the opening balance is derived instead.
+	OpeningSnapshotMaxDays = 3
+)
+
+// StatementLine is one entry of account activity. Amount is signed from the
+// account holder's side: money in is positive and money out is negative,
+// whatever the source's own convention.
+type StatementLine struct {
+	Date        string  `json:"date"`
+	Description string  `json:"description"`
+	Category    string  `json:"category"`
+	Source      string  `json:"source"`
+	Reference   string  `json:"reference"`
+	Status      string  `json:"status"`
+	Pending     bool    `json:"pending"`
+	Amount      float64 `json:"amount"`
+}
+
+type StatementTotals struct {
+	Credits float64 `json:"credits"`
+	Debits  float64 `json:"debits"`
+	Net     float64 `json:"net"`
+	Count   int     `json:"count"`
+}
+
+type AccountStatement struct {
+	TrackId        string          `json:"plaidTrackId"`
+	Month          string          `json:"month"`
+	StartDate      string          `json:"startDate"`
+	EndDate        string          `json:"endDate"`
+	AccountName    string          `json:"accountName"`
+	AccountMask    string          `json:"accountMask"`
+	OpeningBalance *float64        `json:"openingBalance"`
+	ClosingBalance *float64        `json:"closingBalance"`
+	BalanceSource  string          `json:"balanceSource"`
+	Lines          []StatementLine `json:"lines"`
+	Totals         StatementTotals `json:"totals"`
+	GeneratedAt    time.Time       `json:"generatedAt"`
+}
+
+type TransferReceipt struct {
+	TransactionId    string     `json:"transactionId"`
+	DwollaTransferId string     `json:"dwollaTransferId"`
+	Description      string     `json:"description"`
+	Status           string     `json:"status"`
+	Direction        string     `json:"direction"`
+	Amount           string     `json:"amount"`
+	FeeAmount        string     `json:"feeAmount"`
+	Total            string     `json:"total"`
+	Clearing         string     `json:"clearing"`
+	SenderName       string     `json:"senderName"`
+	SenderMask       string     `json:"senderMask"`
+	ReceiverName     string     `json:"receiverName"`
+	ReceiverMask     string     `json:"receiverMask"`
+	InitiatedDate    string     `json:"initiatedDate"`
+	ProcessedAt      *time.Time `json:"processedAt"`
+	FailedAt         *time.Time `json:"failedAt"`
+	FailureReason    string     `json:"failureReason"`
+	IssuedAt         time.Time  `json:"issuedAt"`
+}
+
+type ReceiptRequest struct {
+	UserId        string `json:"userId" binding:"required"`
+	TransactionId string `json:"transactionId" binding:"required"`
+	Format        string `json:"format"`
+}
+
+type StatementRequest struct {
+	UserId  string `json:"userId" binding:"required"`
+	TrackId string `json:"plaidTrackId" binding:"required"`
+	Month   string `json:"month" binding:"required"`
+	Format  string `json:"format"`
+}
+
+// RecordBalanceSnapshot saves the balance Plaid just reported for the bank.
+// Failures are logged; a missed snapshot only makes a statement less exact.
+func RecordBalanceSnapshot(bankdb *gorm.DB, bank db.PlaidUser, accountData plaid.AccountBase, now time.Time) {
+	snapshot := db.BalanceSnapshot{
+		TrackId:        bank.TrackId,
+		Date:           now.Format("2006-01-02"),
+		UserId:         bank.UserId,
+		CurrentBalance: roundAmount(float64(accountData.Balances.GetCurrent())),
+		CapturedAt:     now,
+	}
+	if accountData.Balances.Available.IsSet() && accountData.Balances.Available.Get() != nil {
+		available := roundAmount(float64(*accountData.Balances.Available.Get()))
+		snapshot.AvailableBalance = &available
+	}
+	if err := db.SaveBalanceSnapshot(bankdb, snapshot); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// plaidActivityLines maps a synced transaction to a statement line. Plaid
+// reports money leaving the account as a positive amount. The bank's leg of
+// one of our transfers gives no line, since the transfer is listed itself.
+func plaidActivityLines(transaction db.SyncedTransaction) []StatementLine {
+	if transaction.MirrorsTransfer {
+		return nil
+	}
+	return []StatementLine{{
+		Date:        transaction.Date,
+		Description: transaction.Name,
+		Category:    transaction.CategoryPrimary,
+		Source:      ActivitySourcePlaid,
+		Reference:   transaction.TransactionId,
+		Pending:     transaction.Pending,
+		Amount:      roundAmount(-transaction.Amount),
+	}}
+}
+
+// transferActivityLines maps an internal transfer to statement lines as seen
+// from the given bank. The sender also gets a line for any fee.
+func transferActivityLines(transfer db.Transaction, trackId string) []StatementLine {
+	amount, err := strconv.ParseFloat(transfer.Amount, 64)
+	if err != nil {
+		log.Println("invalid amount on transaction " + transfer.TransactionId)
+		return nil
+	}
+	line := StatementLine{
+		Date:        utils.ExtractTimeStamp(transfer.TransactionId),
+		Description: transfer.Name,
+		Category:    TransferInCategory,
+		Source:      ActivitySourceTransfer,
+		Reference:   transfer.TransactionId,
+		Status:      transfer.Status,
+		Pending:     transfer.Status == DwollaTransferPending,
+		Amount:      roundAmount(amount),
+	}
+	if transfer.SenderBankId != trackId {
+		return []StatementLine{line}
+	}
+	line.Category = TransferOutCategory
+	line.Amount = -line.Amount
+	lines := []StatementLine{line}
+	if fee, err := strconv.ParseFloat(transfer.FeeAmount, 64); err == nil && fee > 0 {
+		lines = append(lines, StatementLine{
+			Date:        line.Date,
+			Description: "Transfer fee",
+			Category:    TransferOutCategory,
+			Source:      ActivitySourceFee,
+			Reference:   transfer.TransactionId,
+			Status:      transfer.Status,
+			Pending:     line.Pending,
+			Amount:      -roundAmount(fee),
+		})
+	}
+	return lines
+}
+
+// activityStream reads one source of activity a row at a time. A row can
+// give several lines, or none when it falls outside the range.
+type activityStream struct {
+	rows     *sql.Rows
+	read     func(rows *sql.Rows) ([]StatementLine, error)
+	buffered []StatementLine
+}
+
+func (stream *activityStream) peek() (*StatementLine, error) {
+	for len(stream.buffered) == 0 {
+		if stream.rows == nil || !stream.rows.Next() {
+			if stream.rows != nil {
+				return nil, stream.rows.Err()
+			}
+			return nil, nil
+		}
+		lines, err := stream.read(stream.rows)
+		if err != nil {
+			return nil, err
+		}
+		stream.buffered = lines
+	}
+	return &stream.buffered[0], nil
+}
+
+func (stream *activityStream) pop() {
+	stream.buffered = stream.buffered[1:]
+}
+
+// transferIdRange turns an inclusive date range into transaction id bounds.
+// Transaction ids are TRANSCT followed by a yyyymmddhhmmss timestamp.
+func transferIdRange(startDate string, endDate string) (string, string, error) {
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid start date %s", startDate)
+	}
+	end, err := time.Parse("2006-01-02", endDate)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid end date %s", endDate)
+	}
+	return "TRANSCT" + start.Format("20060102"), "TRANSCT" + end.AddDate(0, 0, 1).Format("20060102"), nil
+}
+
+// StreamAccountActivity passes the Plaid and internal transfer activity of a
+// bank between two dates inclusive to emit, oldest first, without holding it
+// all in memory.
+func StreamAccountActivity(bankdb *gorm.DB, bank db.PlaidUser, startDate string, endDate string, emit func(line StatementLine) error) error {
+	plaidStream := &activityStream{read: func(rows *sql.Rows) ([]StatementLine, error) {
+		var transaction db.SyncedTransaction
+		if err := bankdb.ScanRows(rows, &transaction); err != nil {
+			return nil, fmt.Errorf("error while reading synced transaction: %v", err.Error())
+		}
+		return plaidActivityLines(transaction), nil
+	}}
+	if !IsManualBank(bank) {
+		rows, err := db.SyncedTransactionRows(bankdb, bank.AccountId, startDate, endDate)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		plaidStream.rows = rows
+	}
+
+	fromId, toId, err := transferIdRange(startDate, endDate)
+	if err != nil {
+		return err
+	}
+	rows, err := db.TransferRows(bankdb, bank.TrackId, fromId, toId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	transferStream := &activityStream{rows: rows, read: func(rows *sql.Rows) ([]StatementLine, error) {
+		var transfer db.Transaction
+		if err := bankdb.ScanRows(rows, &transfer); err != nil {
+			return nil, fmt.Errorf("error while reading transaction: %v", err.Error())
+		}
+		return transferActivityLines(transfer, bank.TrackId), nil
+	}}
+
+	for {
+		plaidLine, err := plaidStream.peek()
+		if err != nil {
+			return err
+		}
+		transferLine, err := transferStream.peek()
+		if err != nil {
+			return err
+		}
+		stream := plaidStream
+		switch {
+		case plaidLine == nil && transferLine == nil:
+			return nil
+		case plaidLine == nil:
+			stream = transferStream
+		case transferLine != nil && (transferLine.Date < plaidLine.Date || (transferLine.Date == plaidLine.Date && transferLine.Reference < plaidLine.Reference)):
+			stream = transferStream
+		}
+		line, _ := stream.peek()
+		if err := emit(*line); err != nil {
+			return err
+		}
+		stream.pop()
+	}
+}
+
+// AccountActivity returns the activity of a bank between two dates
+// inclusive, oldest first.
+func AccountActivity(bankdb *gorm.DB, bank db.PlaidUser, startDate string, endDate string) ([]StatementLine, error) {
+	lines := []StatementLine{}
+	err := StreamAccountActivity(bankdb, bank, startDate, endDate, func(line StatementLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// activityCounts reports whether a line moved money. Failed and cancelled
+// transfers are listed but left out of totals.
+func activityCounts(line StatementLine) bool {
+	return TransferMovedMoney(line.Status)
+}
+
+// statementTotals sums the lines that moved money.
+func statementTotals(lines []StatementLine) StatementTotals {
+	var totals StatementTotals
+	for _, line := range lines {
+		if !activityCounts(line) {
+			continue
+		}
+		totals.Count++
+		if line.Amount >= 0 {
+			totals.Credits += line.Amount
+		} else {
+			totals.Debits += -line.Amount
+		}
+	}
+	totals.Credits = roundAmount(totals.Credits)
+	totals.Debits = roundAmount(totals.Debits)
+	totals.Net = roundAmount(totals.Credits - totals.Debits)
+	return totals
+}
+
+// BuildStatement assembles the statement of a bank for the calendar month
+// starting at monthStart. The opening balance is the last snapshot in the
+// few days before the month and the closing balance the last snapshot in it;
+// when only one is known the other is derived from the month's net activity.
+func BuildStatement(bankdb *gorm.DB, bank db.PlaidUser, monthStart time.Time, now time.Time) (AccountStatement, error) {
+	monthEnd := monthStart.AddDate(0, 1, -1)
+	statement := AccountStatement{
+		TrackId:     bank.TrackId,
+		Month:       monthStart.Format("2006-01"),
+		StartDate:   monthStart.Format("2006-01-02"),
+		EndDate:     monthEnd.Format("2006-01-02"),
+		AccountName: bank.BankName,
+		AccountMask: bank.AccountMask,
+		GeneratedAt: now,
+	}
+	if len(statement.AccountName) == 0 && !IsManualBank(bank) {
+		if accountData, _, err := GetAccounts(bank.AccessToken); err == nil {
+			statement.AccountName = accountData.GetName()
+			statement.AccountMask = accountData.GetMask()
+		}
+	}
+
+	lines, err := AccountActivity(bankdb, bank, statement.StartDate, statement.EndDate)
+	if err != nil {
+		return AccountStatement{}, err
+	}
+	statement.Lines = lines
+	statement.Totals = statementTotals(statement.Lines)
+
+	statement.BalanceSource = BalanceUnavailable
+	opening, openingErr := db.GetLastBalanceSnapshot(bankdb, bank.TrackId, monthStart.AddDate(0, 0, -1).Format("2006-01-02"))
+	if openingErr == nil && opening.Date < monthStart.AddDate(0, 0, -OpeningSnapshotMaxDays).Format("2006-01-02") {
+		openingErr = fmt.Errorf("last snapshot before %s is from %s", statement.Month, opening.Date)
+	}
+	closing, closingErr := db.GetLastBalanceSnapshot(bankdb, bank.TrackId, statement.EndDate)
+	if closingErr == nil && closing.Date < statement.StartDate {
+		closingErr = fmt.Errorf("no snapshot in %s", statement.Month)
+	}
+	switch {
+	case openingErr == nil && closingErr == nil:
+		statement.OpeningBalance = &opening.CurrentBalance
+		statement.ClosingBalance = &closing.CurrentBalance
+		statement.BalanceSource = BalanceFromSnapshot
+	case openingErr == nil:
+		closingBalance := roundAmount(opening.CurrentBalance + statement.Totals.Net)
+		statement.OpeningBalance = &opening.CurrentBalance
+		statement.ClosingBalance = &closingBalance
+		statement.BalanceSource = BalanceDerived
+	case closingErr == nil:
+		openingBalance := roundAmount(closing.CurrentBalance - statement.Totals.Net)
+		statement.OpeningBalance = &openingBalance
+		statement.ClosingBalance = &closing.CurrentBalance
+		statement.BalanceSource = BalanceDerived
+	}
+	return statement, nil
+}
+
+// statementSettled reports whether every line of the statement is final, so
+// it will not change when generated again.
+func statementSettled(statement AccountStatement) bool {
+	for _, line := range statement.Lines {
+		if line.Pending {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildTransferReceipt describes an internal transfer from the viewer's side.
+func BuildTransferReceipt(bankdb *gorm.DB, transaction db.Transaction, viewerId string, now time.Time) TransferReceipt {
+	receipt := TransferReceipt{
+		TransactionId:    transaction.TransactionId,
+		DwollaTransferId: transaction.DwollaTransferId,
+		Description:      transaction.Name,
+		Status:           transaction.Status,
+		Direction:        "received",
+		Amount:           transaction.Amount,
+		Total:            transaction.Amount,
+		Clearing:         transaction.ActualClearing,
+		InitiatedDate:    utils.ExtractTimeStamp(transaction.TransactionId),
+		ProcessedAt:      transaction.ProcessedAt,
+		FailedAt:         transaction.FailedAt,
+		FailureReason:    transaction.FailureReason,
+		IssuedAt:         now,
+	}
+	if transaction.SenderId == viewerId {
+		receipt.Direction = "sent"
+		receipt.FeeAmount = transaction.FeeAmount
+		amount, amountErr := strconv.ParseFloat(transaction.Amount, 64)
+		fee, feeErr := strconv.ParseFloat(transaction.FeeAmount, 64)
+		if amountErr == nil && feeErr == nil {
+			receipt.Total = strconv.FormatFloat(roundAmount(amount+fee), 'f', 2, 64)
+		}
+	}
+	if senderBank, err := db.GetRecordUsingTrackId(bankdb, transaction.SenderBankId); err == nil {
+		receipt.SenderName, receipt.SenderMask = ReceiverDisplay(bankdb, senderBank)
+	}
+	if receiverBank, err := db.GetRecordUsingTrackId(bankdb, transaction.ReceiverBankId); err == nil {
+		receipt.ReceiverName, receipt.ReceiverMask = ReceiverDisplay(bankdb, receiverBank)
+	}
+	return receipt
+}
+
+func GetTransferReceipt(c *gin.Context) {
+	var receiptReq ReceiptRequest
+	if err := c.ShouldBindJSON(&receiptReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if receiptReq.Format != "" && receiptReq.Format != FormatJson && receiptReq.Format != FormatPdf {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or pdf"})
+		return
+	}
+
+	transaction, err := db.GetTransactionUsingId(PgDb, receiptReq.TransactionId)
+	if err != nil || (transaction.SenderId != receiptReq.UserId && transaction.ReceiverId != receiptReq.UserId) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found for user"})
+		return
+	}
+	receipt := BuildTransferReceipt(PgDb, transaction, receiptReq.UserId, time.Now())
+
+	if receiptReq.Format == FormatPdf {
+		pdf, err := RenderReceiptPdf(receipt)
+		if err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=receipt-%s.pdf", receipt.TransactionId))
+		c.Data(http.StatusOK, "application/pdf", pdf)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": receipt})
+}
+
+// GetAccountStatement returns the monthly statement of a bank. Statements for
+// finished months with nothing pending are generated once and served from the
+// cache afterwards.
+func GetAccountStatement(c *gin.Context) {
+	var statementReq StatementRequest
+	if err := c.ShouldBindJSON(&statementReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if statementReq.Format != "" && statementReq.Format != FormatJson && statementReq.Format != FormatPdf {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or pdf"})
+		return
+	}
+	monthStart, err := time.Parse("2006-01", statementReq.Month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must look like 2024-01"})
+		return
+	}
+	now := time.Now()
+	currentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if monthStart.After(currentMonth) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month is in the future"})
+		return
+	}
+
+	bank, err := db.GetRecordUsingTrackId(PgDb, statementReq.TrackId)
+	if err != nil || bank.UserId != statementReq.UserId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no account found for user"})
+		return
+	}
+
+	var statement AccountStatement
+	var pdf []byte
+	cached, err := db.GetStatement(PgDb, bank.TrackId, statementReq.Month)
+	if err == nil {
+		if err := json.Unmarshal([]byte(cached.Data), &statement); err != nil {
+			log.Println("unable to decode cached statement: " + err.Error())
+		} else {
+			pdf = cached.Pdf
+		}
+	}
+	if pdf == nil {
+		if !IsManualBank(bank) {
+			if err := SyncTransactions(PgDb, bank); err != nil {
+				log.Println("unable to sync transactions before statement: " + err.Error())
+			}
+		}
+		statement, err = BuildStatement(PgDb, bank, monthStart, now)
+		if err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		pdf, err = RenderStatementPdf(statement)
+		if err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// The current month and pending lines still change, so only settled
+		// finished months are kept.
+		if monthStart.Before(currentMonth) && statementSettled(statement) {
+			data, err := json.Marshal(statement)
+			if err == nil {
+				err = db.SaveStatement(PgDb, db.Statement{TrackId: bank.TrackId, Month: statement.Month, UserId: bank.UserId, Data: string(data), Pdf: pdf, CreatedAt: now})
+			}
+			if err != nil {
+				log.Println("unable to cache statement: " + err.Error())
+			}
+		}
+	}
+
+	if statementReq.Format == FormatPdf {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=statement-%s-%s.pdf", bank.TrackId, statement.Month))
+		c.Data(http.StatusOK, "application/pdf", pdf)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": statement})
+}
+
+func formatMoney(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+	}
+	return sign + "$" + strconv.FormatFloat(math.Abs(amount), 'f', 2, 64)
+}
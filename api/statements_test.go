@@ -0,0 +1,61 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+)
+
+func TestStatementTotalsCountTransfersOnce(t *testing.T) {
+	transfer := db.Transaction{
+		TransactionId:  "TRANSCT20250301101500",
+		Name:           "Rent share",
+		Amount:         "50.00",
+		SenderBankId:   "PLAIDABC20250101000000",
+		ReceiverBankId: "PLAIDXYZ20250101000000",
+		Status:         DwollaTransferProcessed,
+	}
+	plaidLeg := db.SyncedTransaction{
+		TransactionId:   "plaid-leg",
+		Name:            "DWOLLA TRANSFER",
+		Amount:          50,
+		Date:            "2025-03-03",
+		CategoryPrimary: "TRANSFER_OUT",
+		MirrorsTransfer: true,
+	}
+	purchase := db.SyncedTransaction{
+		TransactionId:   "plaid-purchase",
+		Name:            "Grocer",
+		Amount:          20,
+		Date:            "2025-03-04",
+		CategoryPrimary: "FOOD_AND_DRINK",
+	}
+
+	var lines []StatementLine
+	lines = append(lines, transferActivityLines(transfer, transfer.SenderBankId)...)
+	lines = append(lines, plaidActivityLines(plaidLeg)...)
+	lines = append(lines, plaidActivityLines(purchase)...)
+
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want the transfer and the purchase only", len(lines))
+	}
+	totals := statementTotals(lines)
+	want := StatementTotals{Debits: 70, Net: -70, Count: 2}
+	if totals != want {
+		t.Errorf("statementTotals = %+v, want %+v", totals, want)
+	}
+}
+
+func TestStatementTotalsSkipUnsettledTransfers(t *testing.T) {
+	lines := []StatementLine{
+		{Source: ActivitySourceTransfer, Status: DwollaTransferFailed, Amount: -40},
+		{Source: ActivitySourceTransfer, Status: TransferOrphaned, Amount: -15},
+		{Source: ActivitySourceTransfer, Status: DwollaTransferPending, Amount: 25},
+		{Source: ActivitySourcePlaid, Amount: -10.5},
+	}
+	totals := statementTotals(lines)
+	want := StatementTotals{Credits: 25, Debits: 10.5, Net: 14.5, Count: 2}
+	if totals != want {
+		t.Errorf("statementTotals = %+v, want %+v", totals, want)
+	}
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+	"gorm.io/gorm"
+)
+
+type TransferLookupRequest struct {
+	UserId     string `json:"userId" binding:"required"`
+	TransferId string `json:"transferId" binding:"required"`
+}
+
+type TransferCancelRequest struct {
+	UserId        string `json:"userId" binding:"required"`
+	TransactionId string `json:"transactionId" binding:"required"`
+}
+
+// TransferNotCancellableError is returned when the transfer already left the
+// pending state.
+type TransferNotCancellableError struct {
+	Status string
+}
+
+func (e *TransferNotCancellableError) Error() string {
+	return fmt.Sprintf("transfer is %s and can no longer be cancelled", e.Status)
+}
+
+// TransferMovedMoney reports whether a transfer with the given status moved,
+// or may still move, money.
+func TransferMovedMoney(status string) bool {
+	return !slices.Contains(db.UnsettledTransferStatuses, status)
+}
+
+// ApplyTransferStatus stores the Dwolla status on the transaction and posts
+// the matching ledger journal in the same database transaction. The reason is
+// kept as the failure reason when the transfer failed, was cancelled or was
+// found orphaned by reconciliation.
+func ApplyTransferStatus(bankdb *gorm.DB, transaction db.Transaction, status string, reason string) error {
+	now := time.Now()
+	transaction.Status = status
+	transaction.StatusUpdatedAt = &now
+	switch status {
+	case DwollaTransferProcessed:
+		if transaction.ProcessedAt == nil {
+			transaction.ProcessedAt = &now
+		}
+	case DwollaTransferFailed, DwollaTransferCancelled, TransferOrphaned:
+		if transaction.FailedAt == nil {
+			transaction.FailedAt = &now
+		}
+		transaction.FailureReason = reason
+	}
+
+	return bankdb.Transaction(func(tx *gorm.DB) error {
+		if err := db.UpdateTransferStatus(tx, transaction); err != nil {
+			return err
+		}
+		if err := PostTransferInitiated(tx, transaction); err != nil {
+			return err
+		}
+		switch status {
+		case DwollaTransferProcessed:
+			return PostTransferSettled(tx, transaction)
+		case DwollaTransferFailed, DwollaTransferCancelled, TransferOrphaned:
+			if err := PostTransferReversed(tx, transaction, reason); err != nil {
+				return err
+			}
+			return PostFeeRefunded(tx, transaction)
+		}
+		return nil
+	})
+}
+
+// TransferFailureReason explains why a transfer did not complete. Dwolla only
+// records a return code for failed transfers.
+func TransferFailureReason(ctx context.Context, transfer DwollaTransfer) string {
+	switch transfer.Status {
+	case DwollaTransferFailed:
+		reason, err := RetrieveTransferFailure(ctx, transfer.Id)
+		if err != nil {
+			return "transfer failed"
+		}
+		return reason
+	case DwollaTransferCancelled:
+		return "transfer cancelled"
+	}
+	return ""
+}
+
+// RefreshTransferStatus pulls the current status and clearing of the
+// transaction's transfer from Dwolla and applies them when they changed.
+func RefreshTransferStatus(bankdb *gorm.DB, transaction db.Transaction) (db.Transaction, error) {
+	ctx := context.Background()
+	transfer, err := RetrieveTransfer(ctx, transaction.DwollaTransferId)
+	if err != nil {
+		return db.Transaction{}, err
+	}
+	actualClearing := ActualClearing(transfer.Clearing, transfer.ProcessingChannel)
+	if transfer.Status == transaction.Status && transaction.ActualClearing == actualClearing {
+		return transaction, nil
+	}
+	transaction.ActualClearing = actualClearing
+	if err := ApplyTransferStatus(bankdb, transaction, transfer.Status, TransferFailureReason(ctx, transfer)); err != nil {
+		return db.Transaction{}, err
+	}
+	return db.GetTransactionUsingId(bankdb, transaction.TransactionId)
+}
+
+func GetTransfer(c *gin.Context) {
+	var lookupReq TransferLookupRequest
+	if err := c.ShouldBindJSON(&lookupReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	transaction, err := db.GetTransactionUsingTransferId(PgDb, lookupReq.TransferId)
+	if err != nil || (transaction.SenderId != lookupReq.UserId && transaction.ReceiverId != lookupReq.UserId) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transfer not found for user"})
+		return
+	}
+
+	transaction, err = RefreshTransferStatus(PgDb, transaction)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": transaction})
+}
+
+// CancelPendingTransfer cancels the Dwolla transfer behind a pending
+// transaction and reverses it in the ledger.
+func CancelPendingTransfer(bankdb *gorm.DB, transaction db.Transaction) (db.Transaction, error) {
+	if transaction.Status != DwollaTransferPending {
+		return db.Transaction{}, &TransferNotCancellableError{Status: transaction.Status}
+	}
+	transferUrl := transaction.DwollaTransferUrl
+	if len(transferUrl) == 0 {
+		transferUrl = DwollaTransferUrl(transaction.DwollaTransferId)
+	}
+
+	ctx := context.Background()
+	transfer, err := CancelTransfer(ctx, transferUrl)
+	if err != nil {
+		// Dwolla refuses once the transfer moved on; report the state it is in.
+		refreshed, refreshErr := RefreshTransferStatus(bankdb, transaction)
+		if refreshErr == nil && refreshed.Status != DwollaTransferPending {
+			return db.Transaction{}, &TransferNotCancellableError{Status: refreshed.Status}
+		}
+		return db.Transaction{}, err
+	}
+	if transfer.Status != DwollaTransferCancelled {
+		return db.Transaction{}, &TransferNotCancellableError{Status: transfer.Status}
+	}
+
+	transaction.ActualClearing = ActualClearing(transfer.Clearing, transfer.ProcessingChannel)
+	if err := ApplyTransferStatus(bankdb, transaction, DwollaTransferCancelled, "cancelled by sender"); err != nil {
+		return db.Transaction{}, err
+	}
+	return db.GetTransactionUsingId(bankdb, transaction.TransactionId)
+}
+
+func CancelTransferPayment(c *gin.Context) {
+	var cancelReq TransferCancelRequest
+	if err := c.ShouldBindJSON(&cancelReq); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+
+	transaction, err := db.GetTransactionUsingId(PgDb, cancelReq.TransactionId)
+	if err != nil || len(transaction.TransactionId) == 0 || len(transaction.DwollaTransferId) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transfer not found"})
+		return
+	}
+	senderBank, err := db.GetRecordUsingTrackId(PgDb, transaction.SenderBankId)
+	if err != nil || senderBank.UserId != cancelReq.UserId {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the sender can cancel a transfer"})
+		return
+	}
+
+	transaction, err = CancelPendingTransfer(PgDb, transaction)
+	if err != nil {
+		log.Println(err.Error())
+		var notCancellable *TransferNotCancellableError
+		if errors.As(err, &notCancellable) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": notCancellable.Status})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer Cancelled Successfully", "data": transaction})
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlaidWebhookMaxAge is how old a signed webhook may be before it is refused
+// as a replay.
+var PlaidWebhookMaxAge = 5 * time.Minute
+
+var (
+	// PlaidWebhookKeyRefreshAfter is how long a cached key is trusted before
+	// Plaid is asked again whether it has expired.
+	PlaidWebhookKeyRefreshAfter = time.Hour
+	// PlaidWebhookKeyLookupInterval spaces out lookups of key ids that are not
+	// cached, since anyone can send a webhook naming a new key id. A failed
+	// lookup is remembered for the same interval.
+	PlaidWebhookKeyLookupInterval = 10 * time.Second
+)
+
+var (
+	webhookKeysMu       sync.Mutex
+	webhookKeys         = map[string]webhookKeyEntry{}
+	webhookKeyLastFetch time.Time
+)
+
+type webhookKeyEntry struct {
+	key       *ecdsa.PublicKey
+	expiredAt time.Time
+	fetchedAt time.Time
+	err       error
+}
+
+type webhookJwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type webhookJwtClaims struct {
+	IssuedAt          int64  `json:"iat"`
+	RequestBodySha256 string `json:"request_body_sha256"`
+}
+
+// webhookVerificationKey returns the key for a key id. Keys are cached with
+// their expiry and fetched again from Plaid once PlaidWebhookKeyRefreshAfter
+// has passed; expired keys are refused. Unknown key ids are looked up at most
+// once per PlaidWebhookKeyLookupInterval.
+func webhookVerificationKey(keyId string, now time.Time) (*ecdsa.PublicKey, error) {
+	webhookKeysMu.Lock()
+	entry, ok := webhookKeys[keyId]
+	switch {
+	case ok && entry.err != nil && now.Sub(entry.fetchedAt) < PlaidWebhookKeyLookupInterval:
+		webhookKeysMu.Unlock()
+		return nil, entry.err
+	case ok && entry.err == nil && now.Sub(entry.fetchedAt) < PlaidWebhookKeyRefreshAfter:
+		webhookKeysMu.Unlock()
+		return entry.usable(now)
+	case !ok && now.Sub(webhookKeyLastFetch) < PlaidWebhookKeyLookupInterval:
+		webhookKeysMu.Unlock()
+		return nil, errors.New("unknown webhook verification key")
+	}
+	webhookKeyLastFetch = now
+	webhookKeysMu.Unlock()
+
+	fetched := fetchWebhookKey(keyId, now)
+	if fetched.err != nil && ok && entry.err == nil {
+		// Plaid could not be asked again; keep using the known key until the
+		// next refresh.
+		log.Println("unable to refresh webhook verification key " + keyId + ": " + fetched.err.Error())
+		fetched = entry
+		fetched.fetchedAt = now
+	}
+	entry = fetched
+	webhookKeysMu.Lock()
+	webhookKeys[keyId] = entry
+	webhookKeysMu.Unlock()
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return entry.usable(now)
+}
+
+func (entry webhookKeyEntry) usable(now time.Time) (*ecdsa.PublicKey, error) {
+	if !entry.expiredAt.IsZero() && !now.Before(entry.expiredAt) {
+		return nil, errors.New("webhook verification key has expired")
+	}
+	return entry.key, nil
+}
+
+func fetchWebhookKey(keyId string, now time.Time) webhookKeyEntry {
+	entry := webhookKeyEntry{fetchedAt: now}
+	jwk, err := GetWebhookVerificationKey(keyId)
+	if err != nil {
+		entry.err = err
+		return entry
+	}
+	if jwk.GetKty() != "EC" || jwk.GetCrv() != "P-256" {
+		entry.err = fmt.Errorf("unsupported webhook verification key %s/%s", jwk.GetKty(), jwk.GetCrv())
+		return entry
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.GetX())
+	if err != nil {
+		entry.err = fmt.Errorf("invalid webhook verification key: %v", err.Error())
+		return entry
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.GetY())
+	if err != nil {
+		entry.err = fmt.Errorf("invalid webhook verification key: %v", err.Error())
+		return entry
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		entry.err = errors.New("invalid webhook verification key")
+		return entry
+	}
+	entry.key = key
+	if expiredAt := jwk.GetExpiredAt(); expiredAt != 0 {
+		entry.expiredAt = time.Unix(int64(expiredAt), 0)
+	}
+	return entry
+}
+
+// VerifyPlaidWebhook checks the Plaid-Verification header of a webhook: an
+// ES256 JWT signed by Plaid, issued recently, that carries the SHA-256 of the
+// exact request body.
+func VerifyPlaidWebhook(token string, body []byte, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("missing or malformed Plaid-Verification header")
+	}
+
+	var header webhookJwtHeader
+	if err := decodeJwtSegment(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Alg != "ES256" || len(header.Kid) == 0 {
+		return fmt.Errorf("unexpected webhook signature algorithm %q", header.Alg)
+	}
+	// The claims are not trusted until the signature checks out, but a stale
+	// token is refused before any key lookup.
+	var claims webhookJwtClaims
+	if err := decodeJwtSegment(parts[1], &claims); err != nil {
+		return err
+	}
+	if now.Sub(time.Unix(claims.IssuedAt, 0)) > PlaidWebhookMaxAge {
+		return errors.New("webhook is too old")
+	}
+	key, err := webhookVerificationKey(header.Kid, now)
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return errors.New("malformed webhook signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return errors.New("webhook signature does not verify")
+	}
+
+	bodyHash := sha256.Sum256(body)
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(bodyHash[:])), []byte(claims.RequestBodySha256)) != 1 {
+		return errors.New("webhook body does not match its signature")
+	}
+	return nil
+}
+
+func decodeJwtSegment(segment string, dest interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("malformed Plaid-Verification header: %v", err.Error())
+	}
+	if err := json.Unmarshal(decoded, dest); err != nil {
+		return fmt.Errorf("malformed Plaid-Verification header: %v", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logeshwarann-dev/bits-bank_plaid-service/db"
+)
+
+var PlaidWebhookUrl string
+
+type PlaidWebhook struct {
+	WebhookType string `json:"webhook_type"`
+	WebhookCode string `json:"webhook_code"`
+	ItemId      string `json:"item_id"`
+}
+
+// HandlePlaidWebhook acts on item updates from Plaid. The body is only trusted
+// once its Plaid-Verification signature checks out.
+func HandlePlaidWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	if err := VerifyPlaidWebhook(c.GetHeader("Plaid-Verification"), body, time.Now()); err != nil {
+		log.Println("rejected plaid webhook: " + err.Error())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "webhook verification failed"})
+		return
+	}
+
+	var webhook PlaidWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request - " + err.Error()})
+		return
+	}
+	log.Println("Plaid Webhook: ", webhook.WebhookType, webhook.WebhookCode, webhook.ItemId)
+
+	switch {
+	case webhook.WebhookType == "ITEM" && webhook.WebhookCode == "LOGIN_REPAIRED":
+		if err := db.MarkItemReauthenticated(PgDb, webhook.ItemId, time.Now()); err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	case webhook.WebhookType == "LIABILITIES" && webhook.WebhookCode == "DEFAULT_UPDATE",
+		webhook.WebhookType == "HOLDINGS" && webhook.WebhookCode == "DEFAULT_UPDATE",
+		webhook.WebhookType == "INVESTMENTS_TRANSACTIONS" && webhook.WebhookCode == "DEFAULT_UPDATE":
+		banks, err := db.GetRecordsUsingItemId(PgDb, webhook.ItemId)
+		if err != nil {
+			log.Println(err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, bank := range banks {
+			if IsFundingBank(bank) {
+				continue
+			}
+			if err := RefreshNetWorthItem(PgDb, bank); err != nil {
+				log.Println("unable to refresh " + bank.TrackId + ": " + err.Error())
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook Received"})
+}
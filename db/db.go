@@ -1,12 +1,16 @@
 package db
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -18,6 +22,22 @@ var (
 	DB_SSL  string
 )
 
+// UnsettledTransferStatuses are the transfer statuses under which no money
+// moved.
+var UnsettledTransferStatuses = []string{"failed", "cancelled", "orphaned"}
+
+// mirroredTransferSql matches a synced transaction, aliased s, that is the
+// bank's own leg of one of our transfers: a TRANSFER_IN or TRANSFER_OUT on
+// the same bank for the same amount, dated up to a week after the transfer.
+// It takes the named argument @unsettled.
+const mirroredTransferSql = `s.category_primary IN ('TRANSFER_IN', 'TRANSFER_OUT') AND EXISTS (
+	SELECT 1 FROM transactions x
+	WHERE CASE WHEN s.amount > 0 THEN x.sender_bank_id ELSE x.receiver_bank_id END = s.track_id
+		AND COALESCE(x.status, '') NOT IN @unsettled
+		AND CAST(x.amount AS numeric) = ABS(s.amount)
+		AND TO_DATE(SUBSTRING(x.transaction_id FROM 8 FOR 8), 'YYYYMMDD')
+			BETWEEN TO_DATE(s.date, 'YYYY-MM-DD') - 7 AND TO_DATE(s.date, 'YYYY-MM-DD'))`
+
 func ConnectToDB() *gorm.DB {
 
 	dsn := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=%s", DB_USER, DB_PWD, DB_NAME, DB_HOST, DB_PORT, DB_SSL)
@@ -28,9 +48,57 @@ func ConnectToDB() *gorm.DB {
 	}
 
 	log.Println("DB Connection Successful!")
+
+	if err := MigrateTables(gormDb); err != nil {
+		log.Fatal("Error migrating DB tables: ", err.Error())
+	}
 	return gormDb
 }
 
+func MigrateTables(bankdb *gorm.DB) error {
+	if err := bankdb.AutoMigrate(
+		&PlaidUser{},
+		&Transaction{},
+		&SyncedTransaction{},
+		&CategoryOverride{},
+		&Budget{},
+		&BudgetAlert{},
+		&RecurringStream{},
+		&ScheduledTransfer{},
+		&ScheduledTransferRun{},
+		&WorkerLease{},
+		&TransferLimit{},
+		&RiskDecision{},
+		&LedgerJournal{},
+		&LedgerPosting{},
+		&ReconciliationReport{},
+		&ReconciliationItem{},
+		&DwollaCustomer{},
+		&TransferQuote{},
+		&PaymentRequest{},
+		&BillSplit{},
+		&BillShare{},
+		&Payee{},
+		&BalanceSnapshot{},
+		&Statement{},
+		&BankIdentity{},
+		&AuthChallenge{},
+		&AccountNumberAccess{},
+		&Liability{},
+		&InvestmentHolding{},
+		&InvestmentTransaction{},
+	); err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while migrating tables: %v", err.Error())
+	}
+	// Banks linked before funding source ids were stored only have the URL.
+	if err := bankdb.Exec("UPDATE plaid_users SET funding_source_id = regexp_replace(funding_source_url, '^.*/', '') WHERE funding_source_id IS NULL OR funding_source_id = ''").Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while backfilling funding source ids: %v", err.Error())
+	}
+	return nil
+}
+
 func AddUser(bankdb *gorm.DB, plaidUser PlaidUser) error {
 	if err := bankdb.Create(&plaidUser).Error; err != nil {
 		log.Println(err.Error())
@@ -116,4 +184,1279 @@ func GetTransactionUsingReceiverBankId(bankdb *gorm.DB, receiverBankId string) (
 	return transaction, nil
 }
 
-// func GetTransaction
+func UpdateSyncCursor(bankdb *gorm.DB, trackId string, cursor string) error {
+	result := bankdb.Model(&PlaidUser{}).Where("track_id = ?", trackId).Update("sync_cursor", cursor)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while updating sync cursor: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func UpsertSyncedTransactions(bankdb *gorm.DB, transactions []SyncedTransaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+	if err := bankdb.Clauses(clause.OnConflict{UpdateAll: true}).Create(&transactions).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while saving synced transactions in db: %v", err.Error())
+	}
+	return nil
+}
+
+func DeleteSyncedTransactions(bankdb *gorm.DB, transactionIds []string) error {
+	if len(transactionIds) == 0 {
+		return nil
+	}
+	if err := bankdb.Where("transaction_id IN ?", transactionIds).Delete(&SyncedTransaction{}).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while removing synced transactions from db: %v", err.Error())
+	}
+	return nil
+}
+
+func GetSyncedTransactionUsingId(bankdb *gorm.DB, transactionId string) (SyncedTransaction, error) {
+	var transaction SyncedTransaction
+	result := bankdb.Where("transaction_id = ?", transactionId).First(&transaction)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return SyncedTransaction{}, errors.New("no records found")
+	}
+	return transaction, nil
+}
+
+func GetSyncedTransactionsUsingAccountId(bankdb *gorm.DB, accountId string) ([]SyncedTransaction, error) {
+	var transactions []SyncedTransaction
+	result := bankdb.Where("account_id = ?", accountId).Order("date desc").Find(&transactions)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []SyncedTransaction{}, errors.New("no records found")
+	}
+	return transactions, nil
+}
+
+func SaveCategoryOverride(bankdb *gorm.DB, override CategoryOverride) error {
+	if err := bankdb.Clauses(clause.OnConflict{UpdateAll: true}).Create(&override).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while saving category override in db: %v", err.Error())
+	}
+	return nil
+}
+
+func GetCategoryOverridesUsingUserId(bankdb *gorm.DB, userId string) (map[string]string, error) {
+	var overrides []CategoryOverride
+	result := bankdb.Where("user_id = ?", userId).Find(&overrides)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return map[string]string{}, errors.New("no records found")
+	}
+	categories := make(map[string]string, len(overrides))
+	for _, override := range overrides {
+		categories[override.TransactionId] = override.Category
+	}
+	return categories, nil
+}
+
+// spendEntries returns one row per money movement in the filter's window with a
+// uniform shape: Plaid amounts are positive for outflows, internal transfers
+// are signed from the point of view of the filtered user or account. Transfers
+// between two banks of the same user are left out so they do not count as
+// spend or income. A Plaid TRANSFER_* row that mirrors one of our own
+// transfers on the same bank, for the same amount and up to a week after it
+// was sent, is left out so the transfer is only counted once.
+func spendEntries(bankdb *gorm.DB, filter AnalyticsFilter) *gorm.DB {
+	syncedQuery := `
+		SELECT s.transaction_id, s.date, 'plaid' AS source,
+			COALESCE(o.category, NULLIF(s.category_primary, ''), 'OTHER') AS category,
+			COALESCE(NULLIF(s.merchant_name, ''), s.name) AS merchant, s.logo_url,
+			CASE WHEN s.amount > 0 THEN s.amount ELSE 0 END AS spend,
+			CASE WHEN s.amount < 0 THEN -s.amount ELSE 0 END AS income
+		FROM synced_transactions s
+		LEFT JOIN category_overrides o ON o.transaction_id = s.transaction_id AND o.user_id = s.user_id
+		WHERE s.user_id = @user AND s.date BETWEEN @start AND @end
+			AND (@account = '' OR s.account_id = @account)
+			AND NOT (` + mirroredTransferSql + `)`
+
+	transferQuery := `
+		SELECT t.transaction_id, t.date, 'transfer' AS source,
+			COALESCE(o.category, CASE WHEN t.outgoing THEN 'TRANSFER_OUT' ELSE 'TRANSFER_IN' END) AS category,
+			t.name AS merchant, '' AS logo_url,
+			CASE WHEN t.outgoing THEN t.amount ELSE 0 END AS spend,
+			CASE WHEN t.outgoing THEN 0 ELSE t.amount END AS income
+		FROM (
+			SELECT transaction_id, name, CAST(amount AS numeric) AS amount,
+				TO_CHAR(TO_DATE(SUBSTRING(transaction_id FROM 8 FOR 8), 'YYYYMMDD'), 'YYYY-MM-DD') AS date,
+				CASE WHEN @track = '' THEN sender_id = @user ELSE sender_bank_id = @track END AS outgoing
+			FROM transactions
+			WHERE sender_id <> receiver_id
+				AND COALESCE(status, '') NOT IN @unsettled
+				AND ((@track = '' AND (sender_id = @user OR receiver_id = @user))
+					OR (@track <> '' AND (sender_bank_id = @track OR receiver_bank_id = @track)))
+		) t
+		LEFT JOIN category_overrides o ON o.transaction_id = t.transaction_id AND o.user_id = @user
+		WHERE t.date BETWEEN @start AND @end`
+
+	return bankdb.Raw(syncedQuery+" UNION ALL "+transferQuery, map[string]interface{}{
+		"user":      filter.UserId,
+		"track":     filter.TrackId,
+		"account":   filter.AccountId,
+		"start":     filter.StartDate,
+		"end":       filter.EndDate,
+		"unsettled": UnsettledTransferStatuses,
+	})
+}
+
+func GetSpendByCategory(bankdb *gorm.DB, filter AnalyticsFilter) ([]CategorySummary, error) {
+	var summaries []CategorySummary
+	result := bankdb.Table("(?) AS entries", spendEntries(bankdb, filter)).
+		Select("category, SUM(spend) AS spend, SUM(income) AS income, COUNT(*) AS count").
+		Group("category").
+		Order("spend DESC, income DESC").
+		Scan(&summaries)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []CategorySummary{}, fmt.Errorf("error while aggregating spend by category: %v", result.Error.Error())
+	}
+	return summaries, nil
+}
+
+func GetTopMerchants(bankdb *gorm.DB, filter AnalyticsFilter, limit int) ([]MerchantSummary, error) {
+	var summaries []MerchantSummary
+	result := bankdb.Table("(?) AS entries", spendEntries(bankdb, filter)).
+		Select("merchant, MAX(logo_url) AS logo_url, SUM(spend) AS spend, COUNT(*) AS count").
+		Where("source = ? AND spend > 0", "plaid").
+		Group("merchant").
+		Order("spend DESC").
+		Limit(limit).
+		Scan(&summaries)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []MerchantSummary{}, fmt.Errorf("error while aggregating top merchants: %v", result.Error.Error())
+	}
+	return summaries, nil
+}
+
+func GetSpendByMonth(bankdb *gorm.DB, filter AnalyticsFilter) ([]PeriodSummary, error) {
+	var summaries []PeriodSummary
+	result := bankdb.Table("(?) AS entries", spendEntries(bankdb, filter)).
+		Select("SUBSTRING(date FROM 1 FOR 7) AS period, SUM(spend) AS spend, SUM(income) AS income").
+		Group("period").
+		Order("period").
+		Scan(&summaries)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []PeriodSummary{}, fmt.Errorf("error while aggregating spend by month: %v", result.Error.Error())
+	}
+	return summaries, nil
+}
+
+func CreateBudget(bankdb *gorm.DB, budget Budget) error {
+	if err := bankdb.Create(&budget).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while adding budget in db: %v", err.Error())
+	}
+	return nil
+}
+
+func GetBudgetUsingId(bankdb *gorm.DB, budgetId string) (Budget, error) {
+	var budget Budget
+	result := bankdb.Where("budget_id = ?", budgetId).First(&budget)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return Budget{}, errors.New("no records found")
+	}
+	return budget, nil
+}
+
+func GetBudgetsUsingUserId(bankdb *gorm.DB, userId string) ([]Budget, error) {
+	var budgets []Budget
+	result := bankdb.Where("user_id = ?", userId).Order("created_at").Find(&budgets)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []Budget{}, errors.New("no records found")
+	}
+	return budgets, nil
+}
+
+func UpdateBudget(bankdb *gorm.DB, budget Budget) error {
+	if err := bankdb.Save(&budget).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while updating budget in db: %v", err.Error())
+	}
+	return nil
+}
+
+func DeleteBudget(bankdb *gorm.DB, budgetId string) error {
+	return bankdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("budget_id = ?", budgetId).Delete(&BudgetAlert{}).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while deleting budget alerts from db: %v", err.Error())
+		}
+		if err := tx.Where("budget_id = ?", budgetId).Delete(&Budget{}).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while deleting budget from db: %v", err.Error())
+		}
+		return nil
+	})
+}
+
+// AddBudgetAlert records a threshold crossing and reports whether it is new, so
+// each threshold alerts at most once per budget period.
+func AddBudgetAlert(bankdb *gorm.DB, alert BudgetAlert) (bool, error) {
+	result := bankdb.Clauses(clause.OnConflict{DoNothing: true}).Create(&alert)
+	if result.Error != nil {
+		log.Println(result.Error.Error())
+		return false, fmt.Errorf("error while adding budget alert in db: %v", result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func GetBudgetAlertsUsingUserId(bankdb *gorm.DB, userId string) ([]BudgetAlert, error) {
+	var alerts []BudgetAlert
+	result := bankdb.Where("user_id = ?", userId).Order("created_at desc").Find(&alerts)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []BudgetAlert{}, errors.New("no records found")
+	}
+	return alerts, nil
+}
+
+func GetTransactionsUsingUserId(bankdb *gorm.DB, userId string) ([]Transaction, error) {
+	var transactions []Transaction
+	result := bankdb.Where("sender_id = ? OR receiver_id = ?", userId, userId).Order("transaction_id").Find(&transactions)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []Transaction{}, errors.New("no records found")
+	}
+	return transactions, nil
+}
+
+// ReplaceRecurringStreams swaps the streams previously stored for the user and
+// source (optionally narrowed to one bank) with the freshly detected ones.
+func ReplaceRecurringStreams(bankdb *gorm.DB, userId string, source string, trackId string, streams []RecurringStream) error {
+	return bankdb.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("user_id = ? AND source = ?", userId, source)
+		if len(trackId) > 0 {
+			query = query.Where("track_id = ?", trackId)
+		}
+		if err := query.Delete(&RecurringStream{}).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while clearing recurring streams: %v", err.Error())
+		}
+		if len(streams) == 0 {
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&streams).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while saving recurring streams: %v", err.Error())
+		}
+		return nil
+	})
+}
+
+func GetRecurringStreamsUsingUserId(bankdb *gorm.DB, userId string) ([]RecurringStream, error) {
+	var streams []RecurringStream
+	result := bankdb.Where("user_id = ?", userId).Order("is_active desc, average_amount desc").Find(&streams)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []RecurringStream{}, errors.New("no records found")
+	}
+	return streams, nil
+}
+
+// AcquireLease takes or renews the named lease for the holder. It succeeds only
+// when the lease is free, expired or already held by the same holder, which
+// makes it usable for leader election between replicas.
+func AcquireLease(bankdb *gorm.DB, name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	lease := WorkerLease{
+		Name:      name,
+		Holder:    holder,
+		ExpiresAt: now.Add(ttl),
+	}
+	result := bankdb.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"holder", "expires_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Or(
+				clause.Eq{Column: clause.Column{Table: "worker_leases", Name: "holder"}, Value: holder},
+				clause.Lt{Column: clause.Column{Table: "worker_leases", Name: "expires_at"}, Value: now},
+			),
+		}},
+	}).Create(&lease)
+	if result.Error != nil {
+		log.Println(result.Error.Error())
+		return false, fmt.Errorf("error while acquiring lease %s: %v", name, result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func CreateScheduledTransfer(bankdb *gorm.DB, schedule ScheduledTransfer) error {
+	if err := bankdb.Create(&schedule).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while adding scheduled transfer in db: %v", err.Error())
+	}
+	return nil
+}
+
+func UpdateScheduledTransfer(bankdb *gorm.DB, schedule ScheduledTransfer) error {
+	if err := bankdb.Save(&schedule).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while updating scheduled transfer in db: %v", err.Error())
+	}
+	return nil
+}
+
+// AdvanceScheduledTransfer moves an active schedule to its next occurrence.
+// Only the fields the worker owns are written, and a schedule that was paused
+// or cancelled while it ran is left alone.
+func AdvanceScheduledTransfer(bankdb *gorm.DB, scheduleId string, nextRunDate string, status string, updatedAt time.Time) error {
+	result := bankdb.Model(&ScheduledTransfer{}).Where("schedule_id = ? AND status = ?", scheduleId, "active").Updates(map[string]interface{}{
+		"next_run_date": nextRunDate,
+		"status":        status,
+		"updated_at":    updatedAt,
+	})
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while advancing scheduled transfer: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func GetScheduledTransferUsingId(bankdb *gorm.DB, scheduleId string) (ScheduledTransfer, error) {
+	var schedule ScheduledTransfer
+	result := bankdb.Where("schedule_id = ?", scheduleId).First(&schedule)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return ScheduledTransfer{}, errors.New("no records found")
+	}
+	return schedule, nil
+}
+
+func GetScheduledTransfersUsingUserId(bankdb *gorm.DB, userId string) ([]ScheduledTransfer, error) {
+	var schedules []ScheduledTransfer
+	result := bankdb.Where("user_id = ?", userId).Order("created_at desc").Find(&schedules)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []ScheduledTransfer{}, errors.New("no records found")
+	}
+	return schedules, nil
+}
+
+func GetDueScheduledTransfers(bankdb *gorm.DB, status string, date string) ([]ScheduledTransfer, error) {
+	var schedules []ScheduledTransfer
+	result := bankdb.Where("status = ? AND next_run_date <> '' AND next_run_date <= ?", status, date).Order("next_run_date").Find(&schedules)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []ScheduledTransfer{}, errors.New("no records found")
+	}
+	return schedules, nil
+}
+
+// StartScheduledTransferRun claims one occurrence of a schedule. It reports
+// false when the occurrence was already claimed, so each occurrence executes
+// at most once even if the worker retries.
+func StartScheduledTransferRun(bankdb *gorm.DB, run ScheduledTransferRun) (bool, error) {
+	result := bankdb.Clauses(clause.OnConflict{DoNothing: true}).Create(&run)
+	if result.Error != nil {
+		log.Println(result.Error.Error())
+		return false, fmt.Errorf("error while adding scheduled transfer run in db: %v", result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func FinishScheduledTransferRun(bankdb *gorm.DB, runId string, status string, transactionId string, failureReason string) error {
+	finishedAt := time.Now()
+	result := bankdb.Model(&ScheduledTransferRun{}).Where("run_id = ?", runId).Updates(map[string]interface{}{
+		"status":         status,
+		"transaction_id": transactionId,
+		"failure_reason": failureReason,
+		"finished_at":    &finishedAt,
+	})
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while updating scheduled transfer run: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func GetScheduledTransferRunUsingOccurrence(bankdb *gorm.DB, scheduleId string, occurrenceDate string) (ScheduledTransferRun, error) {
+	var run ScheduledTransferRun
+	result := bankdb.Where("schedule_id = ? AND occurrence_date = ?", scheduleId, occurrenceDate).First(&run)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return ScheduledTransferRun{}, errors.New("no records found")
+	}
+	return run, nil
+}
+
+func GetScheduledTransferRuns(bankdb *gorm.DB, scheduleId string) ([]ScheduledTransferRun, error) {
+	var runs []ScheduledTransferRun
+	result := bankdb.Where("schedule_id = ?", scheduleId).Order("occurrence_date desc").Find(&runs)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []ScheduledTransferRun{}, errors.New("no records found")
+	}
+	return runs, nil
+}
+
+func GetTransferLimit(bankdb *gorm.DB, userId string) (TransferLimit, error) {
+	var limit TransferLimit
+	result := bankdb.Where("user_id = ?", userId).First(&limit)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return TransferLimit{}, errors.New("no records found")
+	}
+	return limit, nil
+}
+
+func SaveTransferLimit(bankdb *gorm.DB, limit TransferLimit) error {
+	if err := bankdb.Save(&limit).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while saving transfer limit in db: %v", err.Error())
+	}
+	return nil
+}
+
+// WithTransferLock runs fn while holding a per-user advisory lock, so the
+// limit checks of one transfer and the row it creates cannot interleave with
+// another transfer of the same user. The lock is held on its own connection
+// and fn uses the pool as usual.
+func WithTransferLock(bankdb *gorm.DB, userId string, fn func() error) error {
+	return bankdb.Connection(func(conn *gorm.DB) error {
+		key := "transfer:" + userId
+		if err := conn.Exec("SELECT pg_advisory_lock(hashtext(?))", key).Error; err != nil {
+			log.Println("Error: ", err)
+			return fmt.Errorf("error while locking transfers of user: %v", err.Error())
+		}
+		defer func() {
+			if err := conn.Exec("SELECT pg_advisory_unlock(hashtext(?))", key).Error; err != nil {
+				log.Println("Error: ", err)
+			}
+		}()
+		return fn()
+	})
+}
+
+// GetOutgoingTransferTotals sums the transfers sent by the user since the given
+// time, leaving out the ones that failed or were cancelled. Transaction ids
+// start with TRANSCT and the creation timestamp, so the window is a range over
+// the id.
+func GetOutgoingTransferTotals(bankdb *gorm.DB, userId string, since time.Time) (float64, int, error) {
+	var totals struct {
+		Total float64
+		Count int
+	}
+	result := bankdb.Model(&Transaction{}).
+		Select("COALESCE(SUM(CAST(amount AS numeric)), 0) AS total, COUNT(*) AS count").
+		Where("sender_id = ? AND transaction_id >= ?", userId, "TRANSCT"+since.Format("20060102150405")).
+		Where("COALESCE(status, '') NOT IN ?", []string{"failed", "cancelled"}).
+		Scan(&totals)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return 0, 0, fmt.Errorf("error while summing outgoing transfers: %v", result.Error.Error())
+	}
+	return totals.Total, totals.Count, nil
+}
+
+func MarkItemReauthenticated(bankdb *gorm.DB, itemId string, reauthAt time.Time) error {
+	result := bankdb.Model(&PlaidUser{}).Where("bank_id = ?", itemId).Update("reauth_at", &reauthAt)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while marking item re-authenticated: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func CountTransfersBetween(bankdb *gorm.DB, senderId string, receiverBankId string) (int64, error) {
+	var count int64
+	result := bankdb.Model(&Transaction{}).Where("sender_id = ? AND receiver_bank_id = ?", senderId, receiverBankId).Count(&count)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return 0, fmt.Errorf("error while counting transfers: %v", result.Error.Error())
+	}
+	return count, nil
+}
+
+func GetOutgoingTransferAmounts(bankdb *gorm.DB, senderId string) ([]string, error) {
+	var amounts []string
+	result := bankdb.Model(&Transaction{}).Where("sender_id = ?", senderId).Pluck("amount", &amounts)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []string{}, fmt.Errorf("error while fetching transfer amounts: %v", result.Error.Error())
+	}
+	return amounts, nil
+}
+
+func AddRiskDecision(bankdb *gorm.DB, decision RiskDecision) error {
+	if err := bankdb.Create(&decision).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while adding risk decision in db: %v", err.Error())
+	}
+	return nil
+}
+
+func GetRiskDecisionUsingId(bankdb *gorm.DB, decisionId string) (RiskDecision, error) {
+	var decision RiskDecision
+	result := bankdb.Where("decision_id = ?", decisionId).First(&decision)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return RiskDecision{}, errors.New("no records found")
+	}
+	return decision, nil
+}
+
+func SetRiskDecisionScheduledRun(bankdb *gorm.DB, decisionId string, runId string) error {
+	result := bankdb.Model(&RiskDecision{}).Where("decision_id = ?", decisionId).Update("scheduled_run_id", runId)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while linking risk decision to scheduled run: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func GetRiskDecisionsUsingReviewStatus(bankdb *gorm.DB, reviewStatus string) ([]RiskDecision, error) {
+	var decisions []RiskDecision
+	result := bankdb.Where("review_status = ?", reviewStatus).Order("created_at").Find(&decisions)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []RiskDecision{}, errors.New("no records found")
+	}
+	return decisions, nil
+}
+
+// UpdateRiskDecisionReview moves a decision out of the given review status. It
+// reports false when another reviewer already changed it.
+func UpdateRiskDecisionReview(bankdb *gorm.DB, decisionId string, fromStatus string, decision RiskDecision) (bool, error) {
+	result := bankdb.Model(&RiskDecision{}).Where("decision_id = ? AND review_status = ?", decisionId, fromStatus).Updates(map[string]interface{}{
+		"review_status":  decision.ReviewStatus,
+		"transaction_id": decision.TransactionId,
+		"reviewed_by":    decision.ReviewedBy,
+		"review_note":    decision.ReviewNote,
+		"reviewed_at":    decision.ReviewedAt,
+	})
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return false, fmt.Errorf("error while updating risk decision: %v", result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// AddJournal writes a journal and its postings. Postings must sum to zero; the
+// check is done in cents so float rounding cannot hide an imbalance. A
+// transaction has at most one journal per entry type, so when a concurrent
+// caller already posted it nothing is written.
+func AddJournal(bankdb *gorm.DB, journal LedgerJournal, postings []LedgerPosting) error {
+	var totalCents int64
+	for _, posting := range postings {
+		totalCents += int64(math.Round(posting.Amount * 100))
+	}
+	if len(postings) < 2 || totalCents != 0 {
+		return fmt.Errorf("journal %s does not balance: %d postings totalling %d cents", journal.JournalId, len(postings), totalCents)
+	}
+
+	return bankdb.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&journal)
+		if result.Error != nil {
+			log.Println(result.Error.Error())
+			return fmt.Errorf("error while adding ledger journal in db: %v", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		if err := tx.Create(&postings).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while adding ledger postings in db: %v", err.Error())
+		}
+		return nil
+	})
+}
+
+func GetJournalsUsingTransactionId(bankdb *gorm.DB, transactionId string) ([]LedgerJournal, error) {
+	var journals []LedgerJournal
+	result := bankdb.Where("transaction_id = ?", transactionId).Order("created_at").Find(&journals)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []LedgerJournal{}, errors.New("no records found")
+	}
+	return journals, nil
+}
+
+// GetLedgerBalances sums postings per ledger account. The pending balance only
+// counts postings of journals in the pending state whose transaction has not
+// been settled or reversed yet.
+func GetLedgerBalances(bankdb *gorm.DB, ledgerAccounts []string, pendingState string, resolvingEntryTypes []string) ([]LedgerBalance, error) {
+	var balances []LedgerBalance
+	result := bankdb.Table("ledger_postings p").
+		Select(`p.ledger_account, SUM(p.amount) AS balance,
+			COALESCE(SUM(CASE WHEN p.state = ? AND NOT EXISTS (
+				SELECT 1 FROM ledger_journals r
+				WHERE r.transaction_id = j.transaction_id AND r.entry_type IN ?
+			) THEN p.amount ELSE 0 END), 0) AS pending_balance`, pendingState, resolvingEntryTypes).
+		Joins("JOIN ledger_journals j ON j.journal_id = p.journal_id").
+		Where("p.ledger_account IN ?", ledgerAccounts).
+		Group("p.ledger_account").
+		Scan(&balances)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []LedgerBalance{}, fmt.Errorf("error while computing ledger balances: %v", result.Error.Error())
+	}
+	return balances, nil
+}
+
+func GetUnbalancedJournals(bankdb *gorm.DB) ([]UnbalancedJournal, error) {
+	var journals []UnbalancedJournal
+	result := bankdb.Table("ledger_journals j").
+		Select("j.journal_id, COALESCE(SUM(p.amount), 0) AS total").
+		Joins("LEFT JOIN ledger_postings p ON p.journal_id = j.journal_id").
+		Group("j.journal_id").
+		Having("COALESCE(SUM(p.amount), 0) <> 0 OR COUNT(p.posting_id) < 2").
+		Scan(&journals)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []UnbalancedJournal{}, fmt.Errorf("error while checking ledger journals: %v", result.Error.Error())
+	}
+	return journals, nil
+}
+
+func GetTransactionsWithoutJournal(bankdb *gorm.DB) ([]Transaction, error) {
+	var transactions []Transaction
+	result := bankdb.Where("NOT EXISTS (SELECT 1 FROM ledger_journals j WHERE j.transaction_id = transactions.transaction_id)").Find(&transactions)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []Transaction{}, errors.New("no records found")
+	}
+	return transactions, nil
+}
+
+func GetAllRecords(bankdb *gorm.DB) ([]PlaidUser, error) {
+	var accounts []PlaidUser
+	result := bankdb.Find(&accounts)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []PlaidUser{}, errors.New("no records found")
+	}
+	return accounts, nil
+}
+
+func GetTransactionUsingTransferId(bankdb *gorm.DB, transferId string) (Transaction, error) {
+	var transaction Transaction
+	result := bankdb.Where("dwolla_transfer_id = ?", transferId).First(&transaction)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return Transaction{}, errors.New("no records found")
+	}
+	return transaction, nil
+}
+
+// GetTransactionsCreatedBetween uses the timestamp embedded in transaction ids
+// to select the transfers created in the window.
+func GetTransactionsCreatedBetween(bankdb *gorm.DB, start time.Time, end time.Time) ([]Transaction, error) {
+	var transactions []Transaction
+	result := bankdb.Where("transaction_id >= ? AND transaction_id < ?",
+		"TRANSCT"+start.Format("20060102150405"), "TRANSCT"+end.Format("20060102150405")).
+		Order("transaction_id").Find(&transactions)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []Transaction{}, errors.New("no records found")
+	}
+	return transactions, nil
+}
+
+func GetRecordUsingFundingSourceId(bankdb *gorm.DB, fundingSourceId string) (PlaidUser, error) {
+	var user PlaidUser
+	result := bankdb.Where("funding_source_id = ?", fundingSourceId).First(&user)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return PlaidUser{}, errors.New("no records found")
+	}
+	return user, nil
+}
+
+func GetAllRecordUsingDwollaCustomerId(bankdb *gorm.DB, customerId string) ([]PlaidUser, error) {
+	var accounts []PlaidUser
+	result := bankdb.Where("dwolla_customer_id = ?", customerId).Find(&accounts)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []PlaidUser{}, errors.New("no records found")
+	}
+	return accounts, nil
+}
+
+func GetTransactionsUsingFundingSourceId(bankdb *gorm.DB, fundingSourceId string) ([]Transaction, error) {
+	var transactions []Transaction
+	result := bankdb.Where("source_funding_source_id = ? OR dest_funding_source_id = ?", fundingSourceId, fundingSourceId).
+		Order("transaction_id desc").Find(&transactions)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return []Transaction{}, errors.New("no records found")
+	}
+	return transactions, nil
+}
+
+// UpdateTransferStatus saves the Dwolla status fields of a transaction.
+func UpdateTransferStatus(bankdb *gorm.DB, transaction Transaction) error {
+	result := bankdb.Model(&Transaction{}).Where("transaction_id = ?", transaction.TransactionId).
+		Select("status", "status_updated_at", "processed_at", "failed_at", "failure_reason", "actual_clearing").
+		Updates(&transaction)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while updating transfer status: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func AddReconciliationReport(bankdb *gorm.DB, report ReconciliationReport, items []ReconciliationItem) error {
+	return bankdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&report).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while adding reconciliation report in db: %v", err.Error())
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		if err := tx.Create(&items).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while adding reconciliation items in db: %v", err.Error())
+		}
+		return nil
+	})
+}
+
+// AddDwollaCustomer stores the customer unless the user or the customer is
+// already recorded, and reports whether a row was added.
+func AddDwollaCustomer(bankdb *gorm.DB, customer DwollaCustomer) (bool, error) {
+	result := bankdb.Clauses(clause.OnConflict{DoNothing: true}).Create(&customer)
+	if result.Error != nil {
+		log.Println(result.Error.Error())
+		return false, fmt.Errorf("error while adding dwolla customer in db: %v", result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func GetDwollaCustomerUsingUserId(bankdb *gorm.DB, userId string) (DwollaCustomer, error) {
+	var customer DwollaCustomer
+	result := bankdb.Where("user_id = ?", userId).First(&customer)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return DwollaCustomer{}, errors.New("no records found")
+	}
+	return customer, nil
+}
+
+func GetDwollaCustomerUsingId(bankdb *gorm.DB, customerId string) (DwollaCustomer, error) {
+	var customer DwollaCustomer
+	result := bankdb.Where("customer_id = ?", customerId).First(&customer)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return DwollaCustomer{}, errors.New("no records found")
+	}
+	return customer, nil
+}
+
+func UpdateDwollaCustomerStatus(bankdb *gorm.DB, customerId string, customerType string, status string) error {
+	result := bankdb.Model(&DwollaCustomer{}).Where("customer_id = ?", customerId).
+		Updates(map[string]interface{}{"type": customerType, "status": status, "updated_at": time.Now()})
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while updating dwolla customer status: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func UpdateDwollaCustomerAddress(bankdb *gorm.DB, customerId string, address1 string, postalCode string) error {
+	result := bankdb.Model(&DwollaCustomer{}).Where("customer_id = ?", customerId).
+		Updates(map[string]interface{}{"address1": address1, "postal_code": postalCode, "updated_at": time.Now()})
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while updating dwolla customer address: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func UpdateFundingSourceVerification(bankdb *gorm.DB, trackId string, status string) error {
+	result := bankdb.Model(&PlaidUser{}).Where("track_id = ?", trackId).Update("verification_status", status)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while updating funding source verification: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func AddTransferQuote(bankdb *gorm.DB, quote TransferQuote) error {
+	if err := bankdb.Create(&quote).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while adding transfer quote in db: %v", err.Error())
+	}
+	return nil
+}
+
+func GetTransferQuoteUsingId(bankdb *gorm.DB, quoteId string) (TransferQuote, error) {
+	var quote TransferQuote
+	result := bankdb.Where("quote_id = ?", quoteId).First(&quote)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return TransferQuote{}, errors.New("no records found")
+	}
+	return quote, nil
+}
+
+// ClaimTransferQuote moves an unexpired quote from fromStatus to toStatus and
+// reports whether this caller won it, so a quote is executed at most once.
+func ClaimTransferQuote(bankdb *gorm.DB, quoteId string, userId string, fromStatus string, toStatus string, now time.Time) (bool, error) {
+	result := bankdb.Model(&TransferQuote{}).
+		Where("quote_id = ? AND user_id = ? AND status = ? AND expires_at > ?", quoteId, userId, fromStatus, now).
+		Updates(map[string]interface{}{"status": toStatus, "confirmed_at": now})
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return false, fmt.Errorf("error while claiming transfer quote: %v", result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func FinishTransferQuote(bankdb *gorm.DB, quoteId string, status string, transactionId string, note string) error {
+	result := bankdb.Model(&TransferQuote{}).Where("quote_id = ?", quoteId).
+		Updates(map[string]interface{}{"status": status, "transaction_id": transactionId, "note": note})
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while updating transfer quote: %v", result.Error.Error())
+	}
+	return nil
+}
+
+// HoldTransferQuote parks a confirmed quote whose transfer is held for review
+// under the risk decision.
+func HoldTransferQuote(bankdb *gorm.DB, quoteId string, status string, decisionId string) error {
+	result := bankdb.Model(&TransferQuote{}).Where("quote_id = ?", quoteId).
+		Updates(map[string]interface{}{"status": status, "decision_id": decisionId})
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while holding transfer quote: %v", result.Error.Error())
+	}
+	return nil
+}
+
+func GetTransferQuoteUsingDecisionId(bankdb *gorm.DB, decisionId string) (TransferQuote, error) {
+	var quote TransferQuote
+	result := bankdb.Where("decision_id = ?", decisionId).First(&quote)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return TransferQuote{}, errors.New("no records found")
+	}
+	return quote, nil
+}
+
+func AddPaymentRequest(bankdb *gorm.DB, request PaymentRequest) error {
+	if err := bankdb.Create(&request).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while adding payment request in db: %v", err.Error())
+	}
+	return nil
+}
+
+func GetPaymentRequestUsingId(bankdb *gorm.DB, requestId string) (PaymentRequest, error) {
+	var request PaymentRequest
+	result := bankdb.Where("request_id = ?", requestId).First(&request)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return PaymentRequest{}, errors.New("no records found")
+	}
+	return request, nil
+}
+
+func GetPaymentRequestUsingDecisionId(bankdb *gorm.DB, decisionId string) (PaymentRequest, error) {
+	var request PaymentRequest
+	result := bankdb.Where("decision_id = ?", decisionId).First(&request)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return PaymentRequest{}, errors.New("no records found")
+	}
+	return request, nil
+}
+
+// GetPaymentRequestsUsingUserId returns the requests the user must pay and
+// the requests the user sent, newest first.
+func GetPaymentRequestsUsingUserId(bankdb *gorm.DB, userId string, statuses []string) ([]PaymentRequest, []PaymentRequest, error) {
+	var incoming, outgoing []PaymentRequest
+	if err := bankdb.Where("payer_id = ? AND status IN ?", userId, statuses).Order("created_at desc").Find(&incoming).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, nil, errors.New("no records found")
+	}
+	if err := bankdb.Where("requester_id = ? AND status IN ?", userId, statuses).Order("created_at desc").Find(&outgoing).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, nil, errors.New("no records found")
+	}
+	return incoming, outgoing, nil
+}
+
+// UpdatePaymentRequestStatus moves a request out of fromStatus and reports
+// whether this caller made the change. Pending requests past their expiry
+// cannot be claimed.
+func UpdatePaymentRequestStatus(bankdb *gorm.DB, requestId string, fromStatus string, updates map[string]interface{}, now time.Time) (bool, error) {
+	result := bankdb.Model(&PaymentRequest{}).
+		Where("request_id = ? AND status = ? AND expires_at > ?", requestId, fromStatus, now).
+		Updates(updates)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return false, fmt.Errorf("error while updating payment request: %v", result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func FinishPaymentRequest(bankdb *gorm.DB, requestId string, updates map[string]interface{}) error {
+	result := bankdb.Model(&PaymentRequest{}).Where("request_id = ?", requestId).Updates(updates)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return fmt.Errorf("error while updating payment request: %v", result.Error.Error())
+	}
+	return nil
+}
+
+// ExpirePaymentRequests marks pending requests past their expiry as expired
+// and returns them.
+func ExpirePaymentRequests(bankdb *gorm.DB, pendingStatus string, expiredStatus string, now time.Time) ([]PaymentRequest, error) {
+	var expired []PaymentRequest
+	result := bankdb.Model(&expired).Clauses(clause.Returning{}).
+		Where("status = ? AND expires_at <= ?", pendingStatus, now).
+		Update("status", expiredStatus)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return nil, fmt.Errorf("error while expiring payment requests: %v", result.Error.Error())
+	}
+	return expired, nil
+}
+
+// AddBillSplit stores a bill with its shares and payment requests together.
+func AddBillSplit(bankdb *gorm.DB, bill BillSplit, shares []BillShare, requests []PaymentRequest) error {
+	return bankdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&bill).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while adding bill split in db: %v", err.Error())
+		}
+		if len(requests) > 0 {
+			if err := tx.Create(&requests).Error; err != nil {
+				log.Println(err.Error())
+				return fmt.Errorf("error while adding payment requests in db: %v", err.Error())
+			}
+		}
+		if err := tx.Create(&shares).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while adding bill shares in db: %v", err.Error())
+		}
+		return nil
+	})
+}
+
+func GetBillSplitUsingId(bankdb *gorm.DB, billId string) (BillSplit, error) {
+	var bill BillSplit
+	result := bankdb.Where("bill_id = ?", billId).First(&bill)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return BillSplit{}, errors.New("no records found")
+	}
+	return bill, nil
+}
+
+func GetBillSplitsUsingCreatorId(bankdb *gorm.DB, creatorId string) ([]BillSplit, error) {
+	var bills []BillSplit
+	if err := bankdb.Where("creator_id = ?", creatorId).Order("created_at desc").Find(&bills).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, errors.New("no records found")
+	}
+	return bills, nil
+}
+
+func GetBillSharesUsingBillIds(bankdb *gorm.DB, billIds []string) ([]BillShare, error) {
+	var shares []BillShare
+	if err := bankdb.Where("bill_id IN ?", billIds).Find(&shares).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, errors.New("no records found")
+	}
+	return shares, nil
+}
+
+func GetPaymentRequestsUsingBillIds(bankdb *gorm.DB, billIds []string) ([]PaymentRequest, error) {
+	var requests []PaymentRequest
+	if err := bankdb.Where("bill_id IN ?", billIds).Find(&requests).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, errors.New("no records found")
+	}
+	return requests, nil
+}
+
+// AddPayee saves a payee and reports false when the user already saved the
+// same receiver bank.
+func AddPayee(bankdb *gorm.DB, payee Payee) (bool, error) {
+	result := bankdb.Clauses(clause.OnConflict{DoNothing: true}).Create(&payee)
+	if result.Error != nil {
+		log.Println(result.Error.Error())
+		return false, fmt.Errorf("error while adding payee in db: %v", result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func GetPayeeUsingId(bankdb *gorm.DB, payeeId string) (Payee, error) {
+	var payee Payee
+	result := bankdb.Where("payee_id = ?", payeeId).First(&payee)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return Payee{}, errors.New("no records found")
+	}
+	return payee, nil
+}
+
+func GetPayeesUsingUserId(bankdb *gorm.DB, userId string) ([]Payee, error) {
+	var payees []Payee
+	if err := bankdb.Where("user_id = ?", userId).Order("nickname").Find(&payees).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, errors.New("no records found")
+	}
+	return payees, nil
+}
+
+func DeletePayee(bankdb *gorm.DB, payeeId string) error {
+	if err := bankdb.Where("payee_id = ?", payeeId).Delete(&Payee{}).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while deleting payee from db: %v", err.Error())
+	}
+	return nil
+}
+
+// SaveBalanceSnapshot keeps the latest balance read for the bank on the
+// snapshot's date.
+func SaveBalanceSnapshot(bankdb *gorm.DB, snapshot BalanceSnapshot) error {
+	if err := bankdb.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "track_id"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"current_balance", "available_balance", "captured_at"}),
+	}).Create(&snapshot).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while saving balance snapshot in db: %v", err.Error())
+	}
+	return nil
+}
+
+// GetLastBalanceSnapshot returns the latest snapshot taken on or before the
+// given date.
+func GetLastBalanceSnapshot(bankdb *gorm.DB, trackId string, onOrBefore string) (BalanceSnapshot, error) {
+	var snapshot BalanceSnapshot
+	result := bankdb.Where("track_id = ? AND date <= ?", trackId, onOrBefore).Order("date desc").First(&snapshot)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return BalanceSnapshot{}, errors.New("no records found")
+	}
+	return snapshot, nil
+}
+
+func GetStatement(bankdb *gorm.DB, trackId string, month string) (Statement, error) {
+	var statement Statement
+	result := bankdb.Where("track_id = ? AND month = ?", trackId, month).First(&statement)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return Statement{}, errors.New("no records found")
+	}
+	return statement, nil
+}
+
+func SaveStatement(bankdb *gorm.DB, statement Statement) error {
+	if err := bankdb.Clauses(clause.OnConflict{DoNothing: true}).Create(&statement).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while saving statement in db: %v", err.Error())
+	}
+	return nil
+}
+
+// SyncedTransactionRows opens a cursor over a bank's synced transactions in
+// a date range, ordered by date, with MirrorsTransfer set on the bank's legs
+// of our own transfers. Callers scan each row with ScanRows and must close
+// the rows.
+func SyncedTransactionRows(bankdb *gorm.DB, accountId string, startDate string, endDate string) (*sql.Rows, error) {
+	rows, err := bankdb.Table("synced_transactions s").
+		Select("s.*, ("+mirroredTransferSql+") AS mirrors_transfer", sql.Named("unsettled", UnsettledTransferStatuses)).
+		Where("s.account_id = ? AND s.date >= ? AND s.date <= ?", accountId, startDate, endDate).
+		Order("s.date, s.transaction_id").Rows()
+	if err != nil {
+		log.Println("Error: ", err)
+		return nil, fmt.Errorf("error while reading synced transactions: %v", err.Error())
+	}
+	return rows, nil
+}
+
+// TransferRows opens a cursor over the transfers sent or received by a bank
+// with transaction ids in [fromId, toId), ordered by transaction id. Ids embed
+// their creation time, so the bounds select a time range.
+func TransferRows(bankdb *gorm.DB, trackId string, fromId string, toId string) (*sql.Rows, error) {
+	rows, err := bankdb.Model(&Transaction{}).
+		Where("(sender_bank_id = ? OR receiver_bank_id = ?) AND transaction_id >= ? AND transaction_id < ?", trackId, trackId, fromId, toId).
+		Order("transaction_id").Rows()
+	if err != nil {
+		log.Println("Error: ", err)
+		return nil, fmt.Errorf("error while reading transactions: %v", err.Error())
+	}
+	return rows, nil
+}
+
+func AddBankIdentity(bankdb *gorm.DB, identity BankIdentity) error {
+	if err := bankdb.Create(&identity).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while adding bank identity in db: %v", err.Error())
+	}
+	return nil
+}
+
+func AddAuthChallenge(bankdb *gorm.DB, challenge AuthChallenge) error {
+	if err := bankdb.Create(&challenge).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while adding auth challenge in db: %v", err.Error())
+	}
+	return nil
+}
+
+// AttemptAuthChallenge counts an attempt against an unused, unexpired
+// challenge with attempts left and returns it. It returns false when the
+// challenge cannot be attempted.
+func AttemptAuthChallenge(bankdb *gorm.DB, challengeId string, maxAttempts int, now time.Time) (AuthChallenge, bool, error) {
+	var challenges []AuthChallenge
+	result := bankdb.Model(&challenges).Clauses(clause.Returning{}).
+		Where("challenge_id = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", challengeId, now, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return AuthChallenge{}, false, fmt.Errorf("error while attempting auth challenge: %v", result.Error.Error())
+	}
+	if len(challenges) == 0 {
+		return AuthChallenge{}, false, nil
+	}
+	return challenges[0], true, nil
+}
+
+// UseAuthChallenge marks a challenge used so its code works only once.
+func UseAuthChallenge(bankdb *gorm.DB, challengeId string, now time.Time) (bool, error) {
+	result := bankdb.Model(&AuthChallenge{}).
+		Where("challenge_id = ? AND used_at IS NULL", challengeId).
+		Update("used_at", &now)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return false, fmt.Errorf("error while using auth challenge: %v", result.Error.Error())
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func AddAccountNumberAccess(bankdb *gorm.DB, access AccountNumberAccess) error {
+	if err := bankdb.Create(&access).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while adding account number access in db: %v", err.Error())
+	}
+	return nil
+}
+
+func GetAccountNumberAccessesUsingUserId(bankdb *gorm.DB, userId string) ([]AccountNumberAccess, error) {
+	var accesses []AccountNumberAccess
+	result := bankdb.Where("user_id = ?", userId).Order("created_at DESC").Find(&accesses)
+	if result.Error != nil {
+		log.Println("Error: ", result.Error)
+		return nil, fmt.Errorf("error while fetching account number accesses: %v", result.Error.Error())
+	}
+	return accesses, nil
+}
+
+func GetRecordsUsingItemId(bankdb *gorm.DB, itemId string) ([]PlaidUser, error) {
+	var users []PlaidUser
+	if err := bankdb.Where("bank_id = ?", itemId).Find(&users).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, errors.New("no records found")
+	}
+	return users, nil
+}
+
+// ReplaceLiabilities swaps the stored liabilities of a linked item for the
+// latest ones, so accounts the item no longer reports are dropped.
+func ReplaceLiabilities(bankdb *gorm.DB, trackId string, liabilities []Liability) error {
+	return bankdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("track_id = ?", trackId).Delete(&Liability{}).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while clearing liabilities: %v", err.Error())
+		}
+		if len(liabilities) == 0 {
+			return nil
+		}
+		if err := tx.Create(&liabilities).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while saving liabilities in db: %v", err.Error())
+		}
+		return nil
+	})
+}
+
+func GetLiabilitiesUsingUserId(bankdb *gorm.DB, userId string) ([]Liability, error) {
+	var liabilities []Liability
+	if err := bankdb.Where("user_id = ?", userId).Order("kind, name").Find(&liabilities).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, errors.New("no records found")
+	}
+	return liabilities, nil
+}
+
+// ReplaceInvestmentHoldings swaps the stored holdings of a linked item for
+// the latest ones, so sold positions are dropped.
+func ReplaceInvestmentHoldings(bankdb *gorm.DB, trackId string, holdings []InvestmentHolding) error {
+	return bankdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("track_id = ?", trackId).Delete(&InvestmentHolding{}).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while clearing investment holdings: %v", err.Error())
+		}
+		if len(holdings) == 0 {
+			return nil
+		}
+		if err := tx.Create(&holdings).Error; err != nil {
+			log.Println(err.Error())
+			return fmt.Errorf("error while saving investment holdings in db: %v", err.Error())
+		}
+		return nil
+	})
+}
+
+func GetInvestmentHoldingsUsingUserId(bankdb *gorm.DB, userId string, trackId string) ([]InvestmentHolding, error) {
+	var holdings []InvestmentHolding
+	query := bankdb.Where("user_id = ?", userId)
+	if len(trackId) > 0 {
+		query = query.Where("track_id = ?", trackId)
+	}
+	if err := query.Order("institution_value DESC").Find(&holdings).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, errors.New("no records found")
+	}
+	return holdings, nil
+}
+
+func UpsertInvestmentTransactions(bankdb *gorm.DB, transactions []InvestmentTransaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+	if err := bankdb.Clauses(clause.OnConflict{UpdateAll: true}).Create(&transactions).Error; err != nil {
+		log.Println(err.Error())
+		return fmt.Errorf("error while saving investment transactions in db: %v", err.Error())
+	}
+	return nil
+}
+
+func CountInvestmentTransactions(bankdb *gorm.DB, trackId string) (int64, error) {
+	var count int64
+	if err := bankdb.Model(&InvestmentTransaction{}).Where("track_id = ?", trackId).Count(&count).Error; err != nil {
+		log.Println("Error: ", err)
+		return 0, fmt.Errorf("error while counting investment transactions: %v", err.Error())
+	}
+	return count, nil
+}
+
+func GetInvestmentTransactionsUsingUserId(bankdb *gorm.DB, userId string, trackId string, startDate string, endDate string) ([]InvestmentTransaction, error) {
+	var transactions []InvestmentTransaction
+	query := bankdb.Where("user_id = ? AND date >= ? AND date <= ?", userId, startDate, endDate)
+	if len(trackId) > 0 {
+		query = query.Where("track_id = ?", trackId)
+	}
+	if err := query.Order("date DESC, investment_transaction_id").Find(&transactions).Error; err != nil {
+		log.Println("Error: ", err)
+		return nil, errors.New("no records found")
+	}
+	return transactions, nil
+}
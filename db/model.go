@@ -1,5 +1,7 @@
 package db
 
+import "time"
+
 // type SignUpForm struct {
 // 	Email       string `json:"email"`
 // 	Password    string `json:"password"`
@@ -37,8 +39,25 @@ type PlaidUser struct {
 	BankId           string `gorm:"not null"`
 	AccessToken      string `gorm:"not null"`
 	FundingSourceUrl string `gorm:"not null"`
+	FundingSourceId  string `gorm:"index"`
+	DwollaCustomerId string `gorm:"index"`
 	ShareableId      string `gorm:"not null"`
 	UserId           string `gorm:"not null"`
+	SyncCursor       string
+	ReauthAt         *time.Time
+	// Banks added with routing and account numbers have no Plaid item; their
+	// funding source is verified with micro-deposits.
+	Source             string
+	VerificationStatus string
+	BankName           string
+	AccountMask        string
+	AccountType        string
+	// IdentityStatus is how well the account holder Plaid reports matched the
+	// user when the bank was linked.
+	IdentityStatus string
+	// LinkPurpose is the product set the item was linked with. Only funding
+	// links have a Dwolla funding source; empty means funding.
+	LinkPurpose string
 }
 
 func (PlaidUser) TableName() string {
@@ -46,21 +65,604 @@ func (PlaidUser) TableName() string {
 }
 
 type Transaction struct {
-	TransactionId  string `gorm:"primaryKey"`
-	Name           string `gorm:"not null"`
-	Amount         string `gorm:"not null"`
-	Channel        string `gorm:"not null"`
-	Category       string `gorm:"not null"`
-	SenderId       string `gorm:"not null"`
-	ReceiverId     string `gorm:"not null"`
-	SenderBankId   string `gorm:"not null"`
-	ReceiverBankId string `gorm:"not null"`
+	TransactionId    string `gorm:"primaryKey"`
+	Name             string `gorm:"not null"`
+	Amount           string `gorm:"not null"`
+	Channel          string `gorm:"not null"`
+	Category         string `gorm:"not null"`
+	SenderId         string `gorm:"not null"`
+	ReceiverId       string `gorm:"not null"`
+	SenderBankId     string `gorm:"not null"`
+	ReceiverBankId   string `gorm:"not null"`
+	DwollaTransferId string `gorm:"index"`
+	Status           string
+	// Dwolla side of the transfer, kept so a row can be matched and refreshed
+	// without rebuilding URLs.
+	DwollaTransferUrl     string
+	SourceFundingSourceId string `gorm:"index"`
+	DestFundingSourceId   string `gorm:"index"`
+	InitiatedAt           *time.Time
+	StatusUpdatedAt       *time.Time
+	ProcessedAt           *time.Time
+	FailedAt              *time.Time
+	FailureReason         string
+	RequestedClearing     string
+	ActualClearing        string
+	CorrelationId         string `gorm:"index"`
+	FeeAmount             string
 }
 
 func (Transaction) TableName() string {
 	return "transactions"
 }
 
+type SyncedTransaction struct {
+	TransactionId    string  `gorm:"primaryKey"`
+	AccountId        string  `gorm:"not null;index"`
+	TrackId          string  `gorm:"not null;index"`
+	UserId           string  `gorm:"not null;index"`
+	Name             string  `gorm:"not null"`
+	Amount           float64 `gorm:"type:numeric(14,2);not null"`
+	Date             string  `gorm:"not null;index"`
+	PaymentChannel   string  `gorm:"not null"`
+	Pending          bool    `gorm:"not null"`
+	CategoryPrimary  string
+	CategoryDetailed string
+	MerchantName     string
+	LogoUrl          string
+	Website          string
+	Counterparties   string
+	// MirrorsTransfer is only read, by SyncedTransactionRows.
+	MirrorsTransfer bool `gorm:"->;-:migration"`
+}
+
+func (SyncedTransaction) TableName() string {
+	return "synced_transactions"
+}
+
+type CategoryOverride struct {
+	TransactionId string    `gorm:"primaryKey"`
+	UserId        string    `gorm:"primaryKey"`
+	Category      string    `gorm:"not null"`
+	UpdatedAt     time.Time `gorm:"not null"`
+}
+
+func (CategoryOverride) TableName() string {
+	return "category_overrides"
+}
+
+type Budget struct {
+	BudgetId    string    `gorm:"primaryKey"`
+	UserId      string    `gorm:"not null;index"`
+	Category    string    `gorm:"not null"`
+	LimitAmount float64   `gorm:"type:numeric(14,2);not null"`
+	Thresholds  string    `gorm:"not null"`
+	Active      bool      `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"not null"`
+	UpdatedAt   time.Time `gorm:"not null"`
+}
+
+func (Budget) TableName() string {
+	return "budgets"
+}
+
+type BudgetAlert struct {
+	BudgetId    string    `gorm:"primaryKey"`
+	Period      string    `gorm:"primaryKey"`
+	Threshold   int       `gorm:"primaryKey"`
+	UserId      string    `gorm:"not null;index"`
+	Category    string    `gorm:"not null"`
+	Spend       float64   `gorm:"type:numeric(14,2);not null"`
+	LimitAmount float64   `gorm:"type:numeric(14,2);not null"`
+	CreatedAt   time.Time `gorm:"not null"`
+}
+
+func (BudgetAlert) TableName() string {
+	return "budget_alerts"
+}
+
+type RecurringStream struct {
+	StreamId      string `gorm:"primaryKey"`
+	UserId        string `gorm:"not null;index"`
+	TrackId       string `gorm:"not null;index"`
+	AccountId     string `gorm:"not null"`
+	Source        string `gorm:"not null"`
+	Direction     string `gorm:"not null"`
+	Description   string `gorm:"not null"`
+	MerchantName  string
+	Category      string
+	Frequency     string  `gorm:"not null"`
+	AverageAmount float64 `gorm:"type:numeric(14,2);not null"`
+	LastAmount    float64 `gorm:"type:numeric(14,2);not null"`
+	FirstDate     string  `gorm:"not null"`
+	LastDate      string  `gorm:"not null"`
+	NextDate      string
+	Status        string    `gorm:"not null"`
+	IsActive      bool      `gorm:"not null"`
+	Occurrences   int       `gorm:"not null"`
+	UpdatedAt     time.Time `gorm:"not null"`
+}
+
+func (RecurringStream) TableName() string {
+	return "recurring_streams"
+}
+
+type ScheduledTransfer struct {
+	ScheduleId          string `gorm:"primaryKey"`
+	UserId              string `gorm:"not null;index"`
+	SenderTrackId       string `gorm:"not null"`
+	ReceiverShareableId string `gorm:"not null"`
+	Name                string `gorm:"not null"`
+	Email               string
+	Amount              string `gorm:"not null"`
+	Frequency           string `gorm:"not null"`
+	DayOfMonth          int
+	StartDate           string `gorm:"not null"`
+	EndDate             string
+	NextRunDate         string    `gorm:"index"`
+	Status              string    `gorm:"not null;index"`
+	CreatedAt           time.Time `gorm:"not null"`
+	UpdatedAt           time.Time `gorm:"not null"`
+}
+
+func (ScheduledTransfer) TableName() string {
+	return "scheduled_transfers"
+}
+
+type ScheduledTransferRun struct {
+	RunId          string `gorm:"primaryKey"`
+	ScheduleId     string `gorm:"not null;uniqueIndex:idx_schedule_occurrence"`
+	OccurrenceDate string `gorm:"not null;uniqueIndex:idx_schedule_occurrence"`
+	Status         string `gorm:"not null"`
+	TransactionId  string
+	FailureReason  string
+	StartedAt      time.Time `gorm:"not null"`
+	FinishedAt     *time.Time
+}
+
+func (ScheduledTransferRun) TableName() string {
+	return "scheduled_transfer_runs"
+}
+
+type WorkerLease struct {
+	Name      string    `gorm:"primaryKey"`
+	Holder    string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func (WorkerLease) TableName() string {
+	return "worker_leases"
+}
+
+type TransferLimit struct {
+	UserId         string    `gorm:"primaryKey"`
+	MaxPerTransfer float64   `gorm:"type:numeric(14,2);not null"`
+	DailyLimit     float64   `gorm:"type:numeric(14,2);not null"`
+	WeeklyLimit    float64   `gorm:"type:numeric(14,2);not null"`
+	DailyCount     int       `gorm:"not null"`
+	UpdatedAt      time.Time `gorm:"not null"`
+}
+
+func (TransferLimit) TableName() string {
+	return "transfer_limits"
+}
+
+type RiskDecision struct {
+	DecisionId          string `gorm:"primaryKey"`
+	UserId              string `gorm:"not null;index"`
+	SenderTrackId       string `gorm:"not null"`
+	ReceiverTrackId     string `gorm:"not null"`
+	ReceiverShareableId string `gorm:"not null"`
+	Name                string `gorm:"not null"`
+	Email               string
+	Amount              string `gorm:"not null"`
+	Clearing            string
+	Metadata            string
+	CorrelationId       string
+	Score               int    `gorm:"not null"`
+	Decision            string `gorm:"not null"`
+	Signals             string `gorm:"not null"`
+	ReviewStatus        string `gorm:"not null;index"`
+	TransactionId       string
+	// ScheduledRunId links a held scheduled transfer to the run waiting on
+	// the review.
+	ScheduledRunId string `gorm:"index"`
+	ReviewedBy     string
+	ReviewNote     string
+	CreatedAt      time.Time `gorm:"not null"`
+	ReviewedAt     *time.Time
+}
+
+func (RiskDecision) TableName() string {
+	return "risk_decisions"
+}
+
+type LedgerJournal struct {
+	JournalId     string    `gorm:"primaryKey"`
+	TransactionId string    `gorm:"not null;uniqueIndex:idx_journal_txn_entry"`
+	EntryType     string    `gorm:"not null;uniqueIndex:idx_journal_txn_entry"`
+	Description   string    `gorm:"not null"`
+	CreatedAt     time.Time `gorm:"not null"`
+}
+
+func (LedgerJournal) TableName() string {
+	return "ledger_journals"
+}
+
+type LedgerPosting struct {
+	PostingId     string    `gorm:"primaryKey"`
+	JournalId     string    `gorm:"not null;index"`
+	LedgerAccount string    `gorm:"not null;index"`
+	Amount        float64   `gorm:"type:numeric(14,2);not null"`
+	State         string    `gorm:"not null"`
+	CreatedAt     time.Time `gorm:"not null"`
+}
+
+func (LedgerPosting) TableName() string {
+	return "ledger_postings"
+}
+
+type LedgerBalance struct {
+	LedgerAccount  string  `json:"ledgerAccount"`
+	Balance        float64 `json:"balance"`
+	PendingBalance float64 `json:"pendingBalance"`
+}
+
+type UnbalancedJournal struct {
+	JournalId string  `json:"journalId"`
+	Total     float64 `json:"total"`
+}
+
+type ReconciliationReport struct {
+	ReportId   string    `gorm:"primaryKey" json:"reportId"`
+	StartDate  string    `gorm:"not null" json:"startDate"`
+	EndDate    string    `gorm:"not null" json:"endDate"`
+	AutoRepair bool      `gorm:"not null" json:"autoRepair"`
+	Matched    int       `gorm:"not null" json:"matched"`
+	Missing    int       `gorm:"not null" json:"missing"`
+	Orphaned   int       `gorm:"not null" json:"orphaned"`
+	Mismatched int       `gorm:"not null" json:"mismatched"`
+	Untracked  int       `gorm:"not null;default:0" json:"untracked"`
+	Repaired   int       `gorm:"not null" json:"repaired"`
+	CreatedAt  time.Time `gorm:"not null" json:"createdAt"`
+}
+
+func (ReconciliationReport) TableName() string {
+	return "reconciliation_reports"
+}
+
+type ReconciliationItem struct {
+	ItemId        string `gorm:"primaryKey" json:"itemId"`
+	ReportId      string `gorm:"not null;index" json:"reportId"`
+	Kind          string `gorm:"not null" json:"kind"`
+	TransferId    string `json:"transferId"`
+	TransactionId string `json:"transactionId"`
+	LocalStatus   string `json:"localStatus"`
+	DwollaStatus  string `json:"dwollaStatus"`
+	Amount        string `json:"amount"`
+	Repaired      bool   `gorm:"not null" json:"repaired"`
+	Note          string `json:"note"`
+}
+
+func (ReconciliationItem) TableName() string {
+	return "reconciliation_items"
+}
+
+type DwollaCustomer struct {
+	CustomerId  string    `gorm:"primaryKey" json:"customerId"`
+	UserId      string    `gorm:"not null;uniqueIndex" json:"userId"`
+	CustomerUrl string    `gorm:"not null" json:"customerUrl"`
+	Email       string    `gorm:"not null" json:"email"`
+	FirstName   string    `gorm:"not null" json:"firstName"`
+	LastName    string    `gorm:"not null" json:"lastName"`
+	Address1    string    `json:"-"`
+	PostalCode  string    `json:"-"`
+	Type        string    `gorm:"not null" json:"type"`
+	Status      string    `gorm:"not null" json:"status"`
+	CreatedAt   time.Time `gorm:"not null" json:"createdAt"`
+	UpdatedAt   time.Time `gorm:"not null" json:"updatedAt"`
+}
+
+func (DwollaCustomer) TableName() string {
+	return "dwolla_customers"
+}
+
+type TransferQuote struct {
+	QuoteId             string     `gorm:"primaryKey" json:"quoteId"`
+	UserId              string     `gorm:"not null;index" json:"userId"`
+	SenderTrackId       string     `gorm:"not null" json:"senderTrackId"`
+	ReceiverTrackId     string     `gorm:"not null" json:"-"`
+	ReceiverShareableId string     `gorm:"not null" json:"receiverShareableId"`
+	ReceiverName        string     `json:"receiverName"`
+	ReceiverMask        string     `json:"receiverMask"`
+	Name                string     `gorm:"not null" json:"name"`
+	Email               string     `json:"email"`
+	Amount              string     `gorm:"not null" json:"amount"`
+	Clearing            string     `gorm:"not null" json:"clearing"`
+	Metadata            string     `json:"-"`
+	CorrelationId       string     `json:"correlationId"`
+	FeeAmount           string     `json:"feeAmount"`
+	Total               string     `gorm:"not null" json:"total"`
+	Status              string     `gorm:"not null;index" json:"status"`
+	TransactionId       string     `json:"transactionId"`
+	DecisionId          string     `gorm:"index" json:"decisionId,omitempty"`
+	Note                string     `json:"note"`
+	ExpiresAt           time.Time  `gorm:"not null" json:"expiresAt"`
+	CreatedAt           time.Time  `gorm:"not null" json:"createdAt"`
+	ConfirmedAt         *time.Time `json:"confirmedAt"`
+}
+
+func (TransferQuote) TableName() string {
+	return "transfer_quotes"
+}
+
+type PaymentRequest struct {
+	RequestId            string     `gorm:"primaryKey" json:"requestId"`
+	RequesterId          string     `gorm:"not null;index" json:"requesterId"`
+	RequesterTrackId     string     `gorm:"not null" json:"requesterTrackId"`
+	RequesterShareableId string     `gorm:"not null" json:"-"`
+	PayerId              string     `gorm:"not null;index" json:"payerId"`
+	PayerShareableId     string     `gorm:"not null" json:"payerShareableId"`
+	BillId               string     `gorm:"index" json:"billId,omitempty"`
+	Amount               string     `gorm:"not null" json:"amount"`
+	Note                 string     `json:"note"`
+	Status               string     `gorm:"not null;index" json:"status"`
+	TransactionId        string     `json:"transactionId"`
+	DecisionId           string     `gorm:"index" json:"decisionId,omitempty"`
+	FailureReason        string     `json:"failureReason"`
+	CreatedAt            time.Time  `gorm:"not null" json:"createdAt"`
+	ExpiresAt            time.Time  `gorm:"not null;index" json:"expiresAt"`
+	RespondedAt          *time.Time `json:"respondedAt"`
+}
+
+func (PaymentRequest) TableName() string {
+	return "payment_requests"
+}
+
+type BillSplit struct {
+	BillId         string    `gorm:"primaryKey" json:"billId"`
+	CreatorId      string    `gorm:"not null;index" json:"creatorId"`
+	CreatorTrackId string    `gorm:"not null" json:"creatorTrackId"`
+	TotalAmount    string    `gorm:"not null" json:"totalAmount"`
+	Note           string    `json:"note"`
+	SplitType      string    `gorm:"not null" json:"splitType"`
+	CreatedAt      time.Time `gorm:"not null" json:"createdAt"`
+}
+
+func (BillSplit) TableName() string {
+	return "bill_splits"
+}
+
+// BillShare is one participant's part of a bill. RequestId is empty for the
+// creator's own share, which is never requested.
+type BillShare struct {
+	ShareId     string `gorm:"primaryKey" json:"shareId"`
+	BillId      string `gorm:"not null;index" json:"billId"`
+	UserId      string `gorm:"not null" json:"userId"`
+	ShareableId string `gorm:"not null" json:"sharableId"`
+	Amount      string `gorm:"not null" json:"amount"`
+	Percent     string `json:"percent,omitempty"`
+	RequestId   string `json:"requestId"`
+}
+
+func (BillShare) TableName() string {
+	return "bill_shares"
+}
+
+type Payee struct {
+	PayeeId         string    `gorm:"primaryKey" json:"payeeId"`
+	UserId          string    `gorm:"not null;uniqueIndex:idx_payee_user_receiver" json:"userId"`
+	ReceiverTrackId string    `gorm:"not null;uniqueIndex:idx_payee_user_receiver" json:"-"`
+	Nickname        string    `gorm:"not null" json:"nickname"`
+	ShareableId     string    `gorm:"not null" json:"sharableId"`
+	ReceiverName    string    `json:"receiverName"`
+	AccountMask     string    `json:"accountMask"`
+	InstitutionName string    `json:"institutionName"`
+	CreatedAt       time.Time `gorm:"not null" json:"createdAt"`
+	ActiveAt        time.Time `gorm:"not null" json:"activeAt"`
+}
+
+func (Payee) TableName() string {
+	return "payees"
+}
+
+// BalanceSnapshot is the last balance read for a bank on a day. Statements
+// take their opening and closing balances from these.
+type BalanceSnapshot struct {
+	TrackId          string    `gorm:"primaryKey"`
+	Date             string    `gorm:"primaryKey"`
+	UserId           string    `gorm:"not null;index"`
+	CurrentBalance   float64   `gorm:"type:numeric(14,2);not null"`
+	AvailableBalance *float64  `gorm:"type:numeric(14,2)"`
+	CapturedAt       time.Time `gorm:"not null"`
+}
+
+func (BalanceSnapshot) TableName() string {
+	return "balance_snapshots"
+}
+
+// Statement caches a generated monthly statement for a closed month.
+type Statement struct {
+	TrackId   string    `gorm:"primaryKey"`
+	Month     string    `gorm:"primaryKey"`
+	UserId    string    `gorm:"not null;index"`
+	Data      string    `gorm:"type:text;not null"`
+	Pdf       []byte    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+func (Statement) TableName() string {
+	return "statements"
+}
+
+// BankIdentity records an account holder check made when a bank was linked.
+// Names, emails, phones and addresses are stored only as keyed hashes.
+type BankIdentity struct {
+	IdentityId     string    `gorm:"primaryKey" json:"identityId"`
+	UserId         string    `gorm:"not null;index" json:"userId"`
+	ItemId         string    `gorm:"not null" json:"itemId"`
+	AccountId      string    `gorm:"not null;index" json:"-"`
+	TrackId        string    `gorm:"index" json:"plaidTrackId"`
+	NameHashes     string    `gorm:"type:text" json:"-"`
+	EmailHashes    string    `gorm:"type:text" json:"-"`
+	PhoneHashes    string    `gorm:"type:text" json:"-"`
+	AddressHashes  string    `gorm:"type:text" json:"-"`
+	NameScore      float64   `json:"nameScore"`
+	EmailMatched   bool      `json:"emailMatched"`
+	AddressMatched bool      `json:"addressMatched"`
+	Status         string    `gorm:"not null" json:"status"`
+	CheckedAt      time.Time `gorm:"not null" json:"checkedAt"`
+}
+
+func (BankIdentity) TableName() string {
+	return "bank_identities"
+}
+
+// AuthChallenge is a one-time code sent to a user before account numbers are
+// shown. Only a keyed hash of the code is stored.
+type AuthChallenge struct {
+	ChallengeId string     `gorm:"primaryKey" json:"challengeId"`
+	UserId      string     `gorm:"not null;index" json:"userId"`
+	TrackId     string     `gorm:"not null" json:"plaidTrackId"`
+	CodeHash    string     `gorm:"not null" json:"-"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	CreatedAt   time.Time  `gorm:"not null" json:"createdAt"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expiresAt"`
+	UsedAt      *time.Time `json:"usedAt"`
+}
+
+func (AuthChallenge) TableName() string {
+	return "auth_challenges"
+}
+
+// AccountNumberAccess audits one request for a bank's account and routing
+// numbers, whether or not it was granted. The numbers themselves are never
+// stored.
+type AccountNumberAccess struct {
+	AccessId    string    `gorm:"primaryKey" json:"accessId"`
+	UserId      string    `gorm:"not null;index" json:"userId"`
+	TrackId     string    `gorm:"not null;index" json:"plaidTrackId"`
+	ChallengeId string    `json:"challengeId"`
+	Action      string    `gorm:"not null" json:"action"`
+	Revealed    bool      `json:"revealed"`
+	Outcome     string    `gorm:"not null" json:"outcome"`
+	Reason      string    `json:"reason"`
+	ClientIp    string    `json:"clientIp"`
+	UserAgent   string    `json:"userAgent"`
+	CreatedAt   time.Time `gorm:"not null;index" json:"createdAt"`
+}
+
+func (AccountNumberAccess) TableName() string {
+	return "account_number_accesses"
+}
+
+// Liability is a credit card, student loan or mortgage reported by Plaid
+// Liabilities. MinimumPayment is the next monthly payment for mortgages and
+// InterestRate is the purchase APR for credit cards.
+type Liability struct {
+	AccountId            string    `gorm:"primaryKey" json:"accountId"`
+	TrackId              string    `gorm:"not null;index" json:"plaidTrackId"`
+	UserId               string    `gorm:"not null;index" json:"userId"`
+	Kind                 string    `gorm:"not null" json:"kind"`
+	Name                 string    `json:"name"`
+	Mask                 string    `json:"mask"`
+	CurrentBalance       float64   `gorm:"type:numeric(14,2)" json:"currentBalance"`
+	InterestRate         float64   `json:"interestRate"`
+	InterestRateType     string    `json:"interestRateType"`
+	Aprs                 string    `gorm:"type:text" json:"-"`
+	MinimumPayment       float64   `gorm:"type:numeric(14,2)" json:"minimumPayment"`
+	NextPaymentDueDate   string    `json:"nextPaymentDueDate"`
+	LastPaymentAmount    float64   `gorm:"type:numeric(14,2)" json:"lastPaymentAmount"`
+	LastPaymentDate      string    `json:"lastPaymentDate"`
+	LastStatementBalance float64   `gorm:"type:numeric(14,2)" json:"lastStatementBalance"`
+	IsOverdue            bool      `json:"isOverdue"`
+	PastDueAmount        float64   `gorm:"type:numeric(14,2)" json:"pastDueAmount"`
+	OriginationPrincipal float64   `gorm:"type:numeric(14,2)" json:"originationPrincipal"`
+	OriginationDate      string    `json:"originationDate"`
+	PayoffDate           string    `json:"payoffDate"`
+	Description          string    `json:"description"`
+	UpdatedAt            time.Time `gorm:"not null" json:"updatedAt"`
+}
+
+func (Liability) TableName() string {
+	return "liabilities"
+}
+
+// InvestmentHolding is a position in one security, with the security's name
+// and ticker copied from Plaid's securities list.
+type InvestmentHolding struct {
+	AccountId            string    `gorm:"primaryKey" json:"accountId"`
+	SecurityId           string    `gorm:"primaryKey" json:"securityId"`
+	TrackId              string    `gorm:"not null;index" json:"plaidTrackId"`
+	UserId               string    `gorm:"not null;index" json:"userId"`
+	AccountName          string    `json:"accountName"`
+	SecurityName         string    `json:"securityName"`
+	TickerSymbol         string    `json:"tickerSymbol"`
+	SecurityType         string    `json:"securityType"`
+	Quantity             float64   `json:"quantity"`
+	InstitutionPrice     float64   `json:"institutionPrice"`
+	InstitutionPriceAsOf string    `json:"institutionPriceAsOf"`
+	InstitutionValue     float64   `gorm:"type:numeric(14,2)" json:"institutionValue"`
+	CostBasis            *float64  `gorm:"type:numeric(14,2)" json:"costBasis"`
+	IsoCurrencyCode      string    `json:"isoCurrencyCode"`
+	UpdatedAt            time.Time `gorm:"not null" json:"updatedAt"`
+}
+
+func (InvestmentHolding) TableName() string {
+	return "investment_holdings"
+}
+
+type InvestmentTransaction struct {
+	InvestmentTransactionId string  `gorm:"primaryKey" json:"investmentTransactionId"`
+	AccountId               string  `gorm:"not null;index" json:"accountId"`
+	TrackId                 string  `gorm:"not null;index" json:"plaidTrackId"`
+	UserId                  string  `gorm:"not null;index" json:"userId"`
+	SecurityId              string  `json:"securityId"`
+	SecurityName            string  `json:"securityName"`
+	TickerSymbol            string  `json:"tickerSymbol"`
+	Date                    string  `gorm:"not null;index" json:"date"`
+	Name                    string  `gorm:"not null" json:"name"`
+	Type                    string  `gorm:"not null" json:"type"`
+	Subtype                 string  `json:"subtype"`
+	Quantity                float64 `json:"quantity"`
+	Price                   float64 `json:"price"`
+	Amount                  float64 `gorm:"type:numeric(14,2);not null" json:"amount"`
+	Fees                    float64 `gorm:"type:numeric(14,2)" json:"fees"`
+	IsoCurrencyCode         string  `json:"isoCurrencyCode"`
+}
+
+func (InvestmentTransaction) TableName() string {
+	return "investment_transactions"
+}
+
+type AnalyticsFilter struct {
+	UserId    string
+	TrackId   string
+	AccountId string
+	StartDate string
+	EndDate   string
+}
+
+type CategorySummary struct {
+	Category string  `json:"category"`
+	Spend    float64 `json:"spend"`
+	Income   float64 `json:"income"`
+	Count    int     `json:"count"`
+}
+
+type MerchantSummary struct {
+	Merchant string  `json:"merchant"`
+	LogoUrl  string  `json:"logoUrl"`
+	Spend    float64 `json:"spend"`
+	Count    int     `json:"count"`
+}
+
+type PeriodSummary struct {
+	Period string  `json:"period"`
+	Spend  float64 `json:"spend"`
+	Income float64 `json:"income"`
+}
+
 // func (s *SignUpForm) ConvertToUser() *BankUser {
 // 	return &BankUser{
 // 		Email:       s.Email,
@@ -5,6 +5,7 @@ go 1.23.0
 require (
 	github.com/gin-contrib/cors v1.7.3
 	github.com/gin-gonic/gin v1.10.0
+	github.com/go-pdf/fpdf v0.9.0
 	github.com/joho/godotenv v1.5.1
 	github.com/kolanos/dwolla-v2-go v1.0.0
 	github.com/plaid/plaid-go v1.10.0
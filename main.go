@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"log"
 	"os"
 
 	"github.com/gin-contrib/cors"
@@ -17,6 +19,16 @@ func init() {
 	api.DwollaKey = os.Getenv("DWOLLA_KEY")
 	api.DwollaSecret = os.Getenv("DWOLLA_SECRET")
 	api.DwollaBaseUrl = os.Getenv("DWOLLA_BASE_URL")
+	api.NotificationWebhookUrl = os.Getenv("NOTIFICATION_WEBHOOK_URL")
+	api.PlaidWebhookUrl = os.Getenv("PLAID_WEBHOOK_URL")
+	api.AdminApiKey = os.Getenv("ADMIN_API_KEY")
+	api.ReconciliationAutoRepair = os.Getenv("RECONCILE_AUTO_REPAIR") == "true"
+	api.LoadGlobalTransferLimit()
+	api.LoadFeeSchedule()
+	api.LoadPaymentRequestTTL()
+	api.LoadPayeeCoolingOff()
+	api.LoadIdentitySettings()
+	api.LoadLinkProductSets()
 	api.PgDb = db.ConnectToDB()
 	api.CreatePlaidConfig()
 	api.CreateDwollaClient()
@@ -24,6 +36,16 @@ func init() {
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := api.RunReconciliationCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	go api.StartScheduledTransferWorker(context.Background(), api.PgDb)
+	go api.StartReconciliationWorker(context.Background(), api.PgDb)
+
 	router := gin.Default()
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"https://www.bitsbank-project.site"},
@@ -36,8 +58,66 @@ func main() {
 	router.POST("/plaid/v1/token/create", api.GenerateLinkToken)
 	router.POST("/plaid/v1/token/exchange", api.GenerateAccessToken)
 	router.POST("/plaid/v1/dwolla/customer/create", api.CreateDwollaCustomerId)
+	router.POST("/plaid/v1/dwolla/customer", api.GetDwollaCustomer)
+	router.POST("/plaid/v1/dwolla/customer/retry", api.RetryDwollaCustomerVerification)
+	router.POST("/plaid/v1/dwolla/customer/document", api.UploadDwollaCustomerDocument)
+	router.POST("/plaid/v1/dwolla/funding-source/create", api.AddManualFundingSource)
+	router.POST("/plaid/v1/dwolla/funding-source/verify", api.VerifyManualFundingSource)
+	router.POST("/plaid/v1/dwolla/funding-source/micro-deposits/retry", api.RetryMicroDeposits)
 	router.POST("/plaid/v1/get/accounts", api.GetBankAccounts)
 	router.POST("/plaid/v1/get/account", api.GetBankAccount)
+	router.POST("/plaid/v1/statement", api.GetAccountStatement)
+	router.POST("/plaid/v1/auth/numbers/challenge", api.CreateAccountNumbersChallenge)
+	router.POST("/plaid/v1/auth/numbers", api.GetAccountNumbers)
+	router.POST("/plaid/v1/auth/numbers/audit", api.GetAccountNumberAccesses)
+	router.POST("/plaid/v1/export", api.ExportTransactions)
+	router.POST("/plaid/v1/liabilities", api.GetLiabilities)
+	router.POST("/plaid/v1/investments/holdings", api.GetInvestmentHoldings)
+	router.POST("/plaid/v1/investments/transactions", api.GetInvestmentTransactions)
+	router.POST("/plaid/v1/networth/refresh", api.RefreshNetWorth)
 	router.POST("/plaid/v1/dwolla/transfer", api.TransferPayment)
+	router.POST("/plaid/v1/dwolla/transfer/get", api.GetTransfer)
+	router.PUT("/plaid/v1/dwolla/transfer/cancel", api.CancelTransferPayment)
+	router.POST("/plaid/v1/dwolla/transfer/receipt", api.GetTransferReceipt)
+	router.POST("/plaid/v1/dwolla/transfer/fee", api.QuoteTransferFee)
+	router.POST("/plaid/v1/dwolla/transfer/quote", api.CreateTransferQuote)
+	router.POST("/plaid/v1/dwolla/transfer/confirm", api.ConfirmTransferQuote)
+	router.POST("/plaid/v1/request/create", api.CreatePaymentRequest)
+	router.POST("/plaid/v1/requests", api.ListPaymentRequests)
+	router.PUT("/plaid/v1/request/accept", api.AcceptPaymentRequest)
+	router.PUT("/plaid/v1/request/decline", api.DeclinePaymentRequest)
+	router.POST("/plaid/v1/bill/create", api.CreateBillSplit)
+	router.POST("/plaid/v1/bills", api.GetBillSplits)
+	router.POST("/plaid/v1/payee/create", api.CreatePayee)
+	router.POST("/plaid/v1/payees", api.GetPayees)
+	router.DELETE("/plaid/v1/payee/delete", api.DeletePayee)
+	router.POST("/plaid/v1/transaction/category", api.OverrideTransactionCategory)
+	router.POST("/plaid/v1/analytics/categories", api.GetCategoryAnalytics)
+	router.POST("/plaid/v1/analytics/merchants", api.GetMerchantAnalytics)
+	router.POST("/plaid/v1/analytics/monthly", api.GetMonthlyAnalytics)
+	router.POST("/plaid/v1/budget/create", api.CreateBudget)
+	router.POST("/plaid/v1/budgets", api.GetBudgets)
+	router.PUT("/plaid/v1/budget/update", api.UpdateBudget)
+	router.DELETE("/plaid/v1/budget/delete", api.DeleteBudget)
+	router.POST("/plaid/v1/recurring", api.GetRecurringTransactionStreams)
+	router.POST("/plaid/v1/dwolla/schedule/create", api.CreateScheduledTransfer)
+	router.POST("/plaid/v1/dwolla/schedules", api.GetScheduledTransfers)
+	router.POST("/plaid/v1/dwolla/schedule/runs", api.GetScheduledTransferRuns)
+	router.PUT("/plaid/v1/dwolla/schedule/pause", api.PauseScheduledTransfer)
+	router.PUT("/plaid/v1/dwolla/schedule/resume", api.ResumeScheduledTransfer)
+	router.PUT("/plaid/v1/dwolla/schedule/cancel", api.CancelScheduledTransfer)
+	router.POST("/plaid/v1/dwolla/limits", api.GetTransferLimits)
+	router.POST("/plaid/v1/webhook", api.HandlePlaidWebhook)
+	router.POST("/plaid/v1/ledger/balance", api.GetLedgerBalance)
+
+	admin := router.Group("/plaid/v1/admin", api.RequireAdminKey())
+	admin.POST("/risk/held", api.GetHeldTransfers)
+	admin.POST("/risk/approve", api.ApproveHeldTransfer)
+	admin.POST("/risk/reject", api.RejectHeldTransfer)
+	admin.POST("/ledger/check", api.CheckLedger)
+	admin.POST("/ledger/backfill", api.BackfillLedger)
+	admin.POST("/reconcile", api.ReconcileTransfers)
+	admin.PUT("/limits", api.SetTransferLimits)
+	admin.POST("/customers/adopt", api.AdoptDwollaCustomer)
 	router.Run(":8090")
 }
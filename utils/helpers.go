@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"time"
 
@@ -52,3 +53,24 @@ func ExtractTimeStamp(value string) string {
 	formattedDate := date.Format("2006-01-02")
 	return formattedDate
 }
+
+// GenerateId builds a record id from a prefix and the current timestamp, with a
+// random suffix so ids created within the same second do not collide.
+func GenerateId(prefix string) string {
+	return GenerateIdAt(prefix, time.Now())
+}
+
+// GenerateIdAt is GenerateId for a record created at the given time, used when
+// importing records that happened earlier.
+func GenerateIdAt(prefix string, at time.Time) string {
+	return fmt.Sprintf("%s%v%04d", prefix, at.Format("20060102150405"), rand.Intn(10000))
+}
+
+// ExtractTrackIdTime returns the link time encoded in a Plaid track id, which
+// is PLAID, three letters of the first name and a yyyymmddhhmmss timestamp.
+func ExtractTrackIdTime(trackId string) (time.Time, error) {
+	if len(trackId) < 22 {
+		return time.Time{}, fmt.Errorf("track id %s has no timestamp", trackId)
+	}
+	return time.ParseInLocation("20060102150405", trackId[8:22], time.Local)
+}